@@ -0,0 +1,187 @@
+package ldapserver
+
+import (
+	"context"
+	"crypto/tls"
+	"strings"
+	"time"
+)
+
+// State of an operation recorded for the access log and metrics
+type operationRecord struct {
+	// Sequence number of the operation on the connection
+	op uint64
+	// Type of the request
+	opType BerType
+	// Time the request was received
+	start time.Time
+	// Number of search result entries and references sent
+	entries    int
+	references int
+}
+
+// Names of the search scopes used in the access log
+var searchScopeNames = map[SearchScope]string{
+	SearchScopeBaseObject:         "base",
+	SearchScopeSingleLevel:        "one",
+	SearchScopeWholeSubtree:       "sub",
+	SearchScopeSubordinateSubtree: "subordinate",
+}
+
+// Log an access log record for the connection, if the access log is enabled
+func (c *Conn) logAccess(msg string, attrs ...any) {
+	if c.accessLog == nil {
+		return
+	}
+	c.accessLog.Info(msg, attrs...)
+}
+
+// Log a request and start recording the operation until its final response is sent
+func (c *Conn) recordRequest(msg *Message, name string, attrs ...any) {
+	if c.accessLog == nil && c.metrics == nil {
+		return
+	}
+	c.recordsLock.Lock()
+	c.lastOp++
+	record := &operationRecord{op: c.lastOp, opType: msg.ProtocolOp.Type, start: time.Now()}
+	if c.records == nil {
+		c.records = make(map[MessageID]*operationRecord)
+	}
+	c.records[msg.MessageID] = record
+	c.recordsLock.Unlock()
+	c.logAccess(name, append([]any{"op", record.op, "msgid", msg.MessageID}, attrs...)...)
+}
+
+// Log a request that has no response
+func (c *Conn) logAccessNoResponse(msg *Message, name string, attrs ...any) {
+	if c.accessLog == nil {
+		return
+	}
+	c.recordsLock.Lock()
+	c.lastOp++
+	op := c.lastOp
+	c.recordsLock.Unlock()
+	c.accessLog.Info(name, append([]any{"op", op, "msgid", msg.MessageID}, attrs...)...)
+}
+
+// Record a response sent to the client, logging the final response of an operation
+func (c *Conn) recordResponse(messageID MessageID, rtype BerType, res Encodable) {
+	if c.accessLog == nil && c.metrics == nil {
+		return
+	}
+	if messageID == 0 {
+		attrs := []any{}
+		if r, ok := res.(*ExtendedResult); ok {
+			attrs = append(attrs, "oid", r.ResponseName, "result_code", r.ResultCode)
+		}
+		c.logAccess("NOTICE", attrs...)
+		return
+	}
+	c.recordsLock.Lock()
+	record := c.records[messageID]
+	if record == nil {
+		c.recordsLock.Unlock()
+		return
+	}
+	switch rtype {
+	case TypeSearchResultEntryOp:
+		record.entries++
+		c.recordsLock.Unlock()
+		return
+	case TypeSearchResultReferenceOp:
+		record.references++
+		c.recordsLock.Unlock()
+		return
+	case TypeIntermediateResponseOp:
+		c.recordsLock.Unlock()
+		return
+	}
+	delete(c.records, messageID)
+	c.recordsLock.Unlock()
+	elapsed := time.Since(record.start)
+	code, hasCode := resultCodeOf(res)
+	if c.metrics != nil && hasCode {
+		c.metrics.OperationCompleted(record.opType, code, elapsed)
+	}
+	if c.accessLog == nil {
+		return
+	}
+	attrs := []any{"op", record.op, "msgid", messageID, "tag", OperationName(rtype)}
+	if hasCode {
+		attrs = append(attrs, "result_code", code)
+	}
+	if rtype == TypeSearchResultDoneOp {
+		attrs = append(attrs, "entries", record.entries, "references", record.references)
+	}
+	attrs = append(attrs, "elapsed", elapsed)
+	c.accessLog.Info("RESULT", attrs...)
+}
+
+// Log the end of an operation for which no final response was sent, e.g. because it was abandoned
+func (c *Conn) recordEnd(ctx context.Context, messageID MessageID) {
+	if c.accessLog == nil && c.metrics == nil {
+		return
+	}
+	c.recordsLock.Lock()
+	record := c.records[messageID]
+	delete(c.records, messageID)
+	c.recordsLock.Unlock()
+	if record == nil || c.accessLog == nil {
+		return
+	}
+	attrs := []any{"op", record.op, "msgid", messageID}
+	if ctx.Err() != nil {
+		attrs = append(attrs, "cancelled", true)
+	}
+	attrs = append(attrs, "elapsed", time.Since(record.start))
+	c.accessLog.Info("NO RESULT", attrs...)
+}
+
+// Log the establishment of TLS on the connection
+func (c *Conn) logAccessTLS(state tls.ConnectionState) {
+	c.logAccess("TLS", "version", tls.VersionName(state.Version), "cipher", tls.CipherSuiteName(state.CipherSuite))
+}
+
+// Returns the access log attributes of a Bind request
+func bindAccessAttrs(req *BindRequest) []any {
+	switch req.AuthType {
+	case AuthenticationTypeSimple:
+		return []any{"dn", req.Name, "method", "simple"}
+	case AuthenticationTypeSASL:
+		mechanism := ""
+		if creds, ok := req.Credentials.(*SASLCredentials); ok {
+			mechanism = creds.Mechanism
+		}
+		return []any{"dn", req.Name, "method", "sasl", "mech", mechanism}
+	}
+	return []any{"dn", req.Name, "method", req.AuthType}
+}
+
+// Returns the access log attributes of a Search request
+func searchAccessAttrs(req *SearchRequest) []any {
+	scope, ok := searchScopeNames[req.Scope]
+	if !ok {
+		scope = "unknown"
+	}
+	filter := ""
+	if req.Filter != nil {
+		filter = req.Filter.String()
+	}
+	return []any{
+		"base", req.BaseObject,
+		"scope", scope,
+		"filter", filter,
+		"attrs", strings.Join(req.Attributes, ","),
+		"sizelimit", req.SizeLimit,
+		"timelimit", req.TimeLimit,
+	}
+}
+
+// Returns the access log attributes of a Modify request
+func modifyAccessAttrs(req *ModifyRequest) []any {
+	attrs := make([]string, len(req.Changes))
+	for i, change := range req.Changes {
+		attrs[i] = change.Modification.Description
+	}
+	return []any{"dn", req.Object, "attrs", strings.Join(attrs, ",")}
+}
@@ -1,5 +1,7 @@
 package ldapserver
 
+import "bytes"
+
 // AddRequest ::= [APPLICATION 8] SEQUENCE {
 //		entry           LDAPDN,
 //		attributes      AttributeList }
@@ -46,3 +48,15 @@ func GetAddRequest(data []byte) (*AddRequest, error) {
 	}
 	return req, nil
 }
+
+// Return the BER-encoded struct (without element header)
+func (r *AddRequest) Encode() []byte {
+	b := bytes.NewBuffer(nil)
+	b.Write(BerEncodeOctetString(r.Entry))
+	ab := bytes.NewBuffer(nil)
+	for _, attr := range r.Attributes {
+		ab.Write(BerEncodeSequence(attr.Encode()))
+	}
+	b.Write(BerEncodeSequence(ab.Bytes()))
+	return b.Bytes()
+}
@@ -91,3 +91,11 @@ func (a *Attribute) Encode() []byte {
 	b.Write(BerEncodeSet(vb.Bytes()))
 	return b.Bytes()
 }
+
+// Return the BER-encoded struct (without element header)
+func (a *AttributeValueAssertion) Encode() []byte {
+	b := bytes.NewBuffer(nil)
+	b.Write(BerEncodeOctetString(a.Description))
+	b.Write(BerEncodeOctetString(a.Value))
+	return b.Bytes()
+}
@@ -109,8 +109,18 @@ func BerReadSize(r io.Reader) (uint32, error) {
 	return res, nil
 }
 
+// Size up to which element data is allocated before it is read
+const berAllocChunkSize = 64 * 1024
+
 // Read a raw element from the io.Reader
 func BerReadElement(r io.Reader) (elmt BerRawElement, err error) {
+	return berReadElement(r, 0)
+}
+
+// Read a raw element from the io.Reader,
+// returning ErrLimitExceeded without reading the data if it is longer than maxSize.
+// A maxSize of 0 means no limit.
+func berReadElement(r io.Reader, maxSize uint32) (elmt BerRawElement, err error) {
 	// First byte is type code
 	tp, err := readByte(r)
 	if err != nil {
@@ -122,13 +132,30 @@ func BerReadElement(r io.Reader) (elmt BerRawElement, err error) {
 	if err != nil {
 		return
 	}
+	if maxSize > 0 && length > maxSize {
+		err = ErrLimitExceeded.WithInfo("element size", length)
+		return
+	}
 	// Data is the next bytes with given length
-	buf := make([]byte, length)
-	_, err = io.ReadFull(r, buf)
+	if length <= berAllocChunkSize {
+		buf := make([]byte, length)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return
+		}
+		elmt.Data = buf
+		return
+	}
+	// Don't trust the length for the allocation, grow the buffer as the data arrives
+	buf := bytes.NewBuffer(make([]byte, 0, berAllocChunkSize))
+	_, err = io.CopyN(buf, r, int64(length))
 	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 		return
 	}
-	elmt.Data = buf
+	elmt.Data = buf.Bytes()
 	return
 }
 
@@ -195,13 +222,11 @@ func BerEncodeBoolean(b bool) []byte {
 // Return a BER-encoded integer without an element header
 func BerEncodeIntegerRaw(i int64) []byte {
 	numBytes := 1
-	for i > 127 {
+	for n := i; n > 127; n >>= 8 {
 		numBytes++
-		i >>= 8
 	}
-	for i < -128 {
+	for n := i; n < -128; n >>= 8 {
 		numBytes++
-		i >>= 8
 	}
 	out := make([]byte, numBytes)
 	var j int
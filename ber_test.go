@@ -5,6 +5,8 @@ import (
 	"bytes"
 	"encoding/hex"
 	"errors"
+	"io"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -133,6 +135,11 @@ func TestBerReadElement(t *testing.T) {
 			t.Fatal("invalid encoding")
 		}
 	}
+	// The data is not allocated up front from a length sent by the client
+	_, err := ldapserver.BerReadElement(bytes.NewReader([]byte{0x04, 0x84, 0xff, 0xff, 0xff, 0xff, 0x00}))
+	if err != io.ErrUnexpectedEOF {
+		t.Fatal("Expected io.ErrUnexpectedEOF, got error", err)
+	}
 }
 
 func TestBerBoolean(t *testing.T) {
@@ -173,6 +180,34 @@ func TestBerInteger(t *testing.T) {
 	}
 }
 
+func TestBerEncodeInteger(t *testing.T) {
+	type integerTest struct {
+		value int64
+		repr  []byte
+	}
+	for _, it := range []integerTest{
+		{0, []byte{0x00}},
+		{50, []byte{0x32}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x00, 0x80}},
+		{1000, []byte{0x03, 0xe8}},
+		{50000, []byte{0x00, 0xc3, 0x50}},
+		{2147483647, []byte{0x7f, 0xff, 0xff, 0xff}},
+		{-1, []byte{0xff}},
+		{-128, []byte{0x80}},
+		{-129, []byte{0xff, 0x7f}},
+		{-12345, []byte{0xcf, 0xc7}},
+	} {
+		repr := ldapserver.BerEncodeIntegerRaw(it.value)
+		if !bytes.Equal(repr, it.repr) {
+			t.Fatalf("BerEncodeIntegerRaw(%d) = %x, want %x", it.value, repr, it.repr)
+		}
+		if getIntegerSimple(repr, it.value) != it.value {
+			t.Fatalf("integer %d did not round-trip", it.value)
+		}
+	}
+}
+
 func TestBerOctetString(t *testing.T) {
 	if ldapserver.BerGetOctetString([]byte{}) != "" {
 		t.Fatal("invalid octet string read")
@@ -815,7 +850,7 @@ func TestParseModifyDNRenameRequest(t *testing.T) {
 	if req.DeleteOldRDN != true {
 		t.Fatal("wrong delete old RDN")
 	}
-	if req.NewSuperior != "" {
+	if req.NewSuperior != "" || req.HasNewSuperior {
 		t.Fatal("wrong new superior")
 	}
 }
@@ -1348,3 +1383,235 @@ func TestSearchResultEntryEncode(t *testing.T) {
 		println(hex.Dump(encoded))
 	}
 }
+
+func TestEncodeRequests(t *testing.T) {
+	filter, err := ldapserver.ParseFilter("(&(objectClass=person)(|(uid=a*b*c)(!(cn:dn:caseExactMatch:=x))(sn>=m)))")
+	if err != nil {
+		t.Fatal("Error parsing filter:", err)
+	}
+	search := &ldapserver.SearchRequest{
+		BaseObject:   "dc=example,dc=com",
+		Scope:        ldapserver.SearchScopeWholeSubtree,
+		DerefAliases: ldapserver.AliasDerefAlways,
+		SizeLimit:    1000,
+		TimeLimit:    30,
+		TypesOnly:    true,
+		Filter:       filter,
+		Attributes:   []string{"uid", "cn"},
+	}
+	decodedSearch, err := ldapserver.GetSearchRequest(search.Encode())
+	if err != nil {
+		t.Fatal("Error decoding SearchRequest:", err)
+	}
+	if decodedSearch.Filter.String() != filter.String() {
+		t.Fatal("wrong filter", decodedSearch.Filter)
+	}
+	decodedSearch.Filter = filter
+	if !reflect.DeepEqual(search, decodedSearch) {
+		t.Fatalf("wrong SearchRequest %+v", decodedSearch)
+	}
+
+	add := &ldapserver.AddRequest{
+		Entry: "uid=jdoe,ou=People,dc=example,dc=com",
+		Attributes: []ldapserver.Attribute{
+			{Description: "objectClass", Values: []string{"top", "person"}},
+			{Description: "uid", Values: []string{"jdoe"}},
+		},
+	}
+	decodedAdd, err := ldapserver.GetAddRequest(add.Encode())
+	if err != nil {
+		t.Fatal("Error decoding AddRequest:", err)
+	}
+	if !reflect.DeepEqual(add, decodedAdd) {
+		t.Fatalf("wrong AddRequest %+v", decodedAdd)
+	}
+
+	modify := &ldapserver.ModifyRequest{
+		Object: "uid=jdoe,ou=People,dc=example,dc=com",
+		Changes: []ldapserver.ModifyChange{
+			{Operation: ldapserver.ModifyDelete, Modification: ldapserver.Attribute{Description: "givenName", Values: []string{"John"}}},
+			{Operation: ldapserver.ModifyAdd, Modification: ldapserver.Attribute{Description: "givenName", Values: []string{"Jonathan"}}},
+			{Operation: ldapserver.ModifyReplace, Modification: ldapserver.Attribute{Description: "cn", Values: []string{"Jonathan Doe"}}},
+		},
+	}
+	decodedModify, err := ldapserver.GetModifyRequest(modify.Encode())
+	if err != nil {
+		t.Fatal("Error decoding ModifyRequest:", err)
+	}
+	if !reflect.DeepEqual(modify, decodedModify) {
+		t.Fatalf("wrong ModifyRequest %+v", decodedModify)
+	}
+
+	for _, modifyDN := range []*ldapserver.ModifyDNRequest{
+		{Object: "uid=jdoe,ou=People,dc=example,dc=com", NewRDN: "uid=john.doe", DeleteOldRDN: true},
+		{Object: "uid=jdoe,ou=People,dc=example,dc=com", NewRDN: "uid=jdoe", NewSuperior: "ou=Users,dc=example,dc=com", HasNewSuperior: true},
+		{Object: "dc=example,dc=com", NewRDN: "dc=example", HasNewSuperior: true},
+	} {
+		decodedModifyDN, err := ldapserver.GetModifyDNRequest(modifyDN.Encode())
+		if err != nil {
+			t.Fatal("Error decoding ModifyDNRequest:", err)
+		}
+		if !reflect.DeepEqual(modifyDN, decodedModifyDN) {
+			t.Fatalf("wrong ModifyDNRequest %+v", decodedModifyDN)
+		}
+	}
+
+	compare := &ldapserver.CompareRequest{Object: "uid=jdoe,ou=People,dc=example,dc=com", Attribute: "employeeType", Value: "salaried"}
+	decodedCompare, err := ldapserver.GetCompareRequest(compare.Encode())
+	if err != nil {
+		t.Fatal("Error decoding CompareRequest:", err)
+	}
+	if !reflect.DeepEqual(compare, decodedCompare) {
+		t.Fatalf("wrong CompareRequest %+v", decodedCompare)
+	}
+
+	for _, bind := range []*ldapserver.BindRequest{
+		{Version: 3, Name: "", AuthType: ldapserver.AuthenticationTypeSimple, Credentials: ""},
+		{Version: 3, Name: "uid=jdoe,ou=People,dc=example,dc=com", AuthType: ldapserver.AuthenticationTypeSimple, Credentials: "secret123"},
+		{Version: 3, Name: "", AuthType: ldapserver.AuthenticationTypeSASL, Credentials: &ldapserver.SASLCredentials{Mechanism: "CRAM-MD5"}},
+		{Version: 3, Name: "", AuthType: ldapserver.AuthenticationTypeSASL, Credentials: &ldapserver.SASLCredentials{Mechanism: "CRAM-MD5", Credentials: "u:jdoe d52116c87c31d9cc747600f9486d2a1d"}},
+	} {
+		decodedBind, err := ldapserver.GetBindRequest(bind.Encode())
+		if err != nil {
+			t.Fatal("Error decoding BindRequest:", err)
+		}
+		if !reflect.DeepEqual(bind, decodedBind) {
+			t.Fatalf("wrong BindRequest %+v", decodedBind)
+		}
+	}
+
+	for _, extended := range []*ldapserver.ExtendedRequest{
+		{Name: ldapserver.OIDStartTLS},
+		{Name: ldapserver.OIDPasswordModify, Value: "\x30\x00"},
+	} {
+		decodedExtended, err := ldapserver.GetExtendedRequest(extended.Encode())
+		if err != nil {
+			t.Fatal("Error decoding ExtendedRequest:", err)
+		}
+		if !reflect.DeepEqual(extended, decodedExtended) {
+			t.Fatalf("wrong ExtendedRequest %+v", decodedExtended)
+		}
+	}
+
+	msg := &ldapserver.Message{
+		MessageID: 5,
+		Controls: []ldapserver.Control{
+			{OID: "1.2.840.113556.1.4.319", Criticality: true, ControlValue: "\x30\x05\x02\x01\x0a\x04\x00"},
+			{OID: "1.3.6.1.4.1.4203.1.10.1"},
+		},
+	}
+	msg.ProtocolOp.Type = ldapserver.TypeDeleteRequestOp
+	msg.ProtocolOp.Data = []byte("dc=example,dc=com")
+	decodedMsg, err := ldapserver.ReadLDAPMessage(bytes.NewReader(msg.EncodeWithHeader()))
+	if err != nil {
+		t.Fatal("Error decoding LDAPMessage:", err)
+	}
+	if !reflect.DeepEqual(msg, decodedMsg) {
+		t.Fatalf("wrong LDAPMessage %+v", decodedMsg)
+	}
+}
+
+func TestDecodeResponses(t *testing.T) {
+	result := &ldapserver.Result{
+		ResultCode:        ldapserver.ResultReferral,
+		MatchedDN:         "dc=example,dc=com",
+		DiagnosticMessage: "see elsewhere",
+		Referral:          []string{"ldap://a.example.com/", "ldap://b.example.com/"},
+	}
+	decodedResult, err := ldapserver.GetResult(result.Encode())
+	if err != nil {
+		t.Fatal("Error decoding LDAPResult:", err)
+	}
+	if !reflect.DeepEqual(result, decodedResult) {
+		t.Fatalf("wrong LDAPResult %+v", decodedResult)
+	}
+
+	bind := &ldapserver.BindResult{
+		Result:                ldapserver.Result{ResultCode: ldapserver.ResultSaslBindInProgress},
+		ServerSASLCredentials: "challenge",
+	}
+	decodedBind, err := ldapserver.GetBindResult(bind.Encode())
+	if err != nil {
+		t.Fatal("Error decoding BindResponse:", err)
+	}
+	if !reflect.DeepEqual(bind, decodedBind) {
+		t.Fatalf("wrong BindResponse %+v", decodedBind)
+	}
+
+	extended := &ldapserver.ExtendedResult{
+		Result:        ldapserver.Result{ResultCode: ldapserver.ResultSuccess},
+		ResponseName:  ldapserver.OIDPasswordModify,
+		ResponseValue: "\x30\x00",
+	}
+	decodedExtended, err := ldapserver.GetExtendedResult(extended.Encode())
+	if err != nil {
+		t.Fatal("Error decoding ExtendedResponse:", err)
+	}
+	if !reflect.DeepEqual(extended, decodedExtended) {
+		t.Fatalf("wrong ExtendedResponse %+v", decodedExtended)
+	}
+
+	entry := &ldapserver.SearchResultEntry{
+		ObjectName: "dc=example,dc=com",
+		Attributes: []ldapserver.Attribute{
+			{Description: "objectClass", Values: []string{"top", "domain"}},
+			{Description: "dc", Values: []string{"example"}},
+		},
+	}
+	decodedEntry, err := ldapserver.GetSearchResultEntry(entry.Encode())
+	if err != nil {
+		t.Fatal("Error decoding SearchResultEntry:", err)
+	}
+	if !reflect.DeepEqual(entry, decodedEntry) {
+		t.Fatalf("wrong SearchResultEntry %+v", decodedEntry)
+	}
+
+	ref := ldapserver.SearchResultReference{"ldap://a.example.com/dc=example,dc=com"}
+	decodedRef, err := ldapserver.GetSearchResultReference(ref.Encode())
+	if err != nil {
+		t.Fatal("Error decoding SearchResultReference:", err)
+	}
+	if !reflect.DeepEqual(ref, decodedRef) {
+		t.Fatalf("wrong SearchResultReference %+v", decodedRef)
+	}
+
+	for _, intermediate := range []*ldapserver.IntermediateResponse{
+		{},
+		{Name: "1.3.6.1.4.1.4203.1.9.1.4"},
+		{Value: "value"},
+		{Name: "1.3.6.1.4.1.4203.1.9.1.4", Value: "value"},
+	} {
+		decodedIntermediate, err := ldapserver.GetIntermediateResponse(intermediate.Encode())
+		if err != nil {
+			t.Fatal("Error decoding IntermediateResponse:", err)
+		}
+		if !reflect.DeepEqual(intermediate, decodedIntermediate) {
+			t.Fatalf("wrong IntermediateResponse %+v", decodedIntermediate)
+		}
+	}
+
+	for _, invalid := range []struct {
+		name   string
+		decode func([]byte) error
+		data   []byte
+	}{
+		{"LDAPResult with trailing element", func(b []byte) error { _, err := ldapserver.GetResult(b); return err },
+			[]byte{0x0a, 0x01, 0x00, 0x04, 0x00, 0x04, 0x00, 0x04, 0x00}},
+		{"LDAPResult with empty referral", func(b []byte) error { _, err := ldapserver.GetResult(b); return err },
+			[]byte{0x0a, 0x01, 0x0a, 0x04, 0x00, 0x04, 0x00, 0xa3, 0x00}},
+		{"BindResponse with wrong credentials tag", func(b []byte) error { _, err := ldapserver.GetBindResult(b); return err },
+			[]byte{0x0a, 0x01, 0x00, 0x04, 0x00, 0x04, 0x00, 0x88, 0x00}},
+		{"ExtendedResponse with invalid name", func(b []byte) error { _, err := ldapserver.GetExtendedResult(b); return err },
+			[]byte{0x0a, 0x01, 0x00, 0x04, 0x00, 0x04, 0x00, 0x8a, 0x01, 0x78}},
+		{"SearchResultEntry without attributes", func(b []byte) error { _, err := ldapserver.GetSearchResultEntry(b); return err },
+			[]byte{0x04, 0x00}},
+		{"empty SearchResultReference", func(b []byte) error { _, err := ldapserver.GetSearchResultReference(b); return err },
+			[]byte{}},
+		{"IntermediateResponse with elements out of order", func(b []byte) error { _, err := ldapserver.GetIntermediateResponse(b); return err },
+			[]byte{0x81, 0x00, 0x80, 0x03, 0x31, 0x2e, 0x32}},
+	} {
+		if err := invalid.decode(invalid.data); err == nil {
+			t.Error("expected error decoding", invalid.name)
+		}
+	}
+}
@@ -113,12 +113,55 @@ func GetBindRequest(data []byte) (*BindRequest, error) {
 	return req, nil
 }
 
+// Return a BindResult from BER-encoded data
+func GetBindResult(data []byte) (*BindResult, error) {
+	seq, err := BerGetSequence(data)
+	if err != nil {
+		return nil, err
+	}
+	res, rest, err := getResultComponents(seq, "BindResponse")
+	if err != nil {
+		return nil, err
+	}
+	r := &BindResult{Result: *res}
+	// serverSaslCreds [7] OCTET STRING OPTIONAL
+	if len(rest) > 0 && rest[0].Type == BerContextSpecificType(7, false) {
+		r.ServerSASLCredentials = BerGetOctetString(rest[0].Data)
+		rest = rest[1:]
+	}
+	if len(rest) != 0 {
+		return nil, ErrWrongElementType.WithInfo("BindResponse element type", rest[0].Type)
+	}
+	return r, nil
+}
+
 // Returns the BER-encoded struct (without element header)
 func (r *BindResult) Encode() []byte {
 	if r.ServerSASLCredentials == "" {
 		return r.Result.Encode()
 	}
 	b := bytes.NewBuffer(r.Result.Encode())
-	b.Write(BerEncodeElement(BerContextSpecificType(7, false), BerEncodeOctetString(r.ServerSASLCredentials)))
+	b.Write(BerEncodeElement(BerContextSpecificType(7, false), []byte(r.ServerSASLCredentials)))
+	return b.Bytes()
+}
+
+// Return the BER-encoded struct (without element header)
+func (r *BindRequest) Encode() []byte {
+	b := bytes.NewBuffer(nil)
+	b.Write(BerEncodeInteger(int64(r.Version)))
+	b.Write(BerEncodeOctetString(r.Name))
+	switch creds := r.Credentials.(type) {
+	case string:
+		b.Write(BerEncodeElement(BerContextSpecificType(uint8(r.AuthType), false), []byte(creds)))
+	case *SASLCredentials:
+		sb := bytes.NewBuffer(nil)
+		sb.Write(BerEncodeOctetString(creds.Mechanism))
+		if creds.Credentials != "" {
+			sb.Write(BerEncodeOctetString(creds.Credentials))
+		}
+		b.Write(BerEncodeElement(BerContextSpecificType(uint8(r.AuthType), true), sb.Bytes()))
+	default:
+		b.Write(BerEncodeElement(BerContextSpecificType(uint8(r.AuthType), false), nil))
+	}
 	return b.Bytes()
 }
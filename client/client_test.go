@@ -0,0 +1,164 @@
+package client_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/merlinz01/ldapserver"
+	"github.com/merlinz01/ldapserver/client"
+)
+
+// Start a server for the handler and return a client connected to it
+func startTestServer(t *testing.T, handler ldapserver.Handler) *client.Conn {
+	t.Helper()
+	server := ldapserver.NewLDAPServer(handler)
+	server.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	if err := server.SetupTLS("../test/cert.pem", "../test/privkey.pem"); err != nil {
+		t.Fatal("Error setting up TLS:", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error listening:", err)
+	}
+	go server.Serve(listener)
+	conn, err := client.Dial("ldap://"+listener.Addr().String(), nil)
+	if err != nil {
+		t.Fatal("Error dialing:", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		server.Shutdown(context.Background())
+	})
+	return conn
+}
+
+// Returns the result code of an error returned by the client
+func resultCode(t *testing.T, err error) ldapserver.LDAPResultCode {
+	t.Helper()
+	var res *ldapserver.Result
+	if !errors.As(err, &res) {
+		t.Fatal("expected a result error, got", err)
+	}
+	return res.ResultCode
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	handler := ldapserver.NewMemoryHandler()
+	err := handler.AddEntry(ldapserver.NewEntry(ldapserver.MustParseDN("dc=example,dc=com"),
+		ldapserver.Attribute{Description: "objectClass", Values: []string{"domain"}},
+		ldapserver.Attribute{Description: "dc", Values: []string{"example"}},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := startTestServer(t, handler)
+
+	if code := resultCode(t, conn.Bind(ctx, "cn=admin", "secret")); code != ldapserver.ResultUnwillingToPerform {
+		t.Fatal("wrong bind result", code)
+	}
+
+	err = conn.StartTLS(ctx, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal("Error starting TLS:", err)
+	}
+	if !conn.IsTLS() {
+		t.Fatal("TLS not set up")
+	}
+	if err = conn.StartTLS(ctx, nil); err != ldapserver.ErrTLSAlreadySetUp {
+		t.Fatal("expected ErrTLSAlreadySetUp, got", err)
+	}
+
+	add := &ldapserver.AddRequest{
+		Entry: "cn=alice,dc=example,dc=com",
+		Attributes: []ldapserver.Attribute{
+			{Description: "objectClass", Values: []string{"person"}},
+			{Description: "cn", Values: []string{"alice"}},
+			{Description: "sn", Values: []string{"Smith"}},
+		},
+	}
+	if err = conn.Add(ctx, add); err != nil {
+		t.Fatal("Error adding:", err)
+	}
+	err = conn.Add(ctx, add)
+	if code := resultCode(t, err); code != ldapserver.ResultEntryAlreadyExists {
+		t.Fatal("wrong add result", code)
+	}
+
+	err = conn.Modify(ctx, &ldapserver.ModifyRequest{
+		Object: "cn=alice,dc=example,dc=com",
+		Changes: []ldapserver.ModifyChange{{
+			Operation:    ldapserver.ModifyReplace,
+			Modification: ldapserver.Attribute{Description: "sn", Values: []string{"Jones"}},
+		}},
+	})
+	if err != nil {
+		t.Fatal("Error modifying:", err)
+	}
+
+	match, err := conn.Compare(ctx, &ldapserver.CompareRequest{
+		Object: "cn=alice,dc=example,dc=com", Attribute: "sn", Value: "jones"})
+	if err != nil || !match {
+		t.Fatal("wrong compare result", match, err)
+	}
+	match, err = conn.Compare(ctx, &ldapserver.CompareRequest{
+		Object: "cn=alice,dc=example,dc=com", Attribute: "sn", Value: "smith"})
+	if err != nil || match {
+		t.Fatal("wrong compare result", match, err)
+	}
+
+	err = conn.ModifyDN(ctx, &ldapserver.ModifyDNRequest{
+		Object: "cn=alice,dc=example,dc=com", NewRDN: "cn=alicia", DeleteOldRDN: true})
+	if err != nil {
+		t.Fatal("Error renaming:", err)
+	}
+
+	res, err := conn.Search(ctx, &ldapserver.SearchRequest{
+		BaseObject: "dc=example,dc=com",
+		Scope:      ldapserver.SearchScopeWholeSubtree,
+		Filter:     ldapserver.MustParseFilter("(sn=jones)"),
+		Attributes: []string{"cn"},
+	})
+	if err != nil {
+		t.Fatal("Error searching:", err)
+	}
+	if len(res.Entries) != 1 || res.Entries[0].ObjectName != "cn=alicia,dc=example,dc=com" {
+		t.Fatal("wrong search entries", res.Entries)
+	}
+	if len(res.Entries[0].Attributes) != 1 || res.Entries[0].Attributes[0].Values[0] != "alicia" {
+		t.Fatal("wrong search attributes", res.Entries[0].Attributes)
+	}
+
+	_, err = conn.Search(ctx, &ldapserver.SearchRequest{BaseObject: "dc=missing,dc=com"})
+	if code := resultCode(t, err); code != ldapserver.ResultNoSuchObject {
+		t.Fatal("wrong search result", code)
+	}
+
+	if err = conn.Delete(ctx, "cn=alicia,dc=example,dc=com"); err != nil {
+		t.Fatal("Error deleting:", err)
+	}
+	if handler.GetEntry(ldapserver.MustParseDN("cn=alicia,dc=example,dc=com")) != nil {
+		t.Fatal("entry not deleted")
+	}
+
+	_, err = conn.Extended(ctx, &ldapserver.ExtendedRequest{Name: "1.2.3.4"})
+	if code := resultCode(t, err); code != ldapserver.ResultProtocolError {
+		t.Fatal("wrong extended result", code)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err = conn.Delete(cancelled, "dc=example,dc=com"); err != context.Canceled {
+		t.Fatal("expected context.Canceled, got", err)
+	}
+
+	conn.Close()
+	if err = conn.Delete(ctx, "dc=example,dc=com"); err != client.ErrClosed {
+		t.Fatal("expected ErrClosed, got", err)
+	}
+}
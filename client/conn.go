@@ -0,0 +1,359 @@
+// Package client implements a LDAPv3 client using the message types and BER encoding of package ldapserver.
+//
+// Operations may be performed concurrently on a single connection;
+// responses are matched to their operations by message ID.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/url"
+	"sync"
+
+	"github.com/merlinz01/ldapserver"
+)
+
+// Errors returned by this package
+var (
+	ErrClosed             = errors.New("connection closed")
+	ErrUnsupportedScheme  = errors.New("unsupported URL scheme")
+	ErrUnexpectedResponse = errors.New("unexpected response type")
+)
+
+// maxInt INTEGER ::= 2147483647 -- (2^^31 - 1) --
+const maxMessageID = 2147483647
+
+// A client connection to a LDAP server
+type Conn struct {
+	// Underlying network connection, replaced when TLS is started
+	conn net.Conn
+	// Original network connection, used for closing
+	rawConn net.Conn
+	// Host name used to verify the server certificate
+	host string
+	// Mutex to synchronize message sending
+	sending sync.Mutex
+	// Mutex protecting the fields below
+	lock sync.Mutex
+	// Whether the connection has TLS set up
+	isTLS bool
+	// Last message ID used
+	lastID ldapserver.MessageID
+	// Operations waiting for responses
+	pending map[ldapserver.MessageID]*operation
+	// Reason the connection was closed
+	err error
+	// Closed when the connection is closed
+	done chan struct{}
+}
+
+// An operation waiting for responses
+type operation struct {
+	id ldapserver.MessageID
+	// Responses received for the operation
+	responses chan *ldapserver.Message
+	// Closed when the operation is finished or abandoned
+	finished   chan struct{}
+	finishOnce sync.Once
+	// If not nil, reading stops after the operation's response until this is closed
+	resume chan struct{}
+}
+
+// Connect to the LDAP server at the specified URL.
+// Supported URL schemes are ldap:// and ldaps://, with default ports 389 and 636.
+// The TLS config is used for ldaps:// connections and may be nil to use the defaults.
+func Dial(rawURL string, tlsConfig *tls.Config) (*Conn, error) {
+	return DialContext(context.Background(), rawURL, tlsConfig)
+}
+
+// Connect to the LDAP server at the specified URL using the context.
+// See Dial for details.
+func DialContext(ctx context.Context, rawURL string, tlsConfig *tls.Config) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	var port string
+	switch u.Scheme {
+	case "ldap":
+		port = "389"
+	case "ldaps":
+		port = "636"
+	default:
+		return nil, ErrUnsupportedScheme
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	host := u.Hostname()
+	dialer := net.Dialer{}
+	c, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "ldaps" {
+		tlsConn := tls.Client(c, clientTLSConfig(tlsConfig, host))
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			c.Close()
+			return nil, err
+		}
+		conn := newConn(tlsConn, c, host)
+		conn.isTLS = true
+		go conn.readLoop()
+		return conn, nil
+	}
+	conn := newConn(c, c, host)
+	go conn.readLoop()
+	return conn, nil
+}
+
+// Create a client connection using an existing network connection.
+func NewConn(c net.Conn) *Conn {
+	host, _, _ := net.SplitHostPort(c.RemoteAddr().String())
+	conn := newConn(c, c, host)
+	_, conn.isTLS = c.(*tls.Conn)
+	go conn.readLoop()
+	return conn
+}
+
+func newConn(c net.Conn, rawConn net.Conn, host string) *Conn {
+	return &Conn{
+		conn:    c,
+		rawConn: rawConn,
+		host:    host,
+		pending: make(map[ldapserver.MessageID]*operation),
+		done:    make(chan struct{}),
+	}
+}
+
+// Returns a copy of the TLS config with the server name set.
+func clientTLSConfig(config *tls.Config, host string) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	return config
+}
+
+// Returns whether the connection has TLS set up
+func (c *Conn) IsTLS() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.isTLS
+}
+
+// Sends an Unbind request and closes the connection.
+// Operations in progress return ErrClosed.
+func (c *Conn) Close() error {
+	c.lock.Lock()
+	closed := c.err != nil
+	c.lock.Unlock()
+	if !closed {
+		// Unbind has no response
+		op := c.newOperation(nil)
+		c.finish(op)
+		c.sendMessage(op.id, ldapserver.TypeUnbindRequestOp, nil, nil)
+	}
+	c.closeWithError(ErrClosed)
+	return nil
+}
+
+// Returns a channel that is closed when the connection is closed
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Returns the reason the connection was closed, or nil if it is open.
+// If the server sent a Notice of Disconnection, this is the *ldapserver.Result it contained.
+func (c *Conn) Err() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.err
+}
+
+// Close the connection, recording the reason
+func (c *Conn) closeWithError(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+	c.rawConn.Close()
+}
+
+// Read messages from the server and dispatch them to their operations
+func (c *Conn) readLoop() {
+	for {
+		msg, err := ldapserver.ReadLDAPMessage(c.conn)
+		if err != nil {
+			c.closeWithError(err)
+			return
+		}
+		if msg.MessageID == 0 {
+			c.handleNotification(msg)
+			continue
+		}
+		c.lock.Lock()
+		op := c.pending[msg.MessageID]
+		c.lock.Unlock()
+		if op == nil {
+			// Abandoned or unknown
+			continue
+		}
+		select {
+		case op.responses <- msg:
+		case <-op.finished:
+		}
+		if op.resume != nil && msg.ProtocolOp.Type == ldapserver.TypeExtendedResponseOp {
+			// Wait for TLS to be set up
+			select {
+			case <-op.resume:
+			case <-c.done:
+				return
+			}
+		}
+	}
+}
+
+// Handle an unsolicited notification
+func (c *Conn) handleNotification(msg *ldapserver.Message) {
+	if msg.ProtocolOp.Type != ldapserver.TypeExtendedResponseOp {
+		return
+	}
+	res, err := ldapserver.GetExtendedResult(msg.ProtocolOp.Data)
+	if err != nil {
+		c.closeWithError(err)
+		return
+	}
+	if res.ResponseName == ldapserver.OIDNoticeOfDisconnection {
+		c.closeWithError(&res.Result)
+	}
+}
+
+// Register a new operation with the next message ID.
+// If resume is not nil, reading stops after the operation's response until it is closed.
+func (c *Conn) newOperation(resume chan struct{}) *operation {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.lastID++
+	if c.lastID > maxMessageID {
+		c.lastID = 1
+	}
+	op := &operation{
+		id:        c.lastID,
+		responses: make(chan *ldapserver.Message, 16),
+		finished:  make(chan struct{}),
+		resume:    resume,
+	}
+	c.pending[op.id] = op
+	return op
+}
+
+// Unregister the operation
+func (c *Conn) finish(op *operation) {
+	op.finishOnce.Do(func() {
+		c.lock.Lock()
+		delete(c.pending, op.id)
+		c.lock.Unlock()
+		close(op.finished)
+	})
+}
+
+// Send a message without locking
+func (c *Conn) writeMessage(id ldapserver.MessageID, optype ldapserver.BerType, data []byte, controls []ldapserver.Control) error {
+	msg := ldapserver.Message{
+		MessageID: id,
+		Controls:  controls,
+	}
+	msg.ProtocolOp.Type = optype
+	msg.ProtocolOp.Data = data
+	_, err := c.conn.Write(msg.EncodeWithHeader())
+	return err
+}
+
+// Send a message
+func (c *Conn) sendMessage(id ldapserver.MessageID, optype ldapserver.BerType, data []byte, controls []ldapserver.Control) error {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	return c.writeMessage(id, optype, data, controls)
+}
+
+// Send a request and register an operation for its responses.
+// The caller must call c.finish() on the operation when done.
+func (c *Conn) start(ctx context.Context, optype ldapserver.BerType, data []byte, controls []ldapserver.Control) (*operation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+	op := c.newOperation(nil)
+	if err := c.sendMessage(op.id, optype, data, controls); err != nil {
+		c.finish(op)
+		return nil, err
+	}
+	return op, nil
+}
+
+// Wait for the next response of the operation.
+// If the context is done first, the operation is abandoned.
+func (c *Conn) next(ctx context.Context, op *operation) (*ldapserver.Message, error) {
+	select {
+	case msg := <-op.responses:
+		return msg, nil
+	case <-c.done:
+		// Deliver a response that arrived before the connection was closed
+		select {
+		case msg := <-op.responses:
+			return msg, nil
+		default:
+		}
+		return nil, c.Err()
+	case <-ctx.Done():
+		c.abandon(op)
+		return nil, ctx.Err()
+	}
+}
+
+// Send an Abandon request for the operation and stop waiting for its responses
+func (c *Conn) abandon(op *operation) {
+	c.finish(op)
+	if op.resume != nil {
+		// StartTLS cannot be abandoned, and the sending lock is already held
+		return
+	}
+	abandonOp := c.newOperation(nil)
+	c.finish(abandonOp)
+	c.sendMessage(abandonOp.id, ldapserver.TypeAbandonRequestOp, ldapserver.BerEncodeIntegerRaw(int64(op.id)), nil)
+}
+
+// Send a request and wait for the final response of the expected type
+func (c *Conn) do(ctx context.Context, optype ldapserver.BerType, data []byte, controls []ldapserver.Control, restype ldapserver.BerType) (*ldapserver.Message, error) {
+	op, err := c.start(ctx, optype, data, controls)
+	if err != nil {
+		return nil, err
+	}
+	defer c.finish(op)
+	for {
+		msg, err := c.next(ctx, op)
+		if err != nil {
+			return nil, err
+		}
+		switch msg.ProtocolOp.Type {
+		case restype:
+			return msg, nil
+		case ldapserver.TypeIntermediateResponseOp:
+			continue
+		default:
+			return nil, ErrUnexpectedResponse
+		}
+	}
+}
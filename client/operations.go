@@ -0,0 +1,234 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+
+	"github.com/merlinz01/ldapserver"
+)
+
+// Returns the result as an error if it is not a success
+func resultError(res *ldapserver.Result) error {
+	if res.ResultCode == ldapserver.ResultSuccess {
+		return nil
+	}
+	return res
+}
+
+// Send a request and return the LDAPResult of its response as an error if it is not a success
+func (c *Conn) doResult(ctx context.Context, optype ldapserver.BerType, data []byte, controls []ldapserver.Control, restype ldapserver.BerType) error {
+	msg, err := c.do(ctx, optype, data, controls, restype)
+	if err != nil {
+		return err
+	}
+	res, err := ldapserver.GetResult(msg.ProtocolOp.Data)
+	if err != nil {
+		return err
+	}
+	return resultError(res)
+}
+
+// Perform a simple bind with the DN and password.
+// An empty name and password performs an anonymous bind.
+func (c *Conn) Bind(ctx context.Context, name string, password string, controls ...ldapserver.Control) error {
+	req := &ldapserver.BindRequest{
+		Version:     3,
+		Name:        name,
+		AuthType:    ldapserver.AuthenticationTypeSimple,
+		Credentials: password,
+	}
+	res, err := c.bind(ctx, req, controls)
+	if err != nil {
+		return err
+	}
+	return resultError(&res.Result)
+}
+
+// Perform one step of a SASL bind.
+// If the result code is saslBindInProgress, the bind should be continued
+// with the server's credentials in the result.
+// Other result codes except success are also returned as an error.
+func (c *Conn) SASLBind(ctx context.Context, name string, credentials *ldapserver.SASLCredentials, controls ...ldapserver.Control) (*ldapserver.BindResult, error) {
+	req := &ldapserver.BindRequest{
+		Version:     3,
+		Name:        name,
+		AuthType:    ldapserver.AuthenticationTypeSASL,
+		Credentials: credentials,
+	}
+	res, err := c.bind(ctx, req, controls)
+	if err != nil {
+		return nil, err
+	}
+	if res.ResultCode == ldapserver.ResultSaslBindInProgress {
+		return res, nil
+	}
+	return res, resultError(&res.Result)
+}
+
+func (c *Conn) bind(ctx context.Context, req *ldapserver.BindRequest, controls []ldapserver.Control) (*ldapserver.BindResult, error) {
+	msg, err := c.do(ctx, ldapserver.TypeBindRequestOp, req.Encode(), controls, ldapserver.TypeBindResponseOp)
+	if err != nil {
+		return nil, err
+	}
+	return ldapserver.GetBindResult(msg.ProtocolOp.Data)
+}
+
+// The results of a search
+type SearchResult struct {
+	Entries    []*ldapserver.SearchResultEntry
+	References []ldapserver.SearchResultReference
+	// Controls returned with the SearchResultDone message
+	Controls []ldapserver.Control
+}
+
+// Perform a search, collecting all the results.
+// If the search ends with a result code other than success,
+// the results received so far are returned along with the error.
+func (c *Conn) Search(ctx context.Context, req *ldapserver.SearchRequest, controls ...ldapserver.Control) (*SearchResult, error) {
+	op, err := c.start(ctx, ldapserver.TypeSearchRequestOp, req.Encode(), controls)
+	if err != nil {
+		return nil, err
+	}
+	defer c.finish(op)
+	result := &SearchResult{}
+	for {
+		msg, err := c.next(ctx, op)
+		if err != nil {
+			return result, err
+		}
+		switch msg.ProtocolOp.Type {
+		case ldapserver.TypeSearchResultEntryOp:
+			entry, err := ldapserver.GetSearchResultEntry(msg.ProtocolOp.Data)
+			if err != nil {
+				return result, err
+			}
+			result.Entries = append(result.Entries, entry)
+		case ldapserver.TypeSearchResultReferenceOp:
+			ref, err := ldapserver.GetSearchResultReference(msg.ProtocolOp.Data)
+			if err != nil {
+				return result, err
+			}
+			result.References = append(result.References, ref)
+		case ldapserver.TypeSearchResultDoneOp:
+			res, err := ldapserver.GetResult(msg.ProtocolOp.Data)
+			if err != nil {
+				return result, err
+			}
+			result.Controls = msg.Controls
+			return result, resultError(res)
+		case ldapserver.TypeIntermediateResponseOp:
+			continue
+		default:
+			return result, ErrUnexpectedResponse
+		}
+	}
+}
+
+// Add an entry
+func (c *Conn) Add(ctx context.Context, req *ldapserver.AddRequest, controls ...ldapserver.Control) error {
+	return c.doResult(ctx, ldapserver.TypeAddRequestOp, req.Encode(), controls, ldapserver.TypeAddResponseOp)
+}
+
+// Delete the entry with the specified DN
+func (c *Conn) Delete(ctx context.Context, dn string, controls ...ldapserver.Control) error {
+	return c.doResult(ctx, ldapserver.TypeDeleteRequestOp, []byte(dn), controls, ldapserver.TypeDeleteResponseOp)
+}
+
+// Modify an entry
+func (c *Conn) Modify(ctx context.Context, req *ldapserver.ModifyRequest, controls ...ldapserver.Control) error {
+	return c.doResult(ctx, ldapserver.TypeModifyRequestOp, req.Encode(), controls, ldapserver.TypeModifyResponseOp)
+}
+
+// Rename or move an entry
+func (c *Conn) ModifyDN(ctx context.Context, req *ldapserver.ModifyDNRequest, controls ...ldapserver.Control) error {
+	return c.doResult(ctx, ldapserver.TypeModifyDNRequestOp, req.Encode(), controls, ldapserver.TypeModifyDNResponseOp)
+}
+
+// Compare an attribute value of an entry.
+// Returns whether the value matched, or an error if the result code
+// is neither compareTrue nor compareFalse.
+func (c *Conn) Compare(ctx context.Context, req *ldapserver.CompareRequest, controls ...ldapserver.Control) (bool, error) {
+	msg, err := c.do(ctx, ldapserver.TypeCompareRequestOp, req.Encode(), controls, ldapserver.TypeCompareResponseOp)
+	if err != nil {
+		return false, err
+	}
+	res, err := ldapserver.GetResult(msg.ProtocolOp.Data)
+	if err != nil {
+		return false, err
+	}
+	switch res.ResultCode {
+	case ldapserver.ResultCompareTrue:
+		return true, nil
+	case ldapserver.ResultCompareFalse:
+		return false, nil
+	default:
+		return false, res
+	}
+}
+
+// Perform an extended operation.
+// If the result code is not success, the result is returned along with the error.
+func (c *Conn) Extended(ctx context.Context, req *ldapserver.ExtendedRequest, controls ...ldapserver.Control) (*ldapserver.ExtendedResult, error) {
+	msg, err := c.do(ctx, ldapserver.TypeExtendedRequestOp, req.Encode(), controls, ldapserver.TypeExtendedResponseOp)
+	if err != nil {
+		return nil, err
+	}
+	res, err := ldapserver.GetExtendedResult(msg.ProtocolOp.Data)
+	if err != nil {
+		return nil, err
+	}
+	return res, resultError(&res.Result)
+}
+
+// Perform the StartTLS extended operation and set up TLS on the connection.
+// The config may be nil to use the defaults.
+// If the config does not specify a server name, the host name from the dialed URL is used.
+//
+// No other requests are sent while TLS is being set up.
+// If TLS negotiation fails, the connection is closed.
+func (c *Conn) StartTLS(ctx context.Context, config *tls.Config) error {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	if c.IsTLS() {
+		return ldapserver.ErrTLSAlreadySetUp
+	}
+	if err := c.Err(); err != nil {
+		return err
+	}
+	resume := make(chan struct{})
+	defer close(resume)
+	op := c.newOperation(resume)
+	defer c.finish(op)
+	req := &ldapserver.ExtendedRequest{Name: ldapserver.OIDStartTLS}
+	if err := c.writeMessage(op.id, ldapserver.TypeExtendedRequestOp, req.Encode(), nil); err != nil {
+		return err
+	}
+	msg, err := c.next(ctx, op)
+	if err != nil {
+		// The state of the connection is unknown
+		c.closeWithError(err)
+		return err
+	}
+	if msg.ProtocolOp.Type != ldapserver.TypeExtendedResponseOp {
+		c.closeWithError(ErrUnexpectedResponse)
+		return ErrUnexpectedResponse
+	}
+	res, err := ldapserver.GetExtendedResult(msg.ProtocolOp.Data)
+	if err != nil {
+		c.closeWithError(err)
+		return err
+	}
+	if err = resultError(&res.Result); err != nil {
+		return err
+	}
+	tlsConn := tls.Client(c.conn, clientTLSConfig(config, c.host))
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		c.closeWithError(err)
+		return err
+	}
+	c.lock.Lock()
+	c.conn = tlsConn
+	c.isTLS = true
+	c.lock.Unlock()
+	return nil
+}
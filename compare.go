@@ -1,5 +1,7 @@
 package ldapserver
 
+import "bytes"
+
 // CompareRequest ::= [APPLICATION 14] SEQUENCE {
 // 	entry   LDAPDN,
 // 	ava     AttributeValueAssertion }
@@ -46,3 +48,14 @@ func GetCompareRequest(data []byte) (*CompareRequest, error) {
 	value := BerGetOctetString(ava_seq[1].Data)
 	return &CompareRequest{object, description, value}, nil
 }
+
+// Return the BER-encoded struct (without element header)
+func (r *CompareRequest) Encode() []byte {
+	b := bytes.NewBuffer(nil)
+	b.Write(BerEncodeOctetString(r.Object))
+	ava := bytes.NewBuffer(nil)
+	ava.Write(BerEncodeOctetString(r.Attribute))
+	ava.Write(BerEncodeOctetString(r.Value))
+	b.Write(BerEncodeSequence(ava.Bytes()))
+	return b.Bytes()
+}
@@ -2,21 +2,71 @@ package ldapserver
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"errors"
 	"io"
+	"log/slog"
 	"net"
+	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// Returned by ReadMessage when no request is received within the idle timeout
+var errIdleTimeout = errors.New("idle timeout exceeded")
+
 type Encodable interface {
 	Encode() []byte
 }
 
+// Function called with each response of an operation before it is sent.
+// The response is not sent if it returns false.
+type responseFilter func(controls []Control, rtype BerType, res Encodable) bool
+
 type Conn struct {
 	// Underlying network connection
 	conn net.Conn
+	// Network connection as accepted, wrapped by conn after StartTLS.
+	// Its deadlines can be set without holding tlsStarting.
+	rawConn net.Conn
+	// Connection ID, unique within the server
+	id uint64
+	// Logger with the connection attributes
+	logger *slog.Logger
+	// Access logger with the connection attributes, or nil if disabled
+	accessLog *slog.Logger
+	// Metrics collector, or nil if disabled
+	metrics Metrics
+	// Limits on received messages
+	limits *Limits
+	// Timeouts for waiting for a request, reading the rest of a request,
+	// writing a response, and the TLS handshake. Zero means no timeout.
+	idleTimeout         time.Duration
+	readTimeout         time.Duration
+	writeTimeout        time.Duration
+	tlsHandshakeTimeout time.Duration
+	// Function returning the Root DSE values of the server and its handler
+	rootDSE func() *RootDSE
+	// Schema published by the server, or nil
+	schema *Schema
+	// Request controls supported by the server and its handler
+	controls *ControlRegistry
+	// Handler of the server, for finding the handlers of extended operations
+	handler Handler
+	// Unfinished Search operations of the PagedResults middleware
+	pagedSearches pagedSearches
+	// Whether stopReading() has been called
+	readingStopped atomic.Bool
+	// Mutex protecting the operation records
+	recordsLock sync.Mutex
+	// Sequence number of the last operation received
+	lastOp uint64
+	// Operations awaiting their final response, for the access log and metrics
+	records map[MessageID]*operationRecord
 	// Flag to signal server to stop reading messages
-	closed bool
+	closed atomic.Bool
 	// Whether the underlying connection has TLS set up
 	isTLS bool
 	// TLS config for StartTLS connections
@@ -27,13 +77,38 @@ type Conn struct {
 	sending sync.Mutex
 	// Wait group to enable atomic Bind request processing
 	asyncOperations sync.WaitGroup
+	// Context cancelled when the connection is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Mutex protecting the operations map
+	operationsLock sync.Mutex
+	// Cancel functions of the operations in progress, keyed by message ID
+	operations map[MessageID]context.CancelFunc
+	// Functions deciding whether responses are sent, keyed by message ID,
+	// from the outermost to the innermost
+	responseFilters map[MessageID][]responseFilter
 	// User-defined authentication storage
 	Authentication any
-	// User-defined message storage to enable Abandon functionality.
+	// User-defined message storage.
 	// The Conn does not touch the cache.
+	//
+	// Deprecated: handlers receive a context that is cancelled when the operation is abandoned.
 	MessageCache map[MessageID]any
 }
 
+// Returns the ID of the connection, which is unique within the server
+func (c *Conn) ID() uint64 {
+	return c.id
+}
+
+// Returns the server's logger with the connection ID and remote address attributes
+func (c *Conn) Logger() *slog.Logger {
+	if c.logger == nil {
+		return slog.Default()
+	}
+	return c.logger
+}
+
 // Returns the local address of the connection
 func (c *Conn) LocalAddr() net.Addr {
 	return c.conn.LocalAddr()
@@ -50,9 +125,107 @@ func (c *Conn) IsTLS() bool {
 }
 
 // Closes the underlying connection and stops reading messages.
+// The contexts of all operations on the connection are cancelled.
 func (c *Conn) Close() {
 	c.conn.Close()
-	c.closed = true
+	c.closed.Store(true)
+	c.cancel()
+}
+
+// Interrupts reading so that no more requests are read from the connection.
+// This does not wait for a StartTLS handshake in progress, which fails instead.
+func (c *Conn) stopReading() {
+	c.readingStopped.Store(true)
+	c.rawConn.SetReadDeadline(time.Now())
+}
+
+// Sets the read deadline of the underlying connection unless stopReading() has been called
+func (c *Conn) setReadDeadline(t time.Time) {
+	c.conn.SetReadDeadline(t)
+	if c.readingStopped.Load() {
+		// Don't override the deadline set by stopReading()
+		c.conn.SetReadDeadline(time.Now())
+	}
+}
+
+// Returns whether any operations are in progress
+func (c *Conn) hasOperations() bool {
+	c.operationsLock.Lock()
+	defer c.operationsLock.Unlock()
+	return len(c.operations) > 0
+}
+
+// Returns a context for the operation with the specified message ID.
+// The context is cancelled when the operation is abandoned, when the connection is closed,
+// or after the time limit if it is not zero.
+// The returned function must be called when the operation is finished.
+func (c *Conn) startOperation(messageID MessageID, timeLimit time.Duration) (context.Context, func()) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeLimit > 0 {
+		ctx, cancel = context.WithTimeout(c.ctx, timeLimit)
+	} else {
+		ctx, cancel = context.WithCancel(c.ctx)
+	}
+	c.operationsLock.Lock()
+	c.operations[messageID] = cancel
+	c.operationsLock.Unlock()
+	return ctx, func() {
+		c.operationsLock.Lock()
+		delete(c.operations, messageID)
+		c.operationsLock.Unlock()
+		c.recordEnd(ctx, messageID)
+		cancel()
+	}
+}
+
+// Cancels the context of the operation with the specified message ID.
+// Returns false if no such operation is in progress.
+func (c *Conn) abandonOperation(messageID MessageID) bool {
+	c.operationsLock.Lock()
+	cancel, ok := c.operations[messageID]
+	c.operationsLock.Unlock()
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// Adds a function called with each response to the message with the specified ID
+// before the functions added earlier, which only see the responses it passes.
+// Returns a function that removes it.
+func (c *Conn) pushResponseFilter(messageID MessageID, f responseFilter) (pop func()) {
+	c.operationsLock.Lock()
+	defer c.operationsLock.Unlock()
+	if c.responseFilters == nil {
+		c.responseFilters = make(map[MessageID][]responseFilter)
+	}
+	// Copy the slice so that filterResponse can use the old one without the lock
+	filters := c.responseFilters[messageID]
+	c.responseFilters[messageID] = append(filters[:len(filters):len(filters)], f)
+	return func() {
+		c.operationsLock.Lock()
+		defer c.operationsLock.Unlock()
+		filters := c.responseFilters[messageID]
+		if len(filters) <= 1 {
+			delete(c.responseFilters, messageID)
+			return
+		}
+		c.responseFilters[messageID] = filters[:len(filters)-1]
+	}
+}
+
+// Returns whether the response passes the filters for its message ID, if any
+func (c *Conn) filterResponse(messageID MessageID, controls []Control, rtype BerType, res Encodable) bool {
+	c.operationsLock.Lock()
+	filters := c.responseFilters[messageID]
+	c.operationsLock.Unlock()
+	for i := len(filters) - 1; i >= 0; i-- {
+		if !filters[i](controls, rtype, res) {
+			return false
+		}
+	}
+	return true
 }
 
 // Sends a notice of disconnection to the client
@@ -62,7 +235,51 @@ func (c *Conn) NotifyDisconnect(resultCode LDAPResultCode, diagnosticMessage str
 
 // Reads a LDAPMessage from the connection
 func (c *Conn) ReadMessage() (*Message, error) {
-	return ReadLDAPMessage(c.conn)
+	r, err := c.waitForMessage()
+	if err != nil {
+		return nil, err
+	}
+	if c.metrics == nil {
+		return readLDAPMessage(r, c.limits)
+	}
+	cr := &countingReader{r: r}
+	msg, err := readLDAPMessage(cr, c.limits)
+	c.metrics.BytesReceived(cr.n)
+	return msg, err
+}
+
+// Waits for the first byte of the next message within the idle timeout
+// and returns a reader for the message, with the read timeout applied to the rest of it.
+// The idle timeout does not expire while operations are in progress.
+func (c *Conn) waitForMessage() (io.Reader, error) {
+	if c.idleTimeout <= 0 && c.readTimeout <= 0 {
+		return c.conn, nil
+	}
+	first := make([]byte, 1)
+	for {
+		if c.idleTimeout > 0 {
+			c.setReadDeadline(time.Now().Add(c.idleTimeout))
+		} else {
+			c.setReadDeadline(time.Time{})
+		}
+		_, err := io.ReadFull(c.conn, first)
+		if err == nil {
+			break
+		}
+		if c.idleTimeout > 0 && errors.Is(err, os.ErrDeadlineExceeded) && !c.readingStopped.Load() {
+			if c.hasOperations() {
+				continue
+			}
+			return nil, errIdleTimeout
+		}
+		return nil, err
+	}
+	if c.readTimeout > 0 {
+		c.setReadDeadline(time.Now().Add(c.readTimeout))
+	} else {
+		c.setReadDeadline(time.Time{})
+	}
+	return io.MultiReader(bytes.NewReader(first), c.conn), nil
 }
 
 // Sends an Extended Result with a message ID of 0
@@ -78,13 +295,25 @@ func (c *Conn) SendUnsolicitedNotification(resultCode LDAPResultCode, diagnostic
 	return c.SendResult(0, nil, TypeExtendedResponseOp, &res)
 }
 
-// Sends a LDAPMessage to the client and removes the corresponding message from the abandonment cache
+// Sends a LDAPMessage to the client.
+// If it cannot be written within the write timeout, the connection is closed.
 func (c *Conn) SendMessage(msg *Message) error {
 	c.tlsStarting.RLock()
 	defer c.tlsStarting.RUnlock()
 	c.sending.Lock()
 	defer c.sending.Unlock()
-	_, err := io.Copy(c.conn, bytes.NewReader(msg.EncodeWithHeader()))
+	if c.writeTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	n, err := io.Copy(c.conn, bytes.NewReader(msg.EncodeWithHeader()))
+	if c.metrics != nil {
+		c.metrics.BytesSent(int(n))
+	}
+	if err != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+		// A partial message may have been written
+		c.Logger().Warn("Timed out writing LDAPMessage, closing connection", "message_id", msg.MessageID)
+		c.Close()
+	}
 	return err
 }
 
@@ -98,24 +327,70 @@ func (c *Conn) StartTLS() error {
 	if c.TLSConfig == nil {
 		return ErrTLSNotAvailable
 	}
+	// Clear the deadlines of the last request and response
+	c.conn.SetWriteDeadline(time.Time{})
+	c.setReadDeadline(time.Time{})
 	tlsConn := tls.Server(c.conn, c.TLSConfig)
-	err := tlsConn.Handshake()
+	err := c.handshake(tlsConn)
 	if err != nil {
 		return err
 	}
 	c.conn = tlsConn
 	c.isTLS = true
+	c.logAccessTLS(tlsConn.ConnectionState())
+	if c.metrics != nil {
+		c.metrics.StartTLS()
+	}
 	return nil
 }
 
+// Performs the TLS handshake within the TLS handshake timeout
+func (c *Conn) handshake(tlsConn *tls.Conn) error {
+	ctx := c.ctx
+	if c.tlsHandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.tlsHandshakeTimeout)
+		defer cancel()
+	}
+	return tlsConn.HandshakeContext(ctx)
+}
+
 // Sends a LDAPResult to the client with specified parameters.
 // Pass an object with an Encode() function returning []byte to res.
 func (c *Conn) SendResult(messageID MessageID, controls []Control, rtype BerType, res Encodable) error {
+	if messageID != 0 && !c.filterResponse(messageID, controls, rtype, res) {
+		return nil
+	}
 	msg := Message{
 		MessageID: messageID,
 		Controls:  controls,
 	}
 	msg.ProtocolOp.Type = rtype
 	msg.ProtocolOp.Data = res.Encode()
-	return c.SendMessage(&msg)
+	err := c.SendMessage(&msg)
+	c.recordResponse(messageID, rtype, res)
+	if logger := c.Logger(); logger.Enabled(context.Background(), slog.LevelDebug) {
+		attrs := []any{"message_id", messageID, "op", OperationName(rtype)}
+		if code, ok := resultCodeOf(res); ok {
+			attrs = append(attrs, "result_code", code)
+		}
+		if err != nil {
+			attrs = append(attrs, "error", err)
+		}
+		logger.Debug("Sent response", attrs...)
+	}
+	return err
+}
+
+// Returns the result code of a response, if it has one
+func resultCodeOf(res Encodable) (LDAPResultCode, bool) {
+	switch r := res.(type) {
+	case *Result:
+		return r.ResultCode, true
+	case *BindResult:
+		return r.ResultCode, true
+	case *ExtendedResult:
+		return r.ResultCode, true
+	}
+	return 0, false
 }
@@ -0,0 +1,176 @@
+package ldapserver
+
+import "sync"
+
+// Interface for controls with a decoded value, e.g. the request controls
+// decoded by a ControlRegistry and the response controls sent by handlers.
+type TypedControl interface {
+	// Returns the OID of the control type
+	ControlType() OID
+	// Returns the encoded control value, or "" if the control has no value
+	EncodeValue() string
+}
+
+// Function decoding the value of a request control.
+// The value is "" if the control has none.
+type ControlDecoder func(value string) (TypedControl, error)
+
+// Returns a Control with the type and the encoded value of the typed control,
+// e.g. to send it with a response.
+func NewControl(control TypedControl, criticality bool) Control {
+	return Control{OID: control.ControlType(), Criticality: criticality, ControlValue: control.EncodeValue()}
+}
+
+// Returns the control with the OID, or nil if the message does not have it
+func (m *Message) GetControl(oid OID) *Control {
+	for i := range m.Controls {
+		if m.Controls[i].OID == oid {
+			return &m.Controls[i]
+		}
+	}
+	return nil
+}
+
+// Returns the decoded value of the request control with the OID,
+// or nil if the message does not have the control or no decoder is registered for it.
+func (m *Message) DecodedControl(oid OID) TypedControl {
+	return m.decodedControls[oid]
+}
+
+// Interface for handlers that support request controls.
+// RegisterControls is called once for each connection to collect the supported controls.
+type ControlProvider interface {
+	RegisterControls(registry *ControlRegistry)
+}
+
+// A supported request control
+type registeredControl struct {
+	decode ControlDecoder
+	// Request types the control applies to, or nil for all
+	operations []BerType
+}
+
+// A set of supported request controls and the decoders of their values.
+//
+// Before a request is passed to the handler, its controls are checked against the registry:
+// a critical control that is not supported for the operation
+// fails the request with the unavailableCriticalExtension result code (RFC 4511 section 4.1.11),
+// and a supported control whose value cannot be decoded fails it with protocolError.
+// Unsupported controls that are not critical are ignored.
+type ControlRegistry struct {
+	// Lock protecting the controls
+	lock sync.RWMutex
+	// Supported controls keyed by OID
+	controls map[OID]registeredControl
+	// OIDs in registration order
+	oids []OID
+}
+
+// Create a new empty control registry.
+func NewControlRegistry() *ControlRegistry {
+	return &ControlRegistry{
+		controls: make(map[OID]registeredControl),
+	}
+}
+
+// Register a supported request control, replacing any with the same OID.
+// If decode is nil, the control is supported with its raw value only.
+// The operations are the request types the control applies to, e.g. TypeSearchRequestOp;
+// if none are given, the control applies to all operations.
+func (r *ControlRegistry) Register(oid OID, decode ControlDecoder, operations ...BerType) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.controls == nil {
+		r.controls = make(map[OID]registeredControl)
+	}
+	if _, ok := r.controls[oid]; !ok {
+		r.oids = append(r.oids, oid)
+	}
+	r.controls[oid] = registeredControl{decode: decode, operations: operations}
+}
+
+// Returns true if the control is supported for the request type
+func (r *ControlRegistry) Supports(oid OID, operation BerType) bool {
+	_, ok := r.lookup(oid, operation)
+	return ok
+}
+
+// Returns the OIDs of the supported controls in registration order
+func (r *ControlRegistry) OIDs() []OID {
+	if r == nil {
+		return nil
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return append([]OID(nil), r.oids...)
+}
+
+// Returns the registered control if it applies to the request type
+func (r *ControlRegistry) lookup(oid OID, operation BerType) (registeredControl, bool) {
+	if r == nil {
+		return registeredControl{}, false
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	rc, ok := r.controls[oid]
+	if !ok {
+		return rc, false
+	}
+	if len(rc.operations) == 0 {
+		return rc, true
+	}
+	for _, op := range rc.operations {
+		if op == operation {
+			return rc, true
+		}
+	}
+	return rc, false
+}
+
+// Add the controls of another registry
+func (r *ControlRegistry) merge(other *ControlRegistry) {
+	other.lock.RLock()
+	defer other.lock.RUnlock()
+	for _, oid := range other.oids {
+		rc := other.controls[oid]
+		r.Register(oid, rc.decode, rc.operations...)
+	}
+}
+
+// Check the controls of a request and decode the supported ones.
+// Returns nil on success, or the result to send otherwise.
+func (r *ControlRegistry) decodeControls(msg *Message) *Result {
+	for _, c := range msg.Controls {
+		rc, ok := r.lookup(c.OID, msg.ProtocolOp.Type)
+		if !ok {
+			if c.Criticality {
+				return ResultUnavailableCriticalExtension.AsResult("unsupported critical control " + string(c.OID))
+			}
+			continue
+		}
+		if rc.decode == nil {
+			continue
+		}
+		value, err := rc.decode(c.ControlValue)
+		if err != nil {
+			return ResultProtocolError.AsResult("invalid value for control " + string(c.OID))
+		}
+		if msg.decodedControls == nil {
+			msg.decodedControls = make(map[OID]TypedControl)
+		}
+		msg.decodedControls[c.OID] = value
+	}
+	return nil
+}
+
+// Collect the controls registered on the server and by its handler
+func (s *LDAPServer) controlRegistry() *ControlRegistry {
+	registry := NewControlRegistry()
+	if s.Controls != nil {
+		registry.merge(s.Controls)
+	}
+	if p, ok := s.Handler.(ControlProvider); ok {
+		p.RegisterControls(registry)
+	}
+	return registry
+}
@@ -0,0 +1,130 @@
+package ldapserver_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/merlinz01/ldapserver"
+)
+
+// A control whose value is a single integer
+type testControl struct {
+	value int64
+}
+
+func (c *testControl) ControlType() ldapserver.OID {
+	return "1.2.3.4"
+}
+
+func (c *testControl) EncodeValue() string {
+	return string(ldapserver.BerEncodeInteger(c.value))
+}
+
+func decodeTestControl(value string) (ldapserver.TypedControl, error) {
+	elem, err := ldapserver.BerReadElement(strings.NewReader(value))
+	if err != nil || elem.Type != ldapserver.BerTypeInteger {
+		return nil, errors.New("invalid test control")
+	}
+	n, err := ldapserver.BerGetInteger(elem.Data)
+	if err != nil {
+		return nil, err
+	}
+	return &testControl{value: n}, nil
+}
+
+// Directory that supports the test control for Search requests
+// and returns its value doubled in a response control
+type controlHandler struct {
+	*ldapserver.MemoryHandler
+}
+
+func (h *controlHandler) RegisterControls(registry *ldapserver.ControlRegistry) {
+	registry.Register("1.2.3.4", decodeTestControl, ldapserver.TypeSearchRequestOp)
+}
+
+func (h *controlHandler) Search(ctx context.Context, conn *ldapserver.Conn, msg *ldapserver.Message, req *ldapserver.SearchRequest) {
+	var controls []ldapserver.Control
+	if c, ok := msg.DecodedControl("1.2.3.4").(*testControl); ok {
+		controls = append(controls, ldapserver.NewControl(&testControl{value: c.value * 2}, false))
+	}
+	conn.SendResult(msg.MessageID, controls, ldapserver.TypeSearchResultDoneOp, ldapserver.ResultSuccess.AsResult(""))
+}
+
+// Send a request with controls and return the final response
+func doRequestWithControls(t *testing.T, conn net.Conn, id ldapserver.MessageID, optype ldapserver.BerType, data []byte,
+	controls ...ldapserver.Control) *ldapserver.Message {
+	t.Helper()
+	msg := ldapserver.Message{MessageID: id, Controls: controls}
+	msg.ProtocolOp.Type = optype
+	msg.ProtocolOp.Data = data
+	if _, err := conn.Write(msg.EncodeWithHeader()); err != nil {
+		t.Fatal("Error sending request:", err)
+	}
+	for {
+		res, err := ldapserver.ReadLDAPMessage(conn)
+		if err != nil {
+			t.Fatal("Error reading response:", err)
+		}
+		if res.ProtocolOp.Type != ldapserver.TypeSearchResultEntryOp &&
+			res.ProtocolOp.Type != ldapserver.TypeSearchResultReferenceOp {
+			return res
+		}
+	}
+}
+
+func TestControls(t *testing.T) {
+	conn := startTestServer(t, ldapserver.Chain(&controlHandler{newTestDirectory(t)}, ldapserver.ReadOnly))
+	search := (&ldapserver.SearchRequest{BaseObject: "dc=example,dc=com", Scope: ldapserver.SearchScopeBaseObject}).Encode()
+	resultCode := func(msg *ldapserver.Message) ldapserver.LDAPResultCode {
+		t.Helper()
+		res, err := ldapserver.GetResult(msg.ProtocolOp.Data)
+		if err != nil {
+			t.Fatal("Error parsing result:", err)
+		}
+		return res.ResultCode
+	}
+
+	res := doRequestWithControls(t, conn, 1, ldapserver.TypeSearchRequestOp, search,
+		ldapserver.NewControl(&testControl{value: 21}, true))
+	if resultCode(res) != ldapserver.ResultSuccess || len(res.Controls) != 1 {
+		t.Fatal("wrong response", resultCode(res), res.Controls)
+	}
+	if c, err := decodeTestControl(res.GetControl("1.2.3.4").ControlValue); err != nil || c.(*testControl).value != 42 {
+		t.Fatal("wrong response control", c, err)
+	}
+	res = doRequestWithControls(t, conn, 2, ldapserver.TypeSearchRequestOp, search,
+		ldapserver.Control{OID: "1.2.3.5", Criticality: true})
+	if resultCode(res) != ldapserver.ResultUnavailableCriticalExtension || res.ProtocolOp.Type != ldapserver.TypeSearchResultDoneOp {
+		t.Fatal("unsupported critical control not rejected", resultCode(res))
+	}
+	res = doRequestWithControls(t, conn, 3, ldapserver.TypeSearchRequestOp, search,
+		ldapserver.Control{OID: "1.2.3.5"})
+	if resultCode(res) != ldapserver.ResultSuccess || len(res.Controls) != 0 {
+		t.Fatal("unsupported non-critical control not ignored", resultCode(res))
+	}
+	res = doRequestWithControls(t, conn, 4, ldapserver.TypeSearchRequestOp, search,
+		ldapserver.Control{OID: "1.2.3.4", ControlValue: "invalid"})
+	if resultCode(res) != ldapserver.ResultProtocolError {
+		t.Fatal("invalid control value not rejected", resultCode(res))
+	}
+	// The control is only supported for Search requests
+	res = doRequestWithControls(t, conn, 5, ldapserver.TypeDeleteRequestOp, []byte("dc=example,dc=com"),
+		ldapserver.NewControl(&testControl{value: 1}, true))
+	if resultCode(res) != ldapserver.ResultUnavailableCriticalExtension || res.ProtocolOp.Type != ldapserver.TypeDeleteResponseOp {
+		t.Fatal("critical control not rejected for Delete", resultCode(res))
+	}
+
+	responses := doRequest(t, conn, 6, ldapserver.TypeSearchRequestOp, (&ldapserver.SearchRequest{
+		Scope: ldapserver.SearchScopeBaseObject, Attributes: []string{"supportedControl"},
+	}).Encode())
+	entry, err := ldapserver.GetSearchResultEntry(responses[0].ProtocolOp.Data)
+	if err != nil {
+		t.Fatal("Error parsing entry:", err)
+	}
+	if len(entry.Attributes) != 1 || !slicesEqual(entry.Attributes[0].Values, []string{"1.2.3.4"}) {
+		t.Fatal("wrong supported controls", entry.Attributes)
+	}
+}
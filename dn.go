@@ -2,6 +2,7 @@ package ldapserver
 
 import (
 	"bytes"
+	"sort"
 	"strconv"
 	"strings"
 	"unicode"
@@ -34,7 +35,7 @@ func (d DN) String() string {
 	return s
 }
 
-// Returns true if the DN is equal to the other DN.
+// Returns true if the DN is equal to the other DN, comparing the RDNs with RDN.Equal.
 func (d DN) Equal(other DN) bool {
 	if len(d) != len(other) {
 		return false
@@ -140,18 +141,42 @@ func (r RDN) String() string {
 }
 
 // Returns true if the RDN is equal to the other RDN.
+// Attribute types are compared ignoring case and values using caseIgnoreMatch,
+// and the order of the attributes of a multi-valued RDN does not matter.
 func (r RDN) Equal(other RDN) bool {
 	if len(r) != len(other) {
 		return false
 	}
-	for i, attr := range r {
-		if attr.Type != other[i].Type || attr.Value != other[i].Value {
+	a, b := r.normalize(), other.normalize()
+	for i := range a {
+		if a[i] != b[i] {
 			return false
 		}
 	}
 	return true
 }
 
+// Returns the RDN in the form used for comparisons, with lowercased attribute types,
+// values prepared for caseIgnoreMatch and the attributes sorted.
+// Values that cannot be prepared, e.g. binary values, are kept as-is.
+func (r RDN) normalize() RDN {
+	norm := make(RDN, len(r))
+	for i, attr := range r {
+		value, err := normalizeCaseIgnore(attr.Value)
+		if err != nil {
+			value = attr.Value
+		}
+		norm[i] = RDNAttribute{Type: strings.ToLower(attr.Type), Value: value}
+	}
+	sort.Slice(norm, func(a, b int) bool {
+		if norm[a].Type != norm[b].Type {
+			return norm[a].Type < norm[b].Type
+		}
+		return norm[a].Value < norm[b].Value
+	})
+	return norm
+}
+
 // Returns the RFC4514-compliant string representation of the RDNAttribute.
 func (a RDNAttribute) String() string {
 	if OID(a.Type).Validate() == nil {
@@ -72,7 +72,7 @@ func TestParseDN(t *testing.T) {
 		pdn, err := ldapserver.ParseDN(dn.dnStr)
 		if err != dn.err {
 			t.Fatalf("Error parsing DN: %s", err)
-		} else if !pdn.Equal(dn.dn) {
+		} else if !pdn.Equal(dn.dn) || pdn.String() != dn.dn.String() {
 			t.Errorf("Expected %s", dn.dn)
 			t.Fatalf("Got      %s", pdn)
 		}
@@ -243,6 +243,32 @@ func TestDNIsSibling(t *testing.T) {
 	}
 }
 
+func TestDNEqual(t *testing.T) {
+	equalTests := []struct {
+		dn1   string
+		dn2   string
+		equal bool
+	}{
+		{"cn=Foo,dc=example,dc=com", "CN=foo,DC=Example,DC=COM", true},
+		{"cn=John  Doe,dc=com", "cn= john doe ,dc=com", true},
+		{"cn=John+sn=Doe,dc=com", "SN=doe+cn=john,dc=com", true},
+		{"cn=John,dc=com", "cn=John+sn=Doe,dc=com", false},
+		{"cn=John,dc=com", "sn=John,dc=com", false},
+		{"cn=John,dc=com", "cn=Jon,dc=com", false},
+		{"cn=John,dc=com", "cn=John,dc=org", false},
+	}
+	for _, test := range equalTests {
+		dn1 := ldapserver.MustParseDN(test.dn1)
+		dn2 := ldapserver.MustParseDN(test.dn2)
+		if dn1.Equal(dn2) != test.equal || dn2.Equal(dn1) != test.equal {
+			t.Errorf("Expected %t for \"%s\" equals \"%s\"", test.equal, test.dn1, test.dn2)
+		}
+	}
+	if !ldapserver.MustParseDN("ou=users,dc=example,dc=com").IsParent(ldapserver.MustParseDN("uid=jdoe,OU=Users,DC=Example,DC=com")) {
+		t.Error("Expected case-insensitive parent comparison")
+	}
+}
+
 func TestDNCommonAncestor(t *testing.T) {
 	type ancestorTest struct {
 		dn1 string
@@ -0,0 +1,131 @@
+package ldapserver
+
+import "strings"
+
+// Entry represents a directory entry: a DN and the attributes stored for it.
+type Entry struct {
+	DN         DN
+	Attributes []Attribute
+}
+
+// Return a new entry with the specified DN and attributes.
+func NewEntry(dn DN, attributes ...Attribute) *Entry {
+	return &Entry{DN: dn, Attributes: attributes}
+}
+
+// Returns a pointer to the attribute with the specified description, or nil if not present.
+// Attribute descriptions are compared case-insensitively.
+func (e *Entry) GetAttribute(description string) *Attribute {
+	for i := range e.Attributes {
+		if strings.EqualFold(e.Attributes[i].Description, description) {
+			return &e.Attributes[i]
+		}
+	}
+	return nil
+}
+
+// Returns the values of the attribute with the specified description, or nil if not present.
+func (e *Entry) GetAttributeValues(description string) []string {
+	attr := e.GetAttribute(description)
+	if attr == nil {
+		return nil
+	}
+	return attr.Values
+}
+
+// Returns the first value of the attribute with the specified description, or "" if not present.
+func (e *Entry) GetAttributeValue(description string) string {
+	values := e.GetAttributeValues(description)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Returns a deep copy of the entry.
+func (e *Entry) Clone() *Entry {
+	c := &Entry{
+		DN:         make(DN, len(e.DN)),
+		Attributes: make([]Attribute, len(e.Attributes)),
+	}
+	for i, rdn := range e.DN {
+		c.DN[i] = append(RDN(nil), rdn...)
+	}
+	for i, attr := range e.Attributes {
+		c.Attributes[i] = Attribute{
+			Description: attr.Description,
+			Values:      append([]string(nil), attr.Values...),
+		}
+	}
+	return c
+}
+
+// Returns a SearchResultEntry for the entry containing the selected attributes.
+//
+// An empty selection or the special selector "*" selects all attributes,
+// and the special selector "1.1" on its own selects no attributes.
+// If typesOnly is true, the attribute values are omitted.
+func (e *Entry) SearchResultEntry(attributes []string, typesOnly bool) *SearchResultEntry {
+	all := len(attributes) == 0
+	for _, a := range attributes {
+		if a == "*" {
+			all = true
+		}
+	}
+	res := &SearchResultEntry{ObjectName: e.DN.String()}
+	for _, attr := range e.Attributes {
+		if !all && !containsFold(attributes, attr.Description) {
+			continue
+		}
+		a := Attribute{Description: attr.Description}
+		if !typesOnly {
+			a.Values = append([]string(nil), attr.Values...)
+		}
+		res.Attributes = append(res.Attributes, a)
+	}
+	return res
+}
+
+// Returns a SearchResultEntry for a server entry whose attributes are all operational
+// except the specified user attributes, such as the Root DSE.
+// Operational attributes are only selected by name or with the special selector "+".
+func (e *Entry) operationalSearchResultEntry(userAttributes []string, attributes []string, typesOnly bool) *SearchResultEntry {
+	user := len(attributes) == 0
+	operational := false
+	for _, a := range attributes {
+		switch a {
+		case "*":
+			user = true
+		case "+":
+			operational = true
+		}
+	}
+	res := &SearchResultEntry{ObjectName: e.DN.String()}
+	for _, attr := range e.Attributes {
+		selected := containsFold(attributes, attr.Description)
+		if containsFold(userAttributes, attr.Description) {
+			selected = selected || user
+		} else {
+			selected = selected || operational
+		}
+		if !selected {
+			continue
+		}
+		a := Attribute{Description: attr.Description}
+		if !typesOnly {
+			a.Values = append([]string(nil), attr.Values...)
+		}
+		res.Attributes = append(res.Attributes, a)
+	}
+	return res
+}
+
+// Returns true if the list contains the string, ignoring case.
+func containsFold(list []string, s string) bool {
+	for _, l := range list {
+		if strings.EqualFold(l, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -43,3 +43,8 @@ var ErrTLSNotAvailable = &LDAPError{message: "TLS not available"}
 var ErrWrongElementType = &LDAPError{message: "wrong element type"}
 var ErrWrongSequenceLength = &LDAPError{message: "wrong sequence length"}
 var ErrInvalidDN = &LDAPError{message: "invalid DN"}
+var ErrUnknownMatchingRule = &LDAPError{message: "unknown matching rule"}
+var ErrInvalidFilter = &LDAPError{message: "invalid filter"}
+var ErrLimitExceeded = &LDAPError{message: "limit exceeded"}
+var ErrInvalidSchemaDefinition = &LDAPError{message: "invalid schema definition"}
+var ErrInvalidAttributeValue = &LDAPError{message: "invalid attribute value"}
@@ -51,14 +51,54 @@ func GetExtendedRequest(data []byte) (*ExtendedRequest, error) {
 	return req, nil
 }
 
+// Return an ExtendedResult from BER-encoded data
+func GetExtendedResult(data []byte) (*ExtendedResult, error) {
+	seq, err := BerGetSequence(data)
+	if err != nil {
+		return nil, err
+	}
+	res, rest, err := getResultComponents(seq, "ExtendedResponse")
+	if err != nil {
+		return nil, err
+	}
+	r := &ExtendedResult{Result: *res}
+	// responseName [10] LDAPOID OPTIONAL
+	if len(rest) > 0 && rest[0].Type == BerContextSpecificType(10, false) {
+		r.ResponseName = OID(BerGetOctetString(rest[0].Data))
+		if err = r.ResponseName.Validate(); err != nil {
+			return nil, err
+		}
+		rest = rest[1:]
+	}
+	// responseValue [11] OCTET STRING OPTIONAL
+	if len(rest) > 0 && rest[0].Type == BerContextSpecificType(11, false) {
+		r.ResponseValue = BerGetOctetString(rest[0].Data)
+		rest = rest[1:]
+	}
+	if len(rest) != 0 {
+		return nil, ErrWrongElementType.WithInfo("ExtendedResponse element type", rest[0].Type)
+	}
+	return r, nil
+}
+
 // Return the BER-encoded struct (without element header)
 func (r *ExtendedResult) Encode() []byte {
 	data := bytes.NewBuffer(r.Result.Encode())
 	if r.ResponseName != "" {
-		data.Write(BerEncodeElement(BerContextSpecificType(10, false), BerEncodeOctetString(string(r.ResponseName))))
+		data.Write(BerEncodeElement(BerContextSpecificType(10, false), []byte(r.ResponseName)))
 	}
 	if r.ResponseValue != "" {
-		data.Write(BerEncodeElement(BerContextSpecificType(11, false), BerEncodeOctetString(r.ResponseValue)))
+		data.Write(BerEncodeElement(BerContextSpecificType(11, false), []byte(r.ResponseValue)))
 	}
 	return data.Bytes()
 }
+
+// Return the BER-encoded struct (without element header)
+func (r *ExtendedRequest) Encode() []byte {
+	b := bytes.NewBuffer(nil)
+	b.Write(BerEncodeElement(BerContextSpecificType(0, false), []byte(r.Name)))
+	if r.Value != "" {
+		b.Write(BerEncodeElement(BerContextSpecificType(1, false), []byte(r.Value)))
+	}
+	return b.Bytes()
+}
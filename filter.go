@@ -64,8 +64,18 @@ type MatchingRuleAssertion struct {
 	DNAttributes bool
 }
 
-// Return a Filter from a raw BER element
+// Return a Filter from a raw BER element.
+// Filters nested deeper than DefaultLimits.MaxFilterDepth are rejected with ErrLimitExceeded.
 func GetFilter(raw BerRawElement) (*Filter, error) {
+	return getFilter(raw, 1, DefaultLimits.MaxFilterDepth)
+}
+
+// Return a Filter at the specified nesting depth from a raw BER element.
+// A maxDepth of 0 means no limit.
+func getFilter(raw BerRawElement, depth int, maxDepth int) (*Filter, error) {
+	if maxDepth > 0 && depth > maxDepth {
+		return nil, ErrLimitExceeded.WithInfo("filter depth", depth)
+	}
 	if raw.Type.Class() != BerClassContextSpecific {
 		return nil, ErrWrongElementType.WithInfo("Filter type", raw.Type)
 	}
@@ -87,7 +97,7 @@ func GetFilter(raw BerRawElement) (*Filter, error) {
 			return nil, err
 		}
 		for _, rf := range seq {
-			filter, err := GetFilter(rf)
+			filter, err := getFilter(rf, depth+1, maxDepth)
 			if err != nil {
 				return nil, err
 			}
@@ -99,7 +109,7 @@ func GetFilter(raw BerRawElement) (*Filter, error) {
 		if err != nil {
 			return nil, err
 		}
-		filter, err := GetFilter(elmt)
+		filter, err := getFilter(elmt, depth+1, maxDepth)
 		if err != nil {
 			return nil, err
 		}
@@ -301,3 +311,61 @@ func encodeAssertionValue(value string) []byte {
 	}
 	return buf
 }
+
+// Return the BER-encoded filter.
+//
+// NOTE: Unlike most Encode() methods, the element header is included,
+// since its type code identifies the type of filter.
+func (f *Filter) Encode() []byte {
+	switch f.Type {
+	case FilterTypeAnd, FilterTypeOr:
+		b := bytes.NewBuffer(nil)
+		for _, filter := range f.Data.([]Filter) {
+			b.Write(filter.Encode())
+		}
+		return BerEncodeElement(BerContextSpecificType(f.Type, true), b.Bytes())
+	case FilterTypeNot:
+		return BerEncodeElement(BerContextSpecificType(f.Type, true), f.Data.(*Filter).Encode())
+	case FilterTypeEqual, FilterTypeGreaterOrEqual, FilterTypeLessOrEqual, FilterTypeApproxMatch:
+		return BerEncodeElement(BerContextSpecificType(f.Type, true), f.Data.(*AttributeValueAssertion).Encode())
+	case FilterTypeSubstrings:
+		sf := f.Data.(*SubstringFilter)
+		b := bytes.NewBuffer(nil)
+		b.Write(BerEncodeOctetString(sf.Attribute))
+		sb := bytes.NewBuffer(nil)
+		if sf.Initial != "" {
+			sb.Write(BerEncodeElement(BerContextSpecificType(0, false), []byte(sf.Initial)))
+		}
+		for _, mid := range sf.Any {
+			sb.Write(BerEncodeElement(BerContextSpecificType(1, false), []byte(mid)))
+		}
+		if sf.Final != "" {
+			sb.Write(BerEncodeElement(BerContextSpecificType(2, false), []byte(sf.Final)))
+		}
+		b.Write(BerEncodeSequence(sb.Bytes()))
+		return BerEncodeElement(BerContextSpecificType(f.Type, true), b.Bytes())
+	case FilterTypePresent:
+		return BerEncodeElement(BerContextSpecificType(f.Type, false), []byte(f.Data.(string)))
+	case FilterTypeExtensibleMatch:
+		mra := f.Data.(*MatchingRuleAssertion)
+		b := bytes.NewBuffer(nil)
+		if mra.MatchingRule != "" {
+			b.Write(BerEncodeElement(BerContextSpecificType(1, false), []byte(mra.MatchingRule)))
+		}
+		if mra.Attribute != "" {
+			b.Write(BerEncodeElement(BerContextSpecificType(2, false), []byte(mra.Attribute)))
+		}
+		b.Write(BerEncodeElement(BerContextSpecificType(3, false), []byte(mra.Value)))
+		if mra.DNAttributes {
+			b.Write(BerEncodeElement(BerContextSpecificType(4, false), []byte{0xff}))
+		}
+		return BerEncodeElement(BerContextSpecificType(f.Type, true), b.Bytes())
+	case FilterTypeAbsoluteTrue:
+		return BerEncodeElement(BerContextSpecificType(FilterTypeAnd, true), nil)
+	case FilterTypeAbsoluteFalse:
+		return BerEncodeElement(BerContextSpecificType(FilterTypeOr, true), nil)
+	default:
+		raw := f.Data.(*BerRawElement)
+		return BerEncodeElement(raw.Type, raw.Data)
+	}
+}
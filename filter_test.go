@@ -2,6 +2,8 @@ package ldapserver_test
 
 import (
 	"bytes"
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/merlinz01/ldapserver"
@@ -91,5 +93,273 @@ func TestFilterString(t *testing.T) {
 		if f.String() != c.str {
 			t.Fatalf("Filter.String() = %v, want %v", f.String(), c.str)
 		}
+		if !bytes.Equal(f.Encode(), c.raw) {
+			t.Fatalf("Filter.Encode() = %v, want %v", f.Encode(), c.raw)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	entry := ldapserver.NewEntry(ldapserver.MustParseDN("uid=jdoe,ou=users,dc=example,dc=com"),
+		ldapserver.Attribute{Description: "objectClass", Values: []string{"top", "person"}},
+		ldapserver.Attribute{Description: "uid", Values: []string{"jdoe"}},
+		ldapserver.Attribute{Description: "cn", Values: []string{"John  Doe"}},
+		ldapserver.Attribute{Description: "sn", Values: []string{"Doe"}},
+		ldapserver.Attribute{Description: "userPassword", Values: []string{"Secret"}},
+		ldapserver.Attribute{Description: "description", Values: []string{"   "}},
+	)
+	ava := func(ftype uint8, attr string, value string) ldapserver.Filter {
+		return ldapserver.Filter{Type: ftype, Data: &ldapserver.AttributeValueAssertion{Description: attr, Value: value}}
+	}
+	eq := func(attr string, value string) ldapserver.Filter {
+		return ava(ldapserver.FilterTypeEqual, attr, value)
+	}
+	ext := func(rule string, attr string, value string, dn bool) ldapserver.Filter {
+		return ldapserver.Filter{Type: ldapserver.FilterTypeExtensibleMatch, Data: &ldapserver.MatchingRuleAssertion{
+			MatchingRule: rule, Attribute: attr, Value: value, DNAttributes: dn}}
+	}
+	sub := func(attr string, initial string, any []string, final string) ldapserver.Filter {
+		return ldapserver.Filter{Type: ldapserver.FilterTypeSubstrings, Data: &ldapserver.SubstringFilter{
+			Attribute: attr, Initial: initial, Any: any, Final: final}}
+	}
+	undefined := ava(ldapserver.FilterTypeGreaterOrEqual, "userPassword", "x")
+	type testCase struct {
+		filter ldapserver.Filter
+		result ldapserver.FilterResult
+	}
+	cases := []testCase{
+		{eq("uid", "jdoe"), ldapserver.FilterTrue},
+		{eq("UID", "JDOE"), ldapserver.FilterTrue},
+		{eq("cn", "john doe"), ldapserver.FilterTrue},
+		{eq("uid", "jsmith"), ldapserver.FilterFalse},
+		{eq("mail", "jdoe@example.com"), ldapserver.FilterFalse},
+		{eq("userPassword", "secret"), ldapserver.FilterFalse},
+		{eq("userPassword", "Secret"), ldapserver.FilterTrue},
+		{eq("description", " "), ldapserver.FilterTrue},
+		{eq("description", ""), ldapserver.FilterFalse},
+		{ava(ldapserver.FilterTypeApproxMatch, "sn", "doe"), ldapserver.FilterTrue},
+		{ava(ldapserver.FilterTypeGreaterOrEqual, "sn", "Doe"), ldapserver.FilterTrue},
+		{ava(ldapserver.FilterTypeGreaterOrEqual, "sn", "e"), ldapserver.FilterFalse},
+		{ava(ldapserver.FilterTypeLessOrEqual, "sn", "doe"), ldapserver.FilterTrue},
+		{ava(ldapserver.FilterTypeLessOrEqual, "sn", "a"), ldapserver.FilterFalse},
+		{undefined, ldapserver.FilterUndefined},
+		{ldapserver.Filter{Type: ldapserver.FilterTypePresent, Data: "objectclass"}, ldapserver.FilterTrue},
+		{ldapserver.Filter{Type: ldapserver.FilterTypePresent, Data: "mail"}, ldapserver.FilterFalse},
+		{sub("cn", "jo", []string{"n d"}, "oe"), ldapserver.FilterTrue},
+		{sub("cn", "", nil, "DOE"), ldapserver.FilterTrue},
+		{sub("cn", "doe", nil, ""), ldapserver.FilterFalse},
+		{sub("description", " ", nil, ""), ldapserver.FilterTrue},
+		{ldapserver.Filter{Type: ldapserver.FilterTypeAnd, Data: []ldapserver.Filter{eq("uid", "jdoe"), eq("sn", "doe")}}, ldapserver.FilterTrue},
+		{ldapserver.Filter{Type: ldapserver.FilterTypeAnd, Data: []ldapserver.Filter{eq("uid", "jdoe"), undefined}}, ldapserver.FilterUndefined},
+		{ldapserver.Filter{Type: ldapserver.FilterTypeAnd, Data: []ldapserver.Filter{eq("uid", "x"), undefined}}, ldapserver.FilterFalse},
+		{ldapserver.Filter{Type: ldapserver.FilterTypeOr, Data: []ldapserver.Filter{eq("uid", "x"), undefined}}, ldapserver.FilterUndefined},
+		{ldapserver.Filter{Type: ldapserver.FilterTypeOr, Data: []ldapserver.Filter{eq("uid", "jdoe"), undefined}}, ldapserver.FilterTrue},
+		{ldapserver.Filter{Type: ldapserver.FilterTypeNot, Data: &undefined}, ldapserver.FilterUndefined},
+		{ldapserver.Filter{Type: ldapserver.FilterTypeNot, Data: &ldapserver.Filter{Type: ldapserver.FilterTypePresent, Data: "mail"}}, ldapserver.FilterTrue},
+		{ldapserver.Filter{Type: ldapserver.FilterTypeAbsoluteTrue}, ldapserver.FilterTrue},
+		{ldapserver.Filter{Type: ldapserver.FilterTypeAbsoluteFalse}, ldapserver.FilterFalse},
+		{ext("caseExactMatch", "sn", "Doe", false), ldapserver.FilterTrue},
+		{ext("2.5.13.5", "sn", "doe", false), ldapserver.FilterFalse},
+		{ext("", "sn", "doe", false), ldapserver.FilterTrue},
+		{ext("caseIgnoreMatch", "", "DOE", false), ldapserver.FilterTrue},
+		{ext("caseIgnoreMatch", "ou", "users", false), ldapserver.FilterFalse},
+		{ext("caseIgnoreMatch", "ou", "users", true), ldapserver.FilterTrue},
+		{ext("caseIgnoreSubstringsMatch", "cn", "j*doe", false), ldapserver.FilterTrue},
+		{ext("noSuchMatch", "sn", "Doe", false), ldapserver.FilterUndefined},
+	}
+	for _, c := range cases {
+		if res := c.filter.Match(entry); res != c.result {
+			t.Fatalf("%s.Match() = %v, want %v", c.filter.String(), res, c.result)
+		}
+	}
+}
+
+func TestMatchingRules(t *testing.T) {
+	rule := func(name string) *ldapserver.MatchingRule {
+		t.Helper()
+		r := ldapserver.DefaultMatchingRules.MatchingRule(name)
+		if r == nil {
+			t.Fatal("matching rule not registered:", name)
+		}
+		return r
+	}
+	equalTests := []struct {
+		rule      string
+		value     string
+		assertion string
+		result    ldapserver.FilterResult
+	}{
+		{"caseIgnoreMatch", "  John\tDoe ", "JOHN DOE", ldapserver.FilterTrue},
+		{"caseIgnoreMatch", "Jo\u00adhn", "john", ldapserver.FilterTrue},
+		{"caseIgnoreMatch", "John\u00a0Doe", "john doe", ldapserver.FilterTrue},
+		{"caseIgnoreMatch", "John\ufffd", "john", ldapserver.FilterUndefined},
+		{"caseExactMatch", "John  Doe", "John Doe", ldapserver.FilterTrue},
+		{"caseExactMatch", "John Doe", "john doe", ldapserver.FilterFalse},
+		{"caseIgnoreMatch", "   ", " ", ldapserver.FilterTrue},
+		{"caseIgnoreMatch", "\t", "", ldapserver.FilterFalse},
+		{"caseExactMatch", " ", "\u00a0\u00ad", ldapserver.FilterTrue},
+		{"caseIgnoreIA5Match", "JDoe@Example.com", "jdoe@example.COM", ldapserver.FilterTrue},
+		{"caseIgnoreIA5Match", "jdoe@example.com", "jd\u00f6e@example.com", ldapserver.FilterUndefined},
+		{"caseExactIA5Match", "JDoe", "jdoe", ldapserver.FilterFalse},
+		{"integerMatch", "0042", "42", ldapserver.FilterTrue},
+		{"integerMatch", "-0", "0", ldapserver.FilterTrue},
+		{"integerMatch", "42", "forty-two", ldapserver.FilterUndefined},
+		{"generalizedTimeMatch", "20240101120000Z", "202401011300+0100", ldapserver.FilterTrue},
+		{"generalizedTimeMatch", "2024010112.5Z", "20240101123000.0Z", ldapserver.FilterTrue},
+		{"generalizedTimeMatch", "20240101120000Z", "20240101120001Z", ldapserver.FilterFalse},
+		{"generalizedTimeMatch", "20240230120000Z", "20240230120000Z", ldapserver.FilterUndefined},
+		{"octetStringMatch", "Secret", "secret", ldapserver.FilterFalse},
+		{"distinguishedNameMatch", "CN=John  Doe,DC=Example,DC=com", "cn=john doe,dc=example,dc=com", ldapserver.FilterTrue},
+		{"distinguishedNameMatch", "cn=John+sn=Doe,dc=com", "sn=Doe+cn=John,dc=com", ldapserver.FilterTrue},
+		{"distinguishedNameMatch", "cn=John,dc=com", "cn=John,dc=org", ldapserver.FilterFalse},
+		{"distinguishedNameMatch", "cn=John,dc=com", "not a DN", ldapserver.FilterUndefined},
+		{"telephoneNumberMatch", "+1 555-0100", "+15550100", ldapserver.FilterTrue},
+		{"numericStringMatch", "123 456", "123456", ldapserver.FilterTrue},
+		{"booleanMatch", "TRUE", "FALSE", ldapserver.FilterFalse},
+		{"objectIdentifierMatch", "2.5.4.3", "2.5.4.3", ldapserver.FilterTrue},
+		{"objectIdentifierFirstComponentMatch", "( 2.5.4.3 NAME 'cn' SUP name )", "2.5.4.3", ldapserver.FilterTrue},
+	}
+	for _, test := range equalTests {
+		if res := rule(test.rule).Equal(test.value, test.assertion); res != test.result {
+			t.Fatalf("%s.Equal(%q, %q) = %v, want %v", test.rule, test.value, test.assertion, res, test.result)
+		}
+	}
+	lessTests := []struct {
+		rule      string
+		value     string
+		assertion string
+		result    ldapserver.FilterResult
+	}{
+		{"integerOrderingMatch", "9", "10", ldapserver.FilterTrue},
+		{"integerOrderingMatch", "-10", "-9", ldapserver.FilterTrue},
+		{"integerOrderingMatch", "-1", "0", ldapserver.FilterTrue},
+		{"integerOrderingMatch", "10", "9", ldapserver.FilterFalse},
+		{"generalizedTimeOrderingMatch", "20240101120000Z", "20240101120000-0100", ldapserver.FilterTrue},
+		{"generalizedTimeOrderingMatch", "20240101120000.5Z", "20240101120000Z", ldapserver.FilterFalse},
+		{"caseIgnoreOrderingMatch", "abc", "ABD", ldapserver.FilterTrue},
+	}
+	for _, test := range lessTests {
+		if res := rule(test.rule).Less(test.value, test.assertion); res != test.result {
+			t.Fatalf("%s.Less(%q, %q) = %v, want %v", test.rule, test.value, test.assertion, res, test.result)
+		}
+	}
+	if rule("telephoneNumberSubstringsMatch").MatchSubstrings("+1 555-0100", "+1555", nil, "100") != ldapserver.FilterTrue {
+		t.Fatal("telephone number substrings did not match")
+	}
+	if rule("caseIgnoreIA5SubstringsMatch").MatchSubstrings("JDoe@Example.com", "jdoe", []string{"@EXAMPLE"}, "") != ldapserver.FilterTrue {
+		t.Fatal("IA5 substrings did not match")
+	}
+	substringsTests := []struct {
+		value   string
+		initial string
+		any     []string
+		final   string
+		result  ldapserver.FilterResult
+	}{
+		{"John Doe", " ", nil, "", ldapserver.FilterTrue},
+		{"John Doe", "", []string{"  "}, "", ldapserver.FilterTrue},
+		{"   ", "", nil, " ", ldapserver.FilterTrue},
+		{"John  Doe", "john ", nil, " doe", ldapserver.FilterTrue},
+		// Leading and trailing spaces of substrings are not significant
+		{"Johnson", "john ", nil, "", ldapserver.FilterTrue},
+		{"John Doe", "", []string{"n d"}, "", ldapserver.FilterTrue},
+	}
+	for _, test := range substringsTests {
+		if res := rule("caseIgnoreSubstringsMatch").MatchSubstrings(test.value, test.initial, test.any, test.final); res != test.result {
+			t.Fatalf("caseIgnoreSubstringsMatch.MatchSubstrings(%q, %q, %q, %q) = %v, want %v",
+				test.value, test.initial, test.any, test.final, res, test.result)
+		}
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	roundTrip := []string{
+		"(&)",
+		"(|)",
+		"(uid=*)",
+		"(uid=jdoe)",
+		"(uid=)",
+		"(createTimestamp>=20170102030405.678Z)",
+		"(accountBalance<=1234)",
+		"(givenName~=John)",
+		"(cn=abc*)",
+		"(cn=*lmn*)",
+		"(cn=*xyz)",
+		"(cn=abc*def*lmn*uvw*xyz)",
+		"(uid:=jdoe)",
+		"(:caseIgnoreMatch:=foo)",
+		"(:dn:2.5.13.5:=foo)",
+		"(uid:dn:caseIgnoreMatch:=jdoe)",
+		"(cn;lang-en=John)",
+		"(2.5.4.3=John)",
+		"(&(givenName=John)(sn=Doe))",
+		"(|(givenName=John)(givenName=Jonathan))",
+		"(!(givenName=John))",
+		"(&(objectClass=person)(|(uid=a*)(!(cn=*b*))))",
+		"(uid=jdo\\00)",
+		"(uid=jdo\\5c)",
+		"(uid=jd\\28\\29)",
+		"(cn=\\2a*\\2a)",
+	}
+	for _, s := range roundTrip {
+		f, err := ldapserver.ParseFilter(s)
+		if err != nil {
+			t.Fatalf("ParseFilter(%q) returned error: %v", s, err)
+		}
+		if f.String() != s {
+			t.Fatalf("ParseFilter(%q).String() = %q", s, f.String())
+		}
+	}
+
+	f, err := ldapserver.ParseFilter("(cn=a\\2Ab\\c3\\a9)")
+	if err != nil {
+		t.Fatal("ParseFilter returned error:", err)
+	}
+	if f.Type != ldapserver.FilterTypeEqual || f.Data.(*ldapserver.AttributeValueAssertion).Value != "a*b\xc3\xa9" {
+		t.Fatal("escapes were not decoded correctly")
+	}
+	f, err = ldapserver.ParseFilter("(o:dn:=Example)")
+	if err != nil {
+		t.Fatal("ParseFilter returned error:", err)
+	}
+	mra := f.Data.(*ldapserver.MatchingRuleAssertion)
+	if mra.Attribute != "o" || !mra.DNAttributes || mra.MatchingRule != "" || mra.Value != "Example" {
+		t.Fatal("extensible match was not parsed correctly", mra)
+	}
+
+	invalid := []string{
+		"",
+		"uid=jdoe",
+		"(uid=jdoe",
+		"(uid=jdoe))",
+		"(uid=jd(oe)",
+		"(uid)",
+		"(=jdoe)",
+		"(u id=jdoe)",
+		"(uid=a**b)",
+		"(uid=\\2)",
+		"(uid=\\zz)",
+		"(uid>=a*)",
+		"(:=jdoe)",
+		"(:dn:=jdoe)",
+		"(uid:dn:rule:extra:=jdoe)",
+		"(&(uid=jdoe)",
+		"(!uid=jdoe)",
+	}
+	for _, s := range invalid {
+		_, err := ldapserver.ParseFilter(s)
+		if !errors.Is(err, ldapserver.ErrInvalidFilter) {
+			t.Fatalf("ParseFilter(%q) returned error %v, want %v", s, err, ldapserver.ErrInvalidFilter)
+		}
+	}
+
+	depth := ldapserver.DefaultLimits.MaxFilterDepth
+	nested := strings.Repeat("(!", depth-1) + "(cn=a)" + strings.Repeat(")", depth-1)
+	if _, err := ldapserver.ParseFilter(nested); err != nil {
+		t.Fatal("ParseFilter returned error for maximum depth:", err)
+	}
+	nested = "(!" + nested + ")"
+	if _, err := ldapserver.ParseFilter(nested); !errors.Is(err, ldapserver.ErrLimitExceeded) {
+		t.Fatalf("ParseFilter returned error %v for too deep filter, want %v", err, ldapserver.ErrLimitExceeded)
 	}
 }
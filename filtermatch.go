@@ -0,0 +1,239 @@
+package ldapserver
+
+import "strings"
+
+// Result of evaluating a filter against an entry.
+// Filters use three-valued logic as defined in RFC 4511 section 4.5.1.7.
+type FilterResult uint8
+
+// Defined filter results
+const (
+	FilterFalse     FilterResult = 0
+	FilterTrue      FilterResult = 1
+	FilterUndefined FilterResult = 2
+)
+
+// Returns "TRUE", "FALSE" or "Undefined"
+func (r FilterResult) String() string {
+	switch r {
+	case FilterTrue:
+		return "TRUE"
+	case FilterFalse:
+		return "FALSE"
+	default:
+		return "Undefined"
+	}
+}
+
+// Returns FilterTrue or FilterFalse
+func filterResultOf(b bool) FilterResult {
+	if b {
+		return FilterTrue
+	}
+	return FilterFalse
+}
+
+// Evaluate the filter against the entry using DefaultMatchingRules.
+// Only entries for which the result is FilterTrue match the filter.
+func (f *Filter) Match(entry *Entry) FilterResult {
+	return f.MatchWith(entry, DefaultMatchingRules)
+}
+
+// Evaluate the filter against the entry using the specified matching rules.
+// Only entries for which the result is FilterTrue match the filter.
+func (f *Filter) MatchWith(entry *Entry, rules MatchingRuleResolver) FilterResult {
+	switch f.Type {
+	case FilterTypeAnd:
+		// FALSE if any is FALSE, else Undefined if any is Undefined, else TRUE
+		res := FilterTrue
+		for _, sub := range f.Data.([]Filter) {
+			switch sub.MatchWith(entry, rules) {
+			case FilterFalse:
+				return FilterFalse
+			case FilterUndefined:
+				res = FilterUndefined
+			}
+		}
+		return res
+	case FilterTypeOr:
+		// TRUE if any is TRUE, else Undefined if any is Undefined, else FALSE
+		res := FilterFalse
+		for _, sub := range f.Data.([]Filter) {
+			switch sub.MatchWith(entry, rules) {
+			case FilterTrue:
+				return FilterTrue
+			case FilterUndefined:
+				res = FilterUndefined
+			}
+		}
+		return res
+	case FilterTypeNot:
+		switch f.Data.(*Filter).MatchWith(entry, rules) {
+		case FilterTrue:
+			return FilterFalse
+		case FilterFalse:
+			return FilterTrue
+		default:
+			return FilterUndefined
+		}
+	case FilterTypeEqual, FilterTypeApproxMatch:
+		// Approximate matching is implemented as equality matching
+		ava := f.Data.(*AttributeValueAssertion)
+		rule := rules.EqualityRule(ava.Description)
+		if rule == nil {
+			return FilterUndefined
+		}
+		return matchValues(attributeValues(entry, ava.Description, rules), func(v string) FilterResult {
+			return rule.Equal(v, ava.Value)
+		})
+	case FilterTypeGreaterOrEqual:
+		ava := f.Data.(*AttributeValueAssertion)
+		rule := rules.OrderingRule(ava.Description)
+		if rule == nil {
+			return FilterUndefined
+		}
+		return matchValues(attributeValues(entry, ava.Description, rules), func(v string) FilterResult {
+			switch rule.Less(v, ava.Value) {
+			case FilterTrue:
+				return FilterFalse
+			case FilterFalse:
+				return FilterTrue
+			default:
+				return FilterUndefined
+			}
+		})
+	case FilterTypeLessOrEqual:
+		ava := f.Data.(*AttributeValueAssertion)
+		rule := rules.OrderingRule(ava.Description)
+		if rule == nil {
+			return FilterUndefined
+		}
+		return matchValues(attributeValues(entry, ava.Description, rules), func(v string) FilterResult {
+			if rule.Less(v, ava.Value) == FilterTrue {
+				return FilterTrue
+			}
+			return rule.Equal(v, ava.Value)
+		})
+	case FilterTypeSubstrings:
+		sf := f.Data.(*SubstringFilter)
+		rule := rules.SubstringsRule(sf.Attribute)
+		if rule == nil {
+			return FilterUndefined
+		}
+		return matchValues(attributeValues(entry, sf.Attribute, rules), func(v string) FilterResult {
+			return rule.MatchSubstrings(v, sf.Initial, sf.Any, sf.Final)
+		})
+	case FilterTypePresent:
+		for _, attr := range entry.Attributes {
+			if sameAttributeType(rules, attr.Description, f.Data.(string)) {
+				return FilterTrue
+			}
+		}
+		return FilterFalse
+	case FilterTypeExtensibleMatch:
+		return f.Data.(*MatchingRuleAssertion).matchWith(entry, rules)
+	case FilterTypeAbsoluteTrue:
+		return FilterTrue
+	case FilterTypeAbsoluteFalse:
+		return FilterFalse
+	}
+	return FilterUndefined
+}
+
+// Evaluate the assertion against the entry as described in RFC 4511 section 4.5.1.7.7.
+func (m *MatchingRuleAssertion) matchWith(entry *Entry, rules MatchingRuleResolver) FilterResult {
+	var rule *MatchingRule
+	if m.MatchingRule != "" {
+		rule = rules.MatchingRule(m.MatchingRule)
+	} else if m.Attribute != "" {
+		rule = rules.EqualityRule(m.Attribute)
+	}
+	if rule == nil {
+		return FilterUndefined
+	}
+	match := func(v string) FilterResult {
+		switch rule.Type {
+		case MatchingRuleOrdering:
+			return rule.Less(v, m.Value)
+		case MatchingRuleSubstrings:
+			initial, any, final := splitSubstringAssertion(m.Value)
+			return rule.MatchSubstrings(v, initial, any, final)
+		default:
+			return rule.Equal(v, m.Value)
+		}
+	}
+	// Collect the values to test
+	var values []string
+	if m.Attribute != "" {
+		values = attributeValues(entry, m.Attribute, rules)
+	} else {
+		for _, attr := range entry.Attributes {
+			values = append(values, attr.Values...)
+		}
+	}
+	if m.DNAttributes {
+		for _, rdn := range entry.DN {
+			for _, attr := range rdn {
+				if m.Attribute == "" || sameAttributeType(rules, attr.Type, m.Attribute) {
+					values = append(values, attr.Value)
+				}
+			}
+		}
+	}
+	return matchValues(values, match)
+}
+
+// Interface implemented by MatchingRuleResolvers that know the names and OIDs of attribute types,
+// such as Schema, so that filters match attributes by any of their names.
+type AttributeTypeResolver interface {
+	// Returns true if the attribute descriptions refer to the same attribute type
+	SameAttributeType(a string, b string) bool
+}
+
+// Returns true if the attribute descriptions refer to the same attribute type
+// according to the rules, or are equal ignoring case.
+func sameAttributeType(rules MatchingRuleResolver, a string, b string) bool {
+	if r, ok := rules.(AttributeTypeResolver); ok {
+		return r.SameAttributeType(a, b)
+	}
+	return strings.EqualFold(a, b)
+}
+
+// Returns the values of the entry's attributes with the description
+func attributeValues(entry *Entry, description string, rules MatchingRuleResolver) []string {
+	r, ok := rules.(AttributeTypeResolver)
+	if !ok {
+		return entry.GetAttributeValues(description)
+	}
+	var values []string
+	for _, attr := range entry.Attributes {
+		if r.SameAttributeType(attr.Description, description) {
+			values = append(values, attr.Values...)
+		}
+	}
+	return values
+}
+
+// Returns TRUE if the match is TRUE for any value,
+// else Undefined if it is Undefined for any value, else FALSE.
+func matchValues(values []string, match func(string) FilterResult) FilterResult {
+	res := FilterFalse
+	for _, v := range values {
+		switch match(v) {
+		case FilterTrue:
+			return FilterTrue
+		case FilterUndefined:
+			res = FilterUndefined
+		}
+	}
+	return res
+}
+
+// Split a substring assertion of the form [initial]*any*...[final] into its components.
+func splitSubstringAssertion(value string) (initial string, any []string, final string) {
+	parts := strings.Split(value, "*")
+	if len(parts) == 1 {
+		return "", parts, ""
+	}
+	return parts[0], parts[1 : len(parts)-1], parts[len(parts)-1]
+}
@@ -0,0 +1,262 @@
+package ldapserver
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// attributedescription = attributetype options
+// attributetype = oid
+// options = *( SEMI option )
+// option = 1*keychar
+var validAttributeDescription = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9-]*|[0-9]+(\.[0-9]+)*)(;[A-Za-z0-9-]+)*$`)
+
+// oid = descr / numericoid
+var validMatchingRuleID = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9-]*|[0-9]+(\.[0-9]+)*)$`)
+
+// Parses a RFC 4515 string representation into a Filter.
+//
+// The absolute true and false filters of RFC 4526 are written "(&)" and "(|)".
+// The output of Filter.String() can be parsed back into an equivalent Filter.
+// Filters nested deeper than DefaultLimits.MaxFilterDepth are rejected with ErrLimitExceeded.
+func ParseFilter(s string) (*Filter, error) {
+	p := filterParser{s: s, maxDepth: DefaultLimits.MaxFilterDepth}
+	f, err := p.parseFilter()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(s) {
+		return nil, ErrInvalidFilter.WithInfo("unexpected data at position", p.pos)
+	}
+	return f, nil
+}
+
+// Parses a RFC 4515 string representation into a Filter.
+// Panics if there is an error, useful for compile-time initialization.
+func MustParseFilter(s string) *Filter {
+	f, err := ParseFilter(s)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// State of a filter string being parsed
+type filterParser struct {
+	s   string
+	pos int
+	// Nesting depth of the filter being parsed
+	depth int
+	// Maximum nesting depth, or 0 for no limit
+	maxDepth int
+}
+
+// Consume the specified character or return an error
+func (p *filterParser) expect(c byte) error {
+	if p.pos >= len(p.s) {
+		return ErrInvalidFilter.WithInfo("unexpected end of filter, expected", string(c))
+	}
+	if p.s[p.pos] != c {
+		return ErrInvalidFilter.WithInfo("expected "+string(c)+" at position", p.pos)
+	}
+	p.pos++
+	return nil
+}
+
+// filter = LPAREN filtercomp RPAREN
+// filtercomp = and / or / not / item
+func (p *filterParser) parseFilter() (*Filter, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.maxDepth > 0 && p.depth > p.maxDepth {
+		return nil, ErrLimitExceeded.WithInfo("filter depth", p.depth)
+	}
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.s) {
+		return nil, ErrInvalidFilter.WithInfo("unexpected end of filter at position", p.pos)
+	}
+	var f *Filter
+	switch p.s[p.pos] {
+	case '&', '|':
+		// and = AMPERSAND filterlist
+		// or = VERTBAR filterlist
+		// filterlist = 1*filter
+		ftype := FilterTypeAnd
+		if p.s[p.pos] == '|' {
+			ftype = FilterTypeOr
+		}
+		p.pos++
+		var filters []Filter
+		for p.pos < len(p.s) && p.s[p.pos] == '(' {
+			sub, err := p.parseFilter()
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, *sub)
+		}
+		if len(filters) == 0 {
+			// RFC 4526 absolute true and false filters
+			if ftype == FilterTypeAnd {
+				f = &Filter{Type: FilterTypeAbsoluteTrue}
+			} else {
+				f = &Filter{Type: FilterTypeAbsoluteFalse}
+			}
+		} else {
+			f = &Filter{Type: ftype, Data: filters}
+		}
+	case '!':
+		// not = EXCLAMATION filter
+		p.pos++
+		sub, err := p.parseFilter()
+		if err != nil {
+			return nil, err
+		}
+		f = &Filter{Type: FilterTypeNot, Data: sub}
+	default:
+		end := strings.IndexByte(p.s[p.pos:], ')')
+		if end < 0 {
+			return nil, ErrInvalidFilter.WithInfo("unterminated filter item at position", p.pos)
+		}
+		item, err := parseFilterItem(p.s[p.pos : p.pos+end])
+		if err != nil {
+			return nil, err
+		}
+		p.pos += end
+		f = item
+	}
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// item = simple / present / substring / extensible
+func parseFilterItem(item string) (*Filter, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq < 0 {
+		return nil, ErrInvalidFilter.WithInfo("missing filter type in item", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+	ftype := FilterTypeEqual
+	if len(attr) > 0 {
+		switch attr[len(attr)-1] {
+		case '~':
+			ftype = FilterTypeApproxMatch
+		case '>':
+			ftype = FilterTypeGreaterOrEqual
+		case '<':
+			ftype = FilterTypeLessOrEqual
+		case ':':
+			ftype = FilterTypeExtensibleMatch
+		}
+		if ftype != FilterTypeEqual {
+			attr = attr[:len(attr)-1]
+		}
+	}
+	if ftype == FilterTypeExtensibleMatch {
+		return parseExtensibleItem(attr, value)
+	}
+	if !validAttributeDescription.MatchString(attr) {
+		return nil, ErrInvalidFilter.WithInfo("invalid attribute description", attr)
+	}
+	if ftype == FilterTypeEqual && strings.IndexByte(value, '*') >= 0 {
+		// present = attr EQUALS ASTERISK
+		if value == "*" {
+			return &Filter{Type: FilterTypePresent, Data: attr}, nil
+		}
+		// substring = attr EQUALS [initial] any [final]
+		parts := strings.Split(value, "*")
+		sf := &SubstringFilter{Attribute: attr}
+		for i, part := range parts {
+			decoded, err := decodeAssertionValue(part)
+			if err != nil {
+				return nil, err
+			}
+			switch i {
+			case 0:
+				sf.Initial = decoded
+			case len(parts) - 1:
+				sf.Final = decoded
+			default:
+				if decoded == "" {
+					return nil, ErrInvalidFilter.WithInfo("empty substring in value", value)
+				}
+				sf.Any = append(sf.Any, decoded)
+			}
+		}
+		return &Filter{Type: FilterTypeSubstrings, Data: sf}, nil
+	}
+	decoded, err := decodeAssertionValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{Type: ftype, Data: &AttributeValueAssertion{Description: attr, Value: decoded}}, nil
+}
+
+// extensible = ( attr [dnattrs] [matchingrule] COLON EQUALS assertionvalue )
+// / ( [dnattrs] matchingrule COLON EQUALS assertionvalue )
+// dnattrs = COLON "dn"
+// matchingrule = COLON oid
+func parseExtensibleItem(lhs string, value string) (*Filter, error) {
+	parts := strings.Split(lhs, ":")
+	m := &MatchingRuleAssertion{Attribute: parts[0]}
+	rest := parts[1:]
+	if len(rest) > 0 && strings.EqualFold(rest[0], "dn") {
+		m.DNAttributes = true
+		rest = rest[1:]
+	}
+	if len(rest) == 1 {
+		m.MatchingRule = rest[0]
+		if !validMatchingRuleID.MatchString(m.MatchingRule) {
+			return nil, ErrInvalidFilter.WithInfo("invalid matching rule", m.MatchingRule)
+		}
+	} else if len(rest) > 1 {
+		return nil, ErrInvalidFilter.WithInfo("invalid extensible match", lhs)
+	}
+	if m.Attribute == "" {
+		if m.MatchingRule == "" {
+			return nil, ErrInvalidFilter.WithInfo("extensible match without attribute or matching rule", lhs)
+		}
+	} else if !validAttributeDescription.MatchString(m.Attribute) {
+		return nil, ErrInvalidFilter.WithInfo("invalid attribute description", m.Attribute)
+	}
+	decoded, err := decodeAssertionValue(value)
+	if err != nil {
+		return nil, err
+	}
+	m.Value = decoded
+	return &Filter{Type: FilterTypeExtensibleMatch, Data: m}, nil
+}
+
+// Decode a RFC 4515 assertion value, resolving escapes of the form \XX.
+func decodeAssertionValue(s string) (string, error) {
+	if strings.IndexByte(s, '\\') < 0 {
+		if strings.ContainsAny(s, "()*\x00") {
+			return "", ErrInvalidFilter.WithInfo("unescaped special character in value", s)
+		}
+		return s, nil
+	}
+	buf := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+2 >= len(s) {
+				return "", ErrInvalidFilter.WithInfo("incomplete escape in value", s)
+			}
+			b, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", ErrInvalidFilter.WithInfo("invalid escape in value", s)
+			}
+			buf = append(buf, byte(b))
+			i += 2
+		case '(', ')', '*', '\x00':
+			return "", ErrInvalidFilter.WithInfo("unescaped special character in value", s)
+		default:
+			buf = append(buf, s[i])
+		}
+	}
+	return string(buf), nil
+}
@@ -1,3 +1,3 @@
 module github.com/merlinz01/ldapserver
 
-go 1.19
+go 1.21
@@ -1,32 +1,38 @@
 package ldapserver
 
 import (
-	"log"
+	"context"
 )
 
 // Interface for LDAP server objects.
 // Implementations should inherit BaseHandler for ease of use.
+//
+// The context passed to each method is cancelled when the operation is abandoned,
+// when the connection is closed or the server is shut down,
+// and for Search requests, when the requested time limit runs out.
+// Handlers should not send a response for an abandoned operation.
 type Handler interface {
-	// Abandon the message with the specified ID
-	Abandon(*Conn, *Message, MessageID)
+	// Notification that the message with the specified ID was abandoned.
+	// The operation's context has already been cancelled.
+	Abandon(context.Context, *Conn, *Message, MessageID)
 	// Perform an Add request
-	Add(*Conn, *Message, *AddRequest)
+	Add(context.Context, *Conn, *Message, *AddRequest)
 	// Perform a Bind request
-	Bind(*Conn, *Message, *BindRequest)
+	Bind(context.Context, *Conn, *Message, *BindRequest)
 	// Perform a Compare request
-	Compare(*Conn, *Message, *CompareRequest)
+	Compare(context.Context, *Conn, *Message, *CompareRequest)
 	// Perform a Delete request
-	Delete(*Conn, *Message, string)
+	Delete(context.Context, *Conn, *Message, string)
 	// Perform an Extended request
-	Extended(*Conn, *Message, *ExtendedRequest)
+	Extended(context.Context, *Conn, *Message, *ExtendedRequest)
 	// Perform a Modify request
-	Modify(*Conn, *Message, *ModifyRequest)
+	Modify(context.Context, *Conn, *Message, *ModifyRequest)
 	// Perform a ModifyDN request
-	ModifyDN(*Conn, *Message, *ModifyDNRequest)
+	ModifyDN(context.Context, *Conn, *Message, *ModifyDNRequest)
 	// Perform a Search request
-	Search(*Conn, *Message, *SearchRequest)
+	Search(context.Context, *Conn, *Message, *SearchRequest)
 	// Handle unrecognized requests
-	Other(*Conn, *Message)
+	Other(context.Context, *Conn, *Message)
 }
 
 // Basic server functionality.
@@ -35,60 +41,67 @@ type Handler interface {
 type BaseHandler struct {
 }
 
-func (*BaseHandler) Abandon(conn *Conn, msg *Message, messageID MessageID) {
+func (*BaseHandler) Abandon(ctx context.Context, conn *Conn, msg *Message, messageID MessageID) {
 	// Abandon has no result
 }
 
-func (*BaseHandler) Add(conn *Conn, msg *Message, req *AddRequest) {
+func (*BaseHandler) Add(ctx context.Context, conn *Conn, msg *Message, req *AddRequest) {
 	conn.SendResult(msg.MessageID, nil, TypeAddResponseOp,
 		ResultUnwillingToPerform.AsResult("the Add operation not supported by this server"))
 }
 
-func (*BaseHandler) Bind(conn *Conn, msg *Message, req *BindRequest) {
+func (*BaseHandler) Bind(ctx context.Context, conn *Conn, msg *Message, req *BindRequest) {
 	conn.SendResult(msg.MessageID, nil, TypeBindResponseOp,
 		ResultUnwillingToPerform.AsResult("the Bind operation not supported by this server"))
 }
 
-func (*BaseHandler) Compare(conn *Conn, msg *Message, req *CompareRequest) {
+func (*BaseHandler) Compare(ctx context.Context, conn *Conn, msg *Message, req *CompareRequest) {
 	conn.SendResult(msg.MessageID, nil, TypeCompareResponseOp,
 		ResultUnwillingToPerform.AsResult("the Compare operation not supported by this server"))
 }
 
-func (*BaseHandler) Delete(conn *Conn, msg *Message, dn string) {
+func (*BaseHandler) Delete(ctx context.Context, conn *Conn, msg *Message, dn string) {
 	conn.SendResult(msg.MessageID, nil, TypeDeleteResponseOp,
 		ResultUnwillingToPerform.AsResult("the Delete operation not supported by this server"))
 }
 
-func (*BaseHandler) Modify(conn *Conn, msg *Message, req *ModifyRequest) {
+func (*BaseHandler) Modify(ctx context.Context, conn *Conn, msg *Message, req *ModifyRequest) {
 	conn.SendResult(msg.MessageID, nil, TypeModifyResponseOp,
 		ResultUnwillingToPerform.AsResult("the Modify operation not supported by this server"))
 }
 
-func (*BaseHandler) ModifyDN(conn *Conn, msg *Message, req *ModifyDNRequest) {
+func (*BaseHandler) ModifyDN(ctx context.Context, conn *Conn, msg *Message, req *ModifyDNRequest) {
 	conn.SendResult(msg.MessageID, nil, TypeModifyDNResponseOp,
 		ResultUnwillingToPerform.AsResult("the ModifyDN operation not supported by this server"))
 }
 
-func (*BaseHandler) Search(conn *Conn, msg *Message, req *SearchRequest) {
+func (*BaseHandler) Search(ctx context.Context, conn *Conn, msg *Message, req *SearchRequest) {
 	conn.SendResult(msg.MessageID, nil, TypeSearchResultDoneOp,
 		ResultUnwillingToPerform.AsResult("the Search operation not supported by this server"))
 }
 
 // Implementers should provide their own Extended method that defaults to calling this
 // if they want to handle other Extended requests.
-func (h *BaseHandler) Extended(conn *Conn, msg *Message, req *ExtendedRequest) {
+// Password Modify requests are passed to the server's handler if it implements PasswordModifier.
+func (h *BaseHandler) Extended(ctx context.Context, conn *Conn, msg *Message, req *ExtendedRequest) {
+	if req.Name == OIDPasswordModify {
+		if m := passwordModifier(conn.handler); m != nil {
+			modifyPassword(ctx, conn, msg, req, m)
+			return
+		}
+	}
 	switch req.Name {
 	case OIDStartTLS:
 		res := ExtendedResult{}
 		res.ResponseName = OIDStartTLS
 		if conn.TLSConfig == nil {
-			log.Println("StartTLS requested but TLS is not available")
+			conn.Logger().Info("StartTLS requested but TLS is not available", "message_id", msg.MessageID)
 			res.Result.ResultCode = ResultProtocolError
 			res.DiagnosticMessage = "TLS is not available on this connection"
 			conn.SendResult(msg.MessageID, nil, TypeExtendedResponseOp, &res)
 			return
 		} else if conn.IsTLS() {
-			log.Println("StartTLS requested but TLS is already set up")
+			conn.Logger().Info("StartTLS requested but TLS is already set up", "message_id", msg.MessageID)
 			res.Result.ResultCode = ResultOperationsError
 			res.DiagnosticMessage = "TLS is already set up on this connection"
 			conn.SendResult(msg.MessageID, nil, TypeExtendedResponseOp, &res)
@@ -99,12 +112,12 @@ func (h *BaseHandler) Extended(conn *Conn, msg *Message, req *ExtendedRequest) {
 			conn.SendResult(msg.MessageID, nil, TypeExtendedResponseOp, &res)
 			err := conn.StartTLS()
 			if err != nil {
-				log.Println("Error starting TLS:", err)
+				conn.Logger().Warn("Error starting TLS", "message_id", msg.MessageID, "error", err)
 				conn.Close()
 			}
 		}
 	default:
-		log.Println("Unknown extended request:", req.Name)
+		conn.Logger().Info("Unknown extended request", "message_id", msg.MessageID, "name", req.Name)
 		res := &ExtendedResult{
 			Result: Result{
 				ResultCode:        ResultProtocolError,
@@ -115,7 +128,7 @@ func (h *BaseHandler) Extended(conn *Conn, msg *Message, req *ExtendedRequest) {
 	}
 }
 
-func (*BaseHandler) Other(conn *Conn, msg *Message) {
+func (*BaseHandler) Other(ctx context.Context, conn *Conn, msg *Message) {
 	conn.NotifyDisconnect(ResultProtocolError, "operation type not recognized")
 	conn.Close()
 }
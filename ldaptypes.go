@@ -23,3 +23,36 @@ const (
 	TypeExtendedResponseOp      BerType = 0b01111000
 	TypeIntermediateResponseOp  BerType = 0b01111001
 )
+
+// Names of the protocol operations as in the LDAPMessage definition of RFC 4511
+var operationNames = map[BerType]string{
+	TypeBindRequestOp:           "bindRequest",
+	TypeBindResponseOp:          "bindResponse",
+	TypeUnbindRequestOp:         "unbindRequest",
+	TypeSearchRequestOp:         "searchRequest",
+	TypeSearchResultEntryOp:     "searchResEntry",
+	TypeSearchResultDoneOp:      "searchResDone",
+	TypeModifyRequestOp:         "modifyRequest",
+	TypeModifyResponseOp:        "modifyResponse",
+	TypeAddRequestOp:            "addRequest",
+	TypeAddResponseOp:           "addResponse",
+	TypeDeleteRequestOp:         "delRequest",
+	TypeDeleteResponseOp:        "delResponse",
+	TypeModifyDNRequestOp:       "modDNRequest",
+	TypeModifyDNResponseOp:      "modDNResponse",
+	TypeCompareRequestOp:        "compareRequest",
+	TypeCompareResponseOp:       "compareResponse",
+	TypeAbandonRequestOp:        "abandonRequest",
+	TypeSearchResultReferenceOp: "searchResRef",
+	TypeExtendedRequestOp:       "extendedReq",
+	TypeExtendedResponseOp:      "extendedResp",
+	TypeIntermediateResponseOp:  "intermediateResponse",
+}
+
+// Returns the name of the protocol operation type as in RFC 4511, e.g. "searchRequest"
+func OperationName(t BerType) string {
+	if name, ok := operationNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
@@ -0,0 +1,69 @@
+package ldapserver
+
+// Limits on the size and complexity of the messages received by the server,
+// protecting it from clients that try to exhaust its memory.
+// A zero field means no limit.
+type Limits struct {
+	// Maximum size in bytes of the content of a LDAPMessage.
+	// Larger messages are rejected before they are read.
+	MaxMessageSize uint32
+	// Maximum nesting depth of a search filter
+	MaxFilterDepth int
+	// Maximum number of attributes in a request
+	// (entry attributes of an Add request, changes of a Modify request,
+	// or requested attributes of a Search request)
+	MaxAttributes int
+	// Maximum number of values of an attribute in an Add or Modify request
+	MaxValues int
+	// Maximum number of controls in a message
+	MaxControls int
+}
+
+// Limits used by the server if none are specified
+var DefaultLimits = Limits{
+	MaxMessageSize: 10 * 1024 * 1024,
+	MaxFilterDepth: 64,
+	MaxAttributes:  1000,
+	MaxValues:      10000,
+	MaxControls:    32,
+}
+
+// Returns an error if the number of attributes or values exceeds the limits
+func (l *Limits) checkAttributes(attrs []Attribute) error {
+	if l.MaxAttributes > 0 && len(attrs) > l.MaxAttributes {
+		return ErrLimitExceeded.WithInfo("number of attributes", len(attrs))
+	}
+	if l.MaxValues > 0 {
+		for _, attr := range attrs {
+			if len(attr.Values) > l.MaxValues {
+				return ErrLimitExceeded.WithInfo("number of values of "+attr.Description, len(attr.Values))
+			}
+		}
+	}
+	return nil
+}
+
+// Returns an error if the Modify request exceeds the limits
+func (l *Limits) checkModifyRequest(req *ModifyRequest) error {
+	if l.MaxAttributes > 0 && len(req.Changes) > l.MaxAttributes {
+		return ErrLimitExceeded.WithInfo("number of changes", len(req.Changes))
+	}
+	if l.MaxValues > 0 {
+		for _, change := range req.Changes {
+			if len(change.Modification.Values) > l.MaxValues {
+				return ErrLimitExceeded.WithInfo("number of values of "+change.Modification.Description,
+					len(change.Modification.Values))
+			}
+		}
+	}
+	return nil
+}
+
+// Returns an error if the Search request exceeds the limits.
+// The filter depth is checked while decoding the request.
+func (l *Limits) checkSearchRequest(req *SearchRequest) error {
+	if l.MaxAttributes > 0 && len(req.Attributes) > l.MaxAttributes {
+		return ErrLimitExceeded.WithInfo("number of requested attributes", len(req.Attributes))
+	}
+	return nil
+}
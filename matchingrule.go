@@ -0,0 +1,590 @@
+package ldapserver
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Type of matching rule
+type MatchingRuleType uint8
+
+// Defined matching rule types
+const (
+	MatchingRuleEquality   MatchingRuleType = 0
+	MatchingRuleOrdering   MatchingRuleType = 1
+	MatchingRuleSubstrings MatchingRuleType = 2
+)
+
+// A matching rule defines how assertion values are compared with attribute values.
+type MatchingRule struct {
+	// Numeric OID of the matching rule
+	OID OID
+	// Short descriptive name of the matching rule, e.g. "caseIgnoreMatch"
+	Name string
+	// Whether this is an equality, ordering or substrings rule
+	Type MatchingRuleType
+	// Prepares a value for comparison.
+	// Returns an error if the value is not valid for the rule.
+	// If nil, values are compared as-is.
+	Normalize func(value string) (string, error)
+	// Compares two normalized values, returning a negative number, zero or a positive number
+	// if a is less than, equal to or greater than b.
+	// If nil, the normalized values are compared bytewise.
+	Compare func(a string, b string) int
+}
+
+// Returns the normalized form of the value.
+func (m *MatchingRule) normalize(value string) (string, error) {
+	if m.Normalize == nil {
+		return value, nil
+	}
+	return m.Normalize(value)
+}
+
+// Compares two normalized values.
+func (m *MatchingRule) compare(a string, b string) int {
+	if m.Compare == nil {
+		return strings.Compare(a, b)
+	}
+	return m.Compare(a, b)
+}
+
+// Returns whether the attribute value is equal to the assertion value according to the rule.
+// Returns FilterUndefined if either value is not valid for the rule.
+func (m *MatchingRule) Equal(attributeValue string, assertionValue string) FilterResult {
+	a, err := m.normalize(attributeValue)
+	if err != nil {
+		return FilterUndefined
+	}
+	b, err := m.normalize(assertionValue)
+	if err != nil {
+		return FilterUndefined
+	}
+	return filterResultOf(m.compare(a, b) == 0)
+}
+
+// Returns whether the attribute value is less than the assertion value according to the rule.
+// Returns FilterUndefined if either value is not valid for the rule.
+func (m *MatchingRule) Less(attributeValue string, assertionValue string) FilterResult {
+	a, err := m.normalize(attributeValue)
+	if err != nil {
+		return FilterUndefined
+	}
+	b, err := m.normalize(assertionValue)
+	if err != nil {
+		return FilterUndefined
+	}
+	return filterResultOf(m.compare(a, b) < 0)
+}
+
+// Returns whether the attribute value matches the substrings according to the rule.
+// Returns FilterUndefined if any of the values is not valid for the rule.
+func (m *MatchingRule) MatchSubstrings(attributeValue string, initial string, any []string, final string) FilterResult {
+	value, err := m.normalize(attributeValue)
+	if err != nil {
+		return FilterUndefined
+	}
+	if initial != "" {
+		if initial, err = m.normalize(initial); err != nil {
+			return FilterUndefined
+		}
+		if !strings.HasPrefix(value, initial) {
+			return FilterFalse
+		}
+		value = value[len(initial):]
+	}
+	for _, sub := range any {
+		if sub, err = m.normalize(sub); err != nil {
+			return FilterUndefined
+		}
+		i := strings.Index(value, sub)
+		if i < 0 {
+			return FilterFalse
+		}
+		value = value[i+len(sub):]
+	}
+	if final != "" {
+		if final, err = m.normalize(final); err != nil {
+			return FilterUndefined
+		}
+		if !strings.HasSuffix(value, final) {
+			return FilterFalse
+		}
+	}
+	return FilterTrue
+}
+
+// Interface for selecting the matching rules used to evaluate filters.
+type MatchingRuleResolver interface {
+	// Returns the equality rule for the attribute, or nil if it has none
+	EqualityRule(attribute string) *MatchingRule
+	// Returns the ordering rule for the attribute, or nil if it has none
+	OrderingRule(attribute string) *MatchingRule
+	// Returns the substrings rule for the attribute, or nil if it has none
+	SubstringsRule(attribute string) *MatchingRule
+	// Returns the matching rule with the specified name or OID, or nil if it is unknown
+	MatchingRule(nameOrOID string) *MatchingRule
+}
+
+// Rules assigned to an attribute
+type attributeRules struct {
+	equality   *MatchingRule
+	ordering   *MatchingRule
+	substrings *MatchingRule
+}
+
+// A MatchingRuleResolver holding a set of matching rules and the rules assigned to attributes.
+// Attributes without assigned rules use the registry's default rules.
+type MatchingRuleRegistry struct {
+	// Lock protecting the maps
+	lock sync.RWMutex
+	// Rules keyed by lowercased name and OID
+	rules map[string]*MatchingRule
+	// Rules keyed by lowercased attribute description
+	attributes map[string]attributeRules
+	// Rules used for attributes without assigned rules
+	defaults attributeRules
+}
+
+// Create a new empty matching rule registry.
+func NewMatchingRuleRegistry() *MatchingRuleRegistry {
+	return &MatchingRuleRegistry{
+		rules:      make(map[string]*MatchingRule),
+		attributes: make(map[string]attributeRules),
+	}
+}
+
+// Add matching rules to the registry, replacing any with the same name or OID.
+func (r *MatchingRuleRegistry) Register(rules ...*MatchingRule) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, rule := range rules {
+		if rule.OID != "" {
+			r.rules[string(rule.OID)] = rule
+		}
+		if rule.Name != "" {
+			r.rules[strings.ToLower(rule.Name)] = rule
+		}
+	}
+}
+
+// Assign the named equality, ordering and substrings rules to the attribute.
+// Pass "" for rules the attribute does not have.
+// If attribute is "", the rules are used as the defaults for attributes without assigned rules.
+// Returns an error if any of the rules is not registered.
+func (r *MatchingRuleRegistry) SetAttributeRules(attribute string, equality string, ordering string, substrings string) error {
+	var rules attributeRules
+	for _, rr := range []struct {
+		name string
+		rule **MatchingRule
+	}{{equality, &rules.equality}, {ordering, &rules.ordering}, {substrings, &rules.substrings}} {
+		if rr.name == "" {
+			continue
+		}
+		*rr.rule = r.MatchingRule(rr.name)
+		if *rr.rule == nil {
+			return ErrUnknownMatchingRule.WithInfo("name", rr.name)
+		}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if attribute == "" {
+		r.defaults = rules
+	} else {
+		r.attributes[strings.ToLower(attribute)] = rules
+	}
+	return nil
+}
+
+// Returns the rules assigned to the attribute, or the defaults.
+func (r *MatchingRuleRegistry) attributeRules(attribute string) attributeRules {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if rules, ok := r.attributes[strings.ToLower(attribute)]; ok {
+		return rules
+	}
+	return r.defaults
+}
+
+func (r *MatchingRuleRegistry) EqualityRule(attribute string) *MatchingRule {
+	return r.attributeRules(attribute).equality
+}
+
+func (r *MatchingRuleRegistry) OrderingRule(attribute string) *MatchingRule {
+	return r.attributeRules(attribute).ordering
+}
+
+func (r *MatchingRuleRegistry) SubstringsRule(attribute string) *MatchingRule {
+	return r.attributeRules(attribute).substrings
+}
+
+func (r *MatchingRuleRegistry) MatchingRule(nameOrOID string) *MatchingRule {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.rules[strings.ToLower(nameOrOID)]
+}
+
+// Prepare a string for case-exact comparison (RFC 4518).
+func normalizeSpaces(value string) (string, error) {
+	return prepareString(value, false)
+}
+
+// Prepare a string for case-insensitive comparison (RFC 4518).
+func normalizeCaseIgnore(value string) (string, error) {
+	return prepareString(value, true)
+}
+
+// Prepare an IA5 string for case-exact comparison.
+func normalizeIA5(value string) (string, error) {
+	if !validIA5String(value) {
+		return "", ErrInvalidAttributeValue.WithInfo("reason", "not an IA5 string")
+	}
+	return prepareString(value, false)
+}
+
+// Prepare an IA5 string for case-insensitive comparison.
+func normalizeIA5CaseIgnore(value string) (string, error) {
+	if !validIA5String(value) {
+		return "", ErrInvalidAttributeValue.WithInfo("reason", "not an IA5 string")
+	}
+	return prepareString(value, true)
+}
+
+// Prepare a numeric string for comparison by removing all spaces.
+func normalizeNumericString(value string) (string, error) {
+	if !validNumericString(value) {
+		return "", ErrInvalidAttributeValue.WithInfo("reason", "not a numeric string")
+	}
+	return strings.ReplaceAll(value, " ", ""), nil
+}
+
+// Prepare a telephone number for comparison by removing spaces and hyphens.
+func normalizeTelephoneNumber(value string) (string, error) {
+	value, err := prepareString(value, true)
+	if err != nil {
+		return "", err
+	}
+	return strings.NewReplacer(" ", "", "-", "", "\u2010", "", "\u2011", "", "\u2212", "").Replace(value), nil
+}
+
+// Prepare a list of lines separated by "$" for case-insensitive comparison.
+func normalizeCaseIgnoreList(value string) (string, error) {
+	lines := strings.Split(value, "$")
+	for i, line := range lines {
+		var err error
+		if lines[i], err = prepareString(line, true); err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(lines, "$"), nil
+}
+
+// Prepare a boolean for comparison.
+func normalizeBoolean(value string) (string, error) {
+	if !validBoolean(value) {
+		return "", ErrInvalidAttributeValue.WithInfo("reason", "not a boolean")
+	}
+	return value, nil
+}
+
+// Prepare a bit string for comparison.
+func normalizeBitString(value string) (string, error) {
+	if !validBitString(value) {
+		return "", ErrInvalidAttributeValue.WithInfo("reason", "not a bit string")
+	}
+	return value, nil
+}
+
+// Prepare an object identifier for comparison.
+// Names are compared ignoring case, since they cannot be resolved to OIDs without a schema.
+func normalizeOID(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if !validOIDValue(value) {
+		return "", ErrInvalidAttributeValue.WithInfo("reason", "not an OID")
+	}
+	return strings.ToLower(value), nil
+}
+
+// Prepare an integer for comparison in its canonical form without leading zeros.
+func normalizeInteger(value string) (string, error) {
+	digits := strings.TrimPrefix(value, "-")
+	if digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return "", ErrInvalidAttributeValue.WithInfo("reason", "not an integer")
+	}
+	digits = strings.TrimLeft(digits, "0")
+	if digits == "" {
+		return "0", nil
+	}
+	if len(digits) < len(value) && value[0] == '-' {
+		return "-" + digits, nil
+	}
+	return digits, nil
+}
+
+// Compares two integers in canonical form.
+func compareIntegers(a string, b string) int {
+	negA, negB := strings.HasPrefix(a, "-"), strings.HasPrefix(b, "-")
+	if negA != negB {
+		if negA {
+			return -1
+		}
+		return 1
+	}
+	sign := 1
+	if negA {
+		sign = -1
+	}
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -sign
+		}
+		return sign
+	}
+	return sign * strings.Compare(a, b)
+}
+
+var generalizedTimePattern = regexp.MustCompile(
+	`^([0-9]{4})([0-9]{2})([0-9]{2})([0-9]{2})(?:([0-9]{2})([0-9]{2})?)?(?:[.,]([0-9]+))?(Z|[+-][0-9]{2}(?:[0-9]{2})?)$`)
+
+// Parse a generalized time (RFC 4517 section 3.3.13).
+// The fraction applies to the last unit given, e.g. "2024010112.5Z" is 12:30.
+func parseGeneralizedTime(value string) (time.Time, error) {
+	m := generalizedTimePattern.FindStringSubmatch(value)
+	if m == nil {
+		return time.Time{}, ErrInvalidAttributeValue.WithInfo("reason", "not a generalized time")
+	}
+	num := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	year, month, day, hour, minute, second := num(m[1]), num(m[2]), num(m[3]), num(m[4]), num(m[5]), num(m[6])
+	if month < 1 || month > 12 || day < 1 || day > 31 || hour > 23 || minute > 59 || second > 60 {
+		return time.Time{}, ErrInvalidAttributeValue.WithInfo("reason", "time out of range")
+	}
+	loc := time.UTC
+	if zone := m[8]; zone != "Z" {
+		offset := num(zone[1:3])*3600 + num(zone[3:])*60
+		if zone[0] == '-' {
+			offset = -offset
+		}
+		loc = time.FixedZone("", offset)
+	}
+	t := time.Date(year, time.Month(month), day, hour, minute, second, 0, loc)
+	if t.Day() != day && second < 60 {
+		return time.Time{}, ErrInvalidAttributeValue.WithInfo("reason", "day out of range")
+	}
+	if m[7] != "" {
+		unit := time.Second
+		if m[5] == "" {
+			unit = time.Hour
+		} else if m[6] == "" {
+			unit = time.Minute
+		}
+		fraction, _ := strconv.ParseFloat("0."+m[7], 64)
+		t = t.Add(time.Duration(fraction * float64(unit)))
+	}
+	return t, nil
+}
+
+// Prepare a generalized time for comparison as a UTC time with nanoseconds,
+// which sorts bytewise in chronological order.
+func normalizeGeneralizedTime(value string) (string, error) {
+	t, err := parseGeneralizedTime(value)
+	if err != nil {
+		return "", err
+	}
+	return t.UTC().Format("20060102150405.000000000Z"), nil
+}
+
+// Prepare a DN for comparison in the normalized form used by RDN.Equal.
+func normalizeDNValue(value string) (string, error) {
+	dn, err := ParseDN(value)
+	if err != nil {
+		return "", err
+	}
+	return normalizeDN(dn).String(), nil
+}
+
+// Prepare a name and optional UID for comparison, e.g. "cn=John,dc=example#'0101'B".
+func normalizeNameAndOptionalUID(value string) (string, error) {
+	uid := ""
+	if i := strings.LastIndex(value, "#'"); i >= 0 && validBitString(value[i+1:]) {
+		value, uid = value[:i], value[i:]
+	}
+	dn, err := normalizeDNValue(value)
+	if err != nil {
+		return "", err
+	}
+	return dn + uid, nil
+}
+
+// Prepare a value of a schema description for comparison with its first component,
+// e.g. "( 2.5.4.3 NAME 'cn' ... )" for "2.5.4.3".
+// Values that are not descriptions are taken as the component itself.
+func firstComponent(normalize func(string) (string, error)) func(string) (string, error) {
+	return func(value string) (string, error) {
+		if fields := strings.Fields(value); len(fields) > 1 && fields[0] == "(" {
+			value = fields[1]
+		}
+		return normalize(value)
+	}
+}
+
+// Wraps a string preparation function for substrings matching (RFC 4518 section 2.6.2).
+// A substring with only spaces is prepared to the empty string, so it matches any value.
+// Leading and trailing spaces of other substrings are removed like those of values,
+// so e.g. the initial substring "John " also matches "Johnson".
+func forSubstrings(normalize func(string) (string, error)) func(string) (string, error) {
+	return func(value string) (string, error) {
+		value, err := normalize(value)
+		if value == " " {
+			value = ""
+		}
+		return value, err
+	}
+}
+
+// Built-in matching rules (RFC 4517 section 4.2)
+var (
+	ObjectIdentifierMatch = &MatchingRule{
+		OID: "2.5.13.0", Name: "objectIdentifierMatch", Type: MatchingRuleEquality,
+		Normalize: normalizeOID,
+	}
+	DistinguishedNameMatch = &MatchingRule{
+		OID: "2.5.13.1", Name: "distinguishedNameMatch", Type: MatchingRuleEquality,
+		Normalize: normalizeDNValue,
+	}
+	CaseIgnoreMatch = &MatchingRule{
+		OID: "2.5.13.2", Name: "caseIgnoreMatch", Type: MatchingRuleEquality,
+		Normalize: normalizeCaseIgnore,
+	}
+	CaseIgnoreOrderingMatch = &MatchingRule{
+		OID: "2.5.13.3", Name: "caseIgnoreOrderingMatch", Type: MatchingRuleOrdering,
+		Normalize: normalizeCaseIgnore,
+	}
+	CaseIgnoreSubstringsMatch = &MatchingRule{
+		OID: "2.5.13.4", Name: "caseIgnoreSubstringsMatch", Type: MatchingRuleSubstrings,
+		Normalize: forSubstrings(normalizeCaseIgnore),
+	}
+	CaseExactMatch = &MatchingRule{
+		OID: "2.5.13.5", Name: "caseExactMatch", Type: MatchingRuleEquality,
+		Normalize: normalizeSpaces,
+	}
+	CaseExactOrderingMatch = &MatchingRule{
+		OID: "2.5.13.6", Name: "caseExactOrderingMatch", Type: MatchingRuleOrdering,
+		Normalize: normalizeSpaces,
+	}
+	CaseExactSubstringsMatch = &MatchingRule{
+		OID: "2.5.13.7", Name: "caseExactSubstringsMatch", Type: MatchingRuleSubstrings,
+		Normalize: forSubstrings(normalizeSpaces),
+	}
+	NumericStringMatch = &MatchingRule{
+		OID: "2.5.13.8", Name: "numericStringMatch", Type: MatchingRuleEquality,
+		Normalize: normalizeNumericString,
+	}
+	NumericStringOrderingMatch = &MatchingRule{
+		OID: "2.5.13.9", Name: "numericStringOrderingMatch", Type: MatchingRuleOrdering,
+		Normalize: normalizeNumericString,
+	}
+	NumericStringSubstringsMatch = &MatchingRule{
+		OID: "2.5.13.10", Name: "numericStringSubstringsMatch", Type: MatchingRuleSubstrings,
+		Normalize: normalizeNumericString,
+	}
+	CaseIgnoreListMatch = &MatchingRule{
+		OID: "2.5.13.11", Name: "caseIgnoreListMatch", Type: MatchingRuleEquality,
+		Normalize: normalizeCaseIgnoreList,
+	}
+	CaseIgnoreListSubstringsMatch = &MatchingRule{
+		OID: "2.5.13.12", Name: "caseIgnoreListSubstringsMatch", Type: MatchingRuleSubstrings,
+		Normalize: forSubstrings(normalizeCaseIgnoreList),
+	}
+	BooleanMatch = &MatchingRule{
+		OID: "2.5.13.13", Name: "booleanMatch", Type: MatchingRuleEquality,
+		Normalize: normalizeBoolean,
+	}
+	IntegerMatch = &MatchingRule{
+		OID: "2.5.13.14", Name: "integerMatch", Type: MatchingRuleEquality,
+		Normalize: normalizeInteger,
+	}
+	IntegerOrderingMatch = &MatchingRule{
+		OID: "2.5.13.15", Name: "integerOrderingMatch", Type: MatchingRuleOrdering,
+		Normalize: normalizeInteger, Compare: compareIntegers,
+	}
+	BitStringMatch = &MatchingRule{
+		OID: "2.5.13.16", Name: "bitStringMatch", Type: MatchingRuleEquality,
+		Normalize: normalizeBitString,
+	}
+	OctetStringMatch = &MatchingRule{
+		OID: "2.5.13.17", Name: "octetStringMatch", Type: MatchingRuleEquality,
+	}
+	OctetStringOrderingMatch = &MatchingRule{
+		OID: "2.5.13.18", Name: "octetStringOrderingMatch", Type: MatchingRuleOrdering,
+	}
+	TelephoneNumberMatch = &MatchingRule{
+		OID: "2.5.13.20", Name: "telephoneNumberMatch", Type: MatchingRuleEquality,
+		Normalize: normalizeTelephoneNumber,
+	}
+	TelephoneNumberSubstringsMatch = &MatchingRule{
+		OID: "2.5.13.21", Name: "telephoneNumberSubstringsMatch", Type: MatchingRuleSubstrings,
+		Normalize: normalizeTelephoneNumber,
+	}
+	UniqueMemberMatch = &MatchingRule{
+		OID: "2.5.13.23", Name: "uniqueMemberMatch", Type: MatchingRuleEquality,
+		Normalize: normalizeNameAndOptionalUID,
+	}
+	GeneralizedTimeMatch = &MatchingRule{
+		OID: "2.5.13.27", Name: "generalizedTimeMatch", Type: MatchingRuleEquality,
+		Normalize: normalizeGeneralizedTime,
+	}
+	GeneralizedTimeOrderingMatch = &MatchingRule{
+		OID: "2.5.13.28", Name: "generalizedTimeOrderingMatch", Type: MatchingRuleOrdering,
+		Normalize: normalizeGeneralizedTime,
+	}
+	IntegerFirstComponentMatch = &MatchingRule{
+		OID: "2.5.13.29", Name: "integerFirstComponentMatch", Type: MatchingRuleEquality,
+		Normalize: firstComponent(normalizeInteger),
+	}
+	ObjectIdentifierFirstComponentMatch = &MatchingRule{
+		OID: "2.5.13.30", Name: "objectIdentifierFirstComponentMatch", Type: MatchingRuleEquality,
+		Normalize: firstComponent(normalizeOID),
+	}
+	CaseExactIA5Match = &MatchingRule{
+		OID: "1.3.6.1.4.1.1466.109.114.1", Name: "caseExactIA5Match", Type: MatchingRuleEquality,
+		Normalize: normalizeIA5,
+	}
+	CaseIgnoreIA5Match = &MatchingRule{
+		OID: "1.3.6.1.4.1.1466.109.114.2", Name: "caseIgnoreIA5Match", Type: MatchingRuleEquality,
+		Normalize: normalizeIA5CaseIgnore,
+	}
+	CaseIgnoreIA5SubstringsMatch = &MatchingRule{
+		OID: "1.3.6.1.4.1.1466.109.114.3", Name: "caseIgnoreIA5SubstringsMatch", Type: MatchingRuleSubstrings,
+		Normalize: forSubstrings(normalizeIA5CaseIgnore),
+	}
+)
+
+// The matching rules used by Filter.Match.
+// Attributes use case-insensitive matching unless assigned other rules.
+var DefaultMatchingRules = newDefaultMatchingRules()
+
+func newDefaultMatchingRules() *MatchingRuleRegistry {
+	r := NewMatchingRuleRegistry()
+	r.Register(
+		ObjectIdentifierMatch, DistinguishedNameMatch,
+		CaseIgnoreMatch, CaseIgnoreOrderingMatch, CaseIgnoreSubstringsMatch,
+		CaseExactMatch, CaseExactOrderingMatch, CaseExactSubstringsMatch,
+		NumericStringMatch, NumericStringOrderingMatch, NumericStringSubstringsMatch,
+		CaseIgnoreListMatch, CaseIgnoreListSubstringsMatch,
+		BooleanMatch, IntegerMatch, IntegerOrderingMatch, BitStringMatch,
+		OctetStringMatch, OctetStringOrderingMatch,
+		TelephoneNumberMatch, TelephoneNumberSubstringsMatch, UniqueMemberMatch,
+		GeneralizedTimeMatch, GeneralizedTimeOrderingMatch,
+		IntegerFirstComponentMatch, ObjectIdentifierFirstComponentMatch,
+		CaseExactIA5Match, CaseIgnoreIA5Match, CaseIgnoreIA5SubstringsMatch,
+	)
+	r.SetAttributeRules("", "caseIgnoreMatch", "caseIgnoreOrderingMatch", "caseIgnoreSubstringsMatch")
+	r.SetAttributeRules("userPassword", "octetStringMatch", "", "")
+	return r
+}
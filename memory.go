@@ -0,0 +1,606 @@
+package ldapserver
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// In-memory directory backend.
+//
+// Stores a tree of entries keyed by DN and implements the Add, Delete, Modify,
+// ModifyDN, Compare and Search operations with the result codes required by RFC 4511.
+// DNs are compared ignoring the case of attribute types and values.
+// Search filters and Compare assertions are evaluated using the matching rules.
+// Other operations are handled by the embedded BaseHandler.
+//
+// The zero value is an empty directory ready to use.
+type MemoryHandler struct {
+	BaseHandler
+	// Matching rules for evaluating filters and assertions, e.g. a *Schema.
+	// If nil, the Schema is used if set, otherwise DefaultMatchingRules.
+	MatchingRules MatchingRuleResolver
+	// Schema that added and modified entries are validated against.
+	// If nil, entries are not validated.
+	Schema *Schema
+	// Lock protecting the entries
+	lock sync.RWMutex
+	// Entries keyed by their normalized DN string
+	entries map[string]*memoryEntry
+}
+
+// An entry stored in a MemoryHandler
+type memoryEntry struct {
+	// The stored entry
+	entry *Entry
+	// Normalized DN used for scoping comparisons
+	normDN DN
+}
+
+// Create a new empty in-memory directory.
+func NewMemoryHandler() *MemoryHandler {
+	return &MemoryHandler{
+		entries: make(map[string]*memoryEntry),
+	}
+}
+
+// Add an entry to the directory, e.g. to populate it before serving.
+// The parent entry must already exist unless none of the entry's superiors exist,
+// in which case the entry becomes the root of a new naming context.
+// If the entry could not be added, the returned error is a *Result describing the problem.
+func (h *MemoryHandler) AddEntry(entry *Entry) error {
+	if h.Schema != nil {
+		if res := h.Schema.ValidateEntry(entry); res != nil {
+			return res
+		}
+	}
+	res := h.addEntry(entry.Clone(), true)
+	if res.ResultCode != ResultSuccess {
+		return res
+	}
+	return nil
+}
+
+// Returns a copy of the entry with the specified DN, or nil if it does not exist.
+func (h *MemoryHandler) GetEntry(dn DN) *Entry {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	me := h.entries[memoryKey(dn)]
+	if me == nil {
+		return nil
+	}
+	return me.entry.Clone()
+}
+
+func (h *MemoryHandler) Add(ctx context.Context, conn *Conn, msg *Message, req *AddRequest) {
+	dn, err := ParseDN(req.Entry)
+	if err != nil {
+		conn.SendResult(msg.MessageID, nil, TypeAddResponseOp,
+			ResultInvalidDNSyntax.AsResult("the provided DN is invalid"))
+		return
+	}
+	if h.Schema != nil {
+		if res := h.Schema.ValidateAddRequest(req); res != nil {
+			conn.SendResult(msg.MessageID, nil, TypeAddResponseOp, res)
+			return
+		}
+	}
+	entry := &Entry{DN: dn}
+	for _, attr := range req.Attributes {
+		entry.Attributes = append(entry.Attributes, Attribute{
+			Description: attr.Description,
+			Values:      append([]string(nil), attr.Values...),
+		})
+	}
+	conn.SendResult(msg.MessageID, nil, TypeAddResponseOp, h.addEntry(entry, false))
+}
+
+func (h *MemoryHandler) Compare(ctx context.Context, conn *Conn, msg *Message, req *CompareRequest) {
+	conn.SendResult(msg.MessageID, nil, TypeCompareResponseOp, h.compareEntry(req))
+}
+
+func (h *MemoryHandler) Delete(ctx context.Context, conn *Conn, msg *Message, dn string) {
+	conn.SendResult(msg.MessageID, nil, TypeDeleteResponseOp, h.deleteEntry(dn))
+}
+
+func (h *MemoryHandler) Modify(ctx context.Context, conn *Conn, msg *Message, req *ModifyRequest) {
+	conn.SendResult(msg.MessageID, nil, TypeModifyResponseOp, h.modifyEntry(req))
+}
+
+func (h *MemoryHandler) ModifyDN(ctx context.Context, conn *Conn, msg *Message, req *ModifyDNRequest) {
+	conn.SendResult(msg.MessageID, nil, TypeModifyDNResponseOp, h.modifyEntryDN(req))
+}
+
+func (h *MemoryHandler) Search(ctx context.Context, conn *Conn, msg *Message, req *SearchRequest) {
+	entries, res := h.searchEntries(req)
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+		err := conn.SendResult(msg.MessageID, nil, TypeSearchResultEntryOp, entry)
+		if err != nil {
+			return
+		}
+	}
+	switch ctx.Err() {
+	case nil:
+		conn.SendResult(msg.MessageID, nil, TypeSearchResultDoneOp, res)
+	case context.DeadlineExceeded:
+		conn.SendResult(msg.MessageID, nil, TypeSearchResultDoneOp,
+			ResultTimeLimitExceeded.AsResult("the time limit was exceeded"))
+	default:
+		// Abandoned or disconnected
+	}
+}
+
+// Publishes the DNs of the entries without a stored parent as naming contexts.
+func (h *MemoryHandler) AddToRootDSE(dse *RootDSE) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	var roots []*memoryEntry
+	for _, me := range h.entries {
+		if h.entries[me.normDN[:len(me.normDN)-1].String()] == nil {
+			roots = append(roots, me)
+		}
+	}
+	sort.Slice(roots, func(i, j int) bool {
+		return roots[i].normDN.String() < roots[j].normDN.String()
+	})
+	for _, me := range roots {
+		dse.addNamingContext(me.entry.DN.String())
+	}
+}
+
+// Store a new entry. The entry must not be referenced elsewhere.
+// If newContext is true, the entry may be the root of a new naming context.
+func (h *MemoryHandler) addEntry(entry *Entry, newContext bool) *Result {
+	if len(entry.DN) == 0 {
+		return ResultUnwillingToPerform.AsResult("the root DSE cannot be added")
+	}
+	// Merge duplicate attribute descriptions and reject empty or duplicate values
+	var attributes []Attribute
+	for _, attr := range entry.Attributes {
+		if len(attr.Values) == 0 {
+			return ResultProtocolError.AsResult("no values given for attribute " + attr.Description)
+		}
+		var existing *Attribute
+		for i := range attributes {
+			if strings.EqualFold(attributes[i].Description, attr.Description) {
+				existing = &attributes[i]
+				break
+			}
+		}
+		if existing == nil {
+			attributes = append(attributes, Attribute{Description: attr.Description})
+			existing = &attributes[len(attributes)-1]
+		}
+		for _, v := range attr.Values {
+			if containsValue(existing.Values, v) {
+				return ResultAttributeOrValueExists.AsResult("duplicate value given for attribute " + attr.Description)
+			}
+			existing.Values = append(existing.Values, v)
+		}
+	}
+	entry.Attributes = attributes
+	if !hasRDNValues(entry, h.matchingRules()) {
+		return ResultNamingViolation.AsResult("the RDN attribute values are not present in the entry")
+	}
+	normDN := normalizeDN(entry.DN)
+	key := normDN.String()
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.entries == nil {
+		h.entries = make(map[string]*memoryEntry)
+	}
+	if h.entries[key] != nil {
+		return ResultEntryAlreadyExists.AsResult("the entry already exists")
+	}
+	parent := normDN[:len(normDN)-1]
+	if len(parent) > 0 && h.entries[parent.String()] == nil {
+		res := h.noSuchObject(parent, "the parent entry does not exist")
+		if !newContext || res.MatchedDN != "" {
+			return res
+		}
+	}
+	h.entries[key] = &memoryEntry{entry: entry, normDN: normDN}
+	return ResultSuccess.AsResult("")
+}
+
+// Compare an attribute value of an entry.
+func (h *MemoryHandler) compareEntry(req *CompareRequest) *Result {
+	dn, err := ParseDN(req.Object)
+	if err != nil {
+		return ResultInvalidDNSyntax.AsResult("the provided DN is invalid")
+	}
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	normDN := normalizeDN(dn)
+	me := h.entries[normDN.String()]
+	if me == nil {
+		return h.noSuchObject(normDN, "the entry does not exist")
+	}
+	values := attributeValues(me.entry, req.Attribute, h.matchingRules())
+	if len(values) == 0 {
+		return ResultNoSuchAttribute.AsResult("the entry does not have the specified attribute")
+	}
+	rule := h.matchingRules().EqualityRule(req.Attribute)
+	if rule == nil {
+		return ResultInappropriateMatching.AsResult("the attribute has no equality matching rule")
+	}
+	switch matchValues(values, func(v string) FilterResult { return rule.Equal(v, req.Value) }) {
+	case FilterTrue:
+		return ResultCompareTrue.AsResult("")
+	case FilterUndefined:
+		return ResultInvalidAttributeSyntax.AsResult("the assertion value is invalid")
+	}
+	return ResultCompareFalse.AsResult("")
+}
+
+// Returns the matching rules to use for filters and assertions.
+func (h *MemoryHandler) matchingRules() MatchingRuleResolver {
+	if h.MatchingRules != nil {
+		return h.MatchingRules
+	}
+	if h.Schema != nil {
+		return h.Schema
+	}
+	return DefaultMatchingRules
+}
+
+// Delete a leaf entry.
+func (h *MemoryHandler) deleteEntry(dnString string) *Result {
+	dn, err := ParseDN(dnString)
+	if err != nil {
+		return ResultInvalidDNSyntax.AsResult("the provided DN is invalid")
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	normDN := normalizeDN(dn)
+	key := normDN.String()
+	if h.entries[key] == nil {
+		return h.noSuchObject(normDN, "the entry does not exist")
+	}
+	for _, me := range h.entries {
+		if normDN.IsParent(me.normDN) {
+			return ResultNotAllowedOnNonLeaf.AsResult("the entry has subordinate entries")
+		}
+	}
+	delete(h.entries, key)
+	return ResultSuccess.AsResult("")
+}
+
+// Apply the changes of a Modify request atomically.
+func (h *MemoryHandler) modifyEntry(req *ModifyRequest) *Result {
+	dn, err := ParseDN(req.Object)
+	if err != nil {
+		return ResultInvalidDNSyntax.AsResult("the provided DN is invalid")
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	normDN := normalizeDN(dn)
+	me := h.entries[normDN.String()]
+	if me == nil {
+		return h.noSuchObject(normDN, "the entry does not exist")
+	}
+	if h.Schema != nil {
+		if res := h.Schema.ValidateModifyRequest(req, me.entry); res != nil {
+			return res
+		}
+	}
+	// Work on a copy so that a failed change leaves the entry untouched
+	entry := me.entry.Clone()
+	for _, change := range req.Changes {
+		if res := applyModifyChange(entry, &change); res != nil {
+			return res
+		}
+	}
+	if !hasRDNValues(entry, h.matchingRules()) {
+		return ResultNotAllowedOnRDN.AsResult("the RDN attribute values cannot be removed")
+	}
+	me.entry = entry
+	return ResultSuccess.AsResult("")
+}
+
+// Apply a single Modify change to the entry.
+// Returns nil on success.
+func applyModifyChange(entry *Entry, change *ModifyChange) *Result {
+	mod := &change.Modification
+	switch change.Operation {
+	case ModifyAdd:
+		if len(mod.Values) == 0 {
+			return ResultProtocolError.AsResult("no values given to add to attribute " + mod.Description)
+		}
+		attr := entry.GetAttribute(mod.Description)
+		if attr == nil {
+			entry.Attributes = append(entry.Attributes, Attribute{Description: mod.Description})
+			attr = &entry.Attributes[len(entry.Attributes)-1]
+		}
+		for _, v := range mod.Values {
+			if containsValue(attr.Values, v) {
+				return ResultAttributeOrValueExists.AsResult("the value already exists in attribute " + mod.Description)
+			}
+			attr.Values = append(attr.Values, v)
+		}
+	case ModifyDelete:
+		attr := entry.GetAttribute(mod.Description)
+		if attr == nil {
+			return ResultNoSuchAttribute.AsResult("the entry does not have attribute " + mod.Description)
+		}
+		for _, v := range mod.Values {
+			i := indexOfValue(attr.Values, v)
+			if i < 0 {
+				return ResultNoSuchAttribute.AsResult("the value does not exist in attribute " + mod.Description)
+			}
+			attr.Values = append(attr.Values[:i], attr.Values[i+1:]...)
+		}
+		if len(mod.Values) == 0 || len(attr.Values) == 0 {
+			removeAttribute(entry, mod.Description)
+		}
+	case ModifyReplace:
+		removeAttribute(entry, mod.Description)
+		if len(mod.Values) > 0 {
+			attr := Attribute{Description: mod.Description}
+			for _, v := range mod.Values {
+				if containsValue(attr.Values, v) {
+					return ResultAttributeOrValueExists.AsResult("duplicate value given for attribute " + mod.Description)
+				}
+				attr.Values = append(attr.Values, v)
+			}
+			entry.Attributes = append(entry.Attributes, attr)
+		}
+	default:
+		return ResultProtocolError.AsResult("unknown Modify operation")
+	}
+	return nil
+}
+
+// Rename and/or move an entry together with its subordinates.
+func (h *MemoryHandler) modifyEntryDN(req *ModifyDNRequest) *Result {
+	dn, err := ParseDN(req.Object)
+	if err != nil {
+		return ResultInvalidDNSyntax.AsResult("the provided DN is invalid")
+	}
+	newRDN, err := ParseDN(req.NewRDN)
+	if err != nil || len(newRDN) != 1 {
+		return ResultInvalidDNSyntax.AsResult("the provided new RDN is invalid")
+	}
+	var newSuperior DN
+	if req.HasNewSuperior {
+		newSuperior, err = ParseDN(req.NewSuperior)
+		if err != nil {
+			return ResultInvalidDNSyntax.AsResult("the provided new superior DN is invalid")
+		}
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	normDN := normalizeDN(dn)
+	oldKey := normDN.String()
+	me := h.entries[oldKey]
+	if me == nil {
+		return h.noSuchObject(normDN, "the entry does not exist")
+	}
+	parent := dn[:len(dn)-1]
+	if req.HasNewSuperior {
+		normSuperior := normalizeDN(newSuperior)
+		if len(normSuperior) > 0 && h.entries[normSuperior.String()] == nil {
+			return h.noSuchObject(normSuperior, "the new superior entry does not exist")
+		}
+		if normDN.Equal(normSuperior) || normDN.IsSuperior(normSuperior) {
+			return ResultUnwillingToPerform.AsResult("an entry cannot be moved below itself")
+		}
+		parent = newSuperior
+	}
+	newDN := parent.WithRDN(newRDN[0])
+	newNormDN := normalizeDN(newDN)
+	newKey := newNormDN.String()
+	if newKey != oldKey && h.entries[newKey] != nil {
+		return ResultEntryAlreadyExists.AsResult("an entry with the new DN already exists")
+	}
+	// Update the RDN attribute values
+	entry := me.entry.Clone()
+	entry.DN = newDN
+	if req.DeleteOldRDN {
+		newRDNNorm := newNormDN[len(newNormDN)-1]
+		oldDN := me.entry.DN
+		for _, attr := range oldDN[len(oldDN)-1] {
+			if rdnContains(newRDNNorm, normalizeDN(DN{{attr}})[0][0]) {
+				continue
+			}
+			if a := entry.GetAttribute(attr.Type); a != nil {
+				if i := indexOfEqualValue(a.Values, attr.Type, attr.Value, h.matchingRules()); i >= 0 {
+					removeAttributeValue(entry, attr.Type, a.Values[i])
+				}
+			}
+		}
+	}
+	for _, attr := range newRDN[0] {
+		a := entry.GetAttribute(attr.Type)
+		if a == nil {
+			entry.Attributes = append(entry.Attributes, Attribute{Description: attr.Type, Values: []string{attr.Value}})
+		} else if indexOfEqualValue(a.Values, attr.Type, attr.Value, h.matchingRules()) < 0 {
+			a.Values = append(a.Values, attr.Value)
+		}
+	}
+	// Move the entry and its subordinates
+	var subordinates []*memoryEntry
+	for key, sub := range h.entries {
+		if normDN.IsSuperior(sub.normDN) {
+			subordinates = append(subordinates, sub)
+			delete(h.entries, key)
+		}
+	}
+	delete(h.entries, oldKey)
+	h.entries[newKey] = &memoryEntry{entry: entry, normDN: newNormDN}
+	for _, sub := range subordinates {
+		subDN := append(append(DN(nil), newDN...), sub.entry.DN[len(dn):]...)
+		sub.entry.DN = subDN
+		sub.normDN = normalizeDN(subDN)
+		h.entries[sub.normDN.String()] = sub
+	}
+	return ResultSuccess.AsResult("")
+}
+
+// Find the entries matching a Search request.
+func (h *MemoryHandler) searchEntries(req *SearchRequest) ([]*SearchResultEntry, *Result) {
+	base, err := ParseDN(req.BaseObject)
+	if err != nil {
+		return nil, ResultInvalidDNSyntax.AsResult("the provided base DN is invalid")
+	}
+	if req.Scope > SearchScopeSubordinateSubtree {
+		return nil, ResultProtocolError.AsResult("the requested search scope is not supported")
+	}
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	normBase := normalizeDN(base)
+	// The empty DN always exists as the root of the tree
+	if len(normBase) > 0 && h.entries[normBase.String()] == nil {
+		return nil, h.noSuchObject(normBase, "the base entry does not exist")
+	}
+	var matches []*memoryEntry
+	for _, me := range h.entries {
+		if !inSearchScope(normBase, req.Scope, me.normDN) {
+			continue
+		}
+		if req.Filter != nil && req.Filter.MatchWith(me.entry, h.matchingRules()) != FilterTrue {
+			continue
+		}
+		matches = append(matches, me)
+	}
+	// Return superiors before subordinates, in a stable order
+	sort.Slice(matches, func(i, j int) bool {
+		if len(matches[i].normDN) != len(matches[j].normDN) {
+			return len(matches[i].normDN) < len(matches[j].normDN)
+		}
+		return matches[i].normDN.String() < matches[j].normDN.String()
+	})
+	res := ResultSuccess.AsResult("")
+	if req.SizeLimit > 0 && len(matches) > int(req.SizeLimit) {
+		matches = matches[:req.SizeLimit]
+		res = ResultSizeLimitExceeded.AsResult("the size limit was exceeded")
+	}
+	entries := make([]*SearchResultEntry, len(matches))
+	for i, me := range matches {
+		entries[i] = me.entry.SearchResultEntry(req.Attributes, req.TypesOnly)
+	}
+	return entries, res
+}
+
+// Returns a noSuchObject result with the MatchedDN set to the closest existing superior.
+// The lock must be held by the caller.
+func (h *MemoryHandler) noSuchObject(normDN DN, diagnosticMessage string) *Result {
+	res := ResultNoSuchObject.AsResult(diagnosticMessage)
+	for i := len(normDN) - 1; i > 0; i-- {
+		if me := h.entries[normDN[:i].String()]; me != nil {
+			res.MatchedDN = me.entry.DN.String()
+			break
+		}
+	}
+	return res
+}
+
+// Returns true if the DN is within the scope of a search from the base DN.
+func inSearchScope(base DN, scope SearchScope, dn DN) bool {
+	switch scope {
+	case SearchScopeBaseObject:
+		return base.Equal(dn)
+	case SearchScopeSingleLevel:
+		return base.IsParent(dn)
+	case SearchScopeWholeSubtree:
+		return base.Equal(dn) || base.IsSuperior(dn)
+	case SearchScopeSubordinateSubtree:
+		return base.IsSuperior(dn)
+	}
+	return false
+}
+
+// Returns the key under which an entry with the DN is stored.
+func memoryKey(dn DN) string {
+	return normalizeDN(dn).String()
+}
+
+// Returns a copy of the DN with each RDN in the form used for comparisons.
+func normalizeDN(dn DN) DN {
+	norm := make(DN, len(dn))
+	for i, rdn := range dn {
+		norm[i] = rdn.normalize()
+	}
+	return norm
+}
+
+// Returns true if the normalized RDN contains the normalized attribute.
+func rdnContains(rdn RDN, attr RDNAttribute) bool {
+	for _, a := range rdn {
+		if a == attr {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns false if any of the entry's RDN attribute values is missing from its attributes.
+func hasRDNValues(entry *Entry, rules MatchingRuleResolver) bool {
+	if len(entry.DN) == 0 {
+		return true
+	}
+	for _, attr := range entry.DN[len(entry.DN)-1] {
+		if indexOfEqualValue(attributeValues(entry, attr.Type, rules), attr.Type, attr.Value, rules) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the index of the first value equal to the value by the attribute's equality rule,
+// or -1 if not present. Values are compared as-is if the attribute has no equality rule.
+func indexOfEqualValue(values []string, description string, value string, rules MatchingRuleResolver) int {
+	rule := rules.EqualityRule(description)
+	if rule == nil {
+		return indexOfValue(values, value)
+	}
+	for i, v := range values {
+		if rule.Equal(v, value) == FilterTrue {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns true if the value is in the list of values.
+func containsValue(values []string, value string) bool {
+	return indexOfValue(values, value) >= 0
+}
+
+// Returns the index of the value in the list of values, or -1 if not present.
+func indexOfValue(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// Removes the attribute with the specified description from the entry.
+func removeAttribute(entry *Entry, description string) {
+	for i := range entry.Attributes {
+		if strings.EqualFold(entry.Attributes[i].Description, description) {
+			entry.Attributes = append(entry.Attributes[:i], entry.Attributes[i+1:]...)
+			return
+		}
+	}
+}
+
+// Removes a value of an attribute from the entry, removing the attribute if no values are left.
+func removeAttributeValue(entry *Entry, description string, value string) {
+	attr := entry.GetAttribute(description)
+	if attr == nil {
+		return
+	}
+	if i := indexOfValue(attr.Values, value); i >= 0 {
+		attr.Values = append(attr.Values[:i], attr.Values[i+1:]...)
+	}
+	if len(attr.Values) == 0 {
+		removeAttribute(entry, description)
+	}
+}
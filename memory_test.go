@@ -0,0 +1,326 @@
+package ldapserver_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/merlinz01/ldapserver"
+)
+
+// Start a server for the handler and return a client connection to it
+func startTestServer(t *testing.T, handler ldapserver.Handler) net.Conn {
+	t.Helper()
+	server := ldapserver.NewLDAPServer(handler)
+	server.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error listening:", err)
+	}
+	go server.Serve(listener)
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal("Error dialing:", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		server.Shutdown(context.Background())
+	})
+	return conn
+}
+
+// Send a request and return the responses up to and including the final one
+func doRequest(t *testing.T, conn net.Conn, id ldapserver.MessageID, optype ldapserver.BerType, data []byte) []*ldapserver.Message {
+	t.Helper()
+	msg := ldapserver.Message{MessageID: id}
+	msg.ProtocolOp.Type = optype
+	msg.ProtocolOp.Data = data
+	if _, err := conn.Write(msg.EncodeWithHeader()); err != nil {
+		t.Fatal("Error sending request:", err)
+	}
+	var responses []*ldapserver.Message
+	for {
+		res, err := ldapserver.ReadLDAPMessage(conn)
+		if err != nil {
+			t.Fatal("Error reading response:", err)
+		}
+		if res.MessageID != id {
+			t.Fatal("wrong message ID", res.MessageID)
+		}
+		responses = append(responses, res)
+		if res.ProtocolOp.Type != ldapserver.TypeSearchResultEntryOp &&
+			res.ProtocolOp.Type != ldapserver.TypeSearchResultReferenceOp {
+			return responses
+		}
+	}
+}
+
+// Send a request and return the parsed final result
+func doResult(t *testing.T, conn net.Conn, id ldapserver.MessageID, optype ldapserver.BerType, data []byte) *ldapserver.Result {
+	t.Helper()
+	responses := doRequest(t, conn, id, optype, data)
+	res, err := ldapserver.GetResult(responses[len(responses)-1].ProtocolOp.Data)
+	if err != nil {
+		t.Fatal("Error parsing result:", err)
+	}
+	return res
+}
+
+func encodeAttribute(description string, values ...string) []byte {
+	attr := ldapserver.Attribute{Description: description, Values: values}
+	return ldapserver.BerEncodeSequence(attr.Encode())
+}
+
+func encodeAddRequest(dn string, attrs ...[]byte) []byte {
+	return append(ldapserver.BerEncodeOctetString(dn),
+		ldapserver.BerEncodeSequence(bytes.Join(attrs, nil))...)
+}
+
+func encodeModifyRequest(dn string, op ldapserver.ModifyOperation, attr []byte) []byte {
+	change := ldapserver.BerEncodeSequence(append(ldapserver.BerEncodeEnumerated(int64(op)), attr...))
+	return append(ldapserver.BerEncodeOctetString(dn), ldapserver.BerEncodeSequence(change)...)
+}
+
+func encodeSearchRequest(base string, scope ldapserver.SearchScope, filter []byte) []byte {
+	b := bytes.NewBuffer(nil)
+	b.Write(ldapserver.BerEncodeOctetString(base))
+	b.Write(ldapserver.BerEncodeEnumerated(int64(scope)))
+	b.Write(ldapserver.BerEncodeEnumerated(0))
+	b.Write(ldapserver.BerEncodeInteger(0))
+	b.Write(ldapserver.BerEncodeInteger(0))
+	b.Write(ldapserver.BerEncodeBoolean(false))
+	b.Write(filter)
+	b.Write(ldapserver.BerEncodeSequence(nil))
+	return b.Bytes()
+}
+
+func newTestDirectory(t *testing.T) *ldapserver.MemoryHandler {
+	h := ldapserver.NewMemoryHandler()
+	for _, e := range []*ldapserver.Entry{
+		ldapserver.NewEntry(ldapserver.MustParseDN("dc=example,dc=com"),
+			ldapserver.Attribute{Description: "objectClass", Values: []string{"domain"}},
+			ldapserver.Attribute{Description: "dc", Values: []string{"example"}}),
+		ldapserver.NewEntry(ldapserver.MustParseDN("ou=users,dc=example,dc=com"),
+			ldapserver.Attribute{Description: "objectClass", Values: []string{"organizationalUnit"}},
+			ldapserver.Attribute{Description: "ou", Values: []string{"users"}}),
+		ldapserver.NewEntry(ldapserver.MustParseDN("uid=jdoe,ou=users,dc=example,dc=com"),
+			ldapserver.Attribute{Description: "objectClass", Values: []string{"person"}},
+			ldapserver.Attribute{Description: "uid", Values: []string{"jdoe"}},
+			ldapserver.Attribute{Description: "sn", Values: []string{"Doe"}}),
+	} {
+		if err := h.AddEntry(e); err != nil {
+			t.Fatal("Error adding entry:", err)
+		}
+	}
+	return h
+}
+
+func TestMemoryAdd(t *testing.T) {
+	h := newTestDirectory(t)
+	conn := startTestServer(t, h)
+	res := doResult(t, conn, 1, ldapserver.TypeAddRequestOp, encodeAddRequest("uid=jsmith,ou=users,dc=example,dc=com",
+		encodeAttribute("objectClass", "person"), encodeAttribute("uid", "jsmith")))
+	if res.ResultCode != ldapserver.ResultSuccess {
+		t.Fatal("wrong result code", res.ResultCode)
+	}
+	if h.GetEntry(ldapserver.MustParseDN("UID=jsmith,OU=Users,DC=example,DC=com")) == nil {
+		t.Fatal("entry was not added")
+	}
+	res = doResult(t, conn, 2, ldapserver.TypeAddRequestOp, encodeAddRequest("uid=jsmith,ou=users,dc=example,dc=com",
+		encodeAttribute("uid", "jsmith")))
+	if res.ResultCode != ldapserver.ResultEntryAlreadyExists {
+		t.Fatal("wrong result code", res.ResultCode)
+	}
+	res = doResult(t, conn, 3, ldapserver.TypeAddRequestOp, encodeAddRequest("uid=x,ou=missing,ou=users,dc=example,dc=com",
+		encodeAttribute("uid", "x")))
+	if res.ResultCode != ldapserver.ResultNoSuchObject {
+		t.Fatal("wrong result code", res.ResultCode)
+	}
+	if res.MatchedDN != "ou=users,dc=example,dc=com" {
+		t.Fatal("wrong matched DN", res.MatchedDN)
+	}
+	res = doResult(t, conn, 4, ldapserver.TypeAddRequestOp, encodeAddRequest("uid=x,ou=users,dc=example,dc=com",
+		encodeAttribute("uid", "y")))
+	if res.ResultCode != ldapserver.ResultNamingViolation {
+		t.Fatal("wrong result code", res.ResultCode)
+	}
+	// RDN values are matched with the attribute's equality rule
+	res = doResult(t, conn, 5, ldapserver.TypeAddRequestOp, encodeAddRequest("uid=JRoe,ou=users,dc=example,dc=com",
+		encodeAttribute("objectClass", "person"), encodeAttribute("uid", "jroe")))
+	if res.ResultCode != ldapserver.ResultSuccess {
+		t.Fatal("wrong result code", res.ResultCode)
+	}
+}
+
+func TestMemoryDelete(t *testing.T) {
+	h := newTestDirectory(t)
+	conn := startTestServer(t, h)
+	res := doResult(t, conn, 1, ldapserver.TypeDeleteRequestOp, []byte("ou=users,dc=example,dc=com"))
+	if res.ResultCode != ldapserver.ResultNotAllowedOnNonLeaf {
+		t.Fatal("wrong result code", res.ResultCode)
+	}
+	res = doResult(t, conn, 2, ldapserver.TypeDeleteRequestOp, []byte("uid=jdoe,ou=users,dc=example,dc=com"))
+	if res.ResultCode != ldapserver.ResultSuccess {
+		t.Fatal("wrong result code", res.ResultCode)
+	}
+	res = doResult(t, conn, 3, ldapserver.TypeDeleteRequestOp, []byte("uid=jdoe,ou=users,dc=example,dc=com"))
+	if res.ResultCode != ldapserver.ResultNoSuchObject {
+		t.Fatal("wrong result code", res.ResultCode)
+	}
+	if res.MatchedDN != "ou=users,dc=example,dc=com" {
+		t.Fatal("wrong matched DN", res.MatchedDN)
+	}
+}
+
+func TestMemoryModify(t *testing.T) {
+	h := newTestDirectory(t)
+	conn := startTestServer(t, h)
+	dn := "uid=jdoe,ou=users,dc=example,dc=com"
+	res := doResult(t, conn, 1, ldapserver.TypeModifyRequestOp,
+		encodeModifyRequest(dn, ldapserver.ModifyAdd, encodeAttribute("mail", "jdoe@example.com")))
+	if res.ResultCode != ldapserver.ResultSuccess {
+		t.Fatal("wrong result code", res.ResultCode)
+	}
+	res = doResult(t, conn, 2, ldapserver.TypeModifyRequestOp,
+		encodeModifyRequest(dn, ldapserver.ModifyAdd, encodeAttribute("mail", "jdoe@example.com")))
+	if res.ResultCode != ldapserver.ResultAttributeOrValueExists {
+		t.Fatal("wrong result code", res.ResultCode)
+	}
+	res = doResult(t, conn, 3, ldapserver.TypeModifyRequestOp,
+		encodeModifyRequest(dn, ldapserver.ModifyDelete, encodeAttribute("telephoneNumber")))
+	if res.ResultCode != ldapserver.ResultNoSuchAttribute {
+		t.Fatal("wrong result code", res.ResultCode)
+	}
+	res = doResult(t, conn, 4, ldapserver.TypeModifyRequestOp,
+		encodeModifyRequest(dn, ldapserver.ModifyReplace, encodeAttribute("uid", "johndoe")))
+	if res.ResultCode != ldapserver.ResultNotAllowedOnRDN {
+		t.Fatal("wrong result code", res.ResultCode)
+	}
+	res = doResult(t, conn, 5, ldapserver.TypeModifyRequestOp,
+		encodeModifyRequest(dn, ldapserver.ModifyReplace, encodeAttribute("sn", "Smith")))
+	if res.ResultCode != ldapserver.ResultSuccess {
+		t.Fatal("wrong result code", res.ResultCode)
+	}
+	entry := h.GetEntry(ldapserver.MustParseDN(dn))
+	if entry.GetAttributeValue("sn") != "Smith" || entry.GetAttributeValue("mail") != "jdoe@example.com" ||
+		entry.GetAttributeValue("uid") != "jdoe" {
+		t.Fatal("entry was not modified correctly", entry.Attributes)
+	}
+}
+
+func TestMemoryModifyDN(t *testing.T) {
+	h := newTestDirectory(t)
+	conn := startTestServer(t, h)
+	req := bytes.NewBuffer(nil)
+	req.Write(ldapserver.BerEncodeOctetString("ou=users,dc=example,dc=com"))
+	req.Write(ldapserver.BerEncodeOctetString("ou=people"))
+	req.Write(ldapserver.BerEncodeBoolean(true))
+	res := doResult(t, conn, 1, ldapserver.TypeModifyDNRequestOp, req.Bytes())
+	if res.ResultCode != ldapserver.ResultSuccess {
+		t.Fatal("wrong result code", res.ResultCode)
+	}
+	entry := h.GetEntry(ldapserver.MustParseDN("uid=jdoe,ou=people,dc=example,dc=com"))
+	if entry == nil {
+		t.Fatal("subordinate entry was not moved")
+	}
+	if entry.DN.String() != "uid=jdoe,ou=people,dc=example,dc=com" {
+		t.Fatal("wrong subordinate DN", entry.DN)
+	}
+	parent := h.GetEntry(ldapserver.MustParseDN("ou=people,dc=example,dc=com"))
+	if len(parent.GetAttributeValues("ou")) != 1 || parent.GetAttributeValue("ou") != "people" {
+		t.Fatal("RDN values were not updated", parent.Attributes)
+	}
+	req.Reset()
+	req.Write(ldapserver.BerEncodeOctetString("ou=people,dc=example,dc=com"))
+	req.Write(ldapserver.BerEncodeOctetString("ou=staff"))
+	req.Write(ldapserver.BerEncodeBoolean(true))
+	req.Write(ldapserver.BerEncodeElement(ldapserver.BerContextSpecificType(0, false),
+		[]byte("uid=jdoe,ou=people,dc=example,dc=com")))
+	res = doResult(t, conn, 2, ldapserver.TypeModifyDNRequestOp, req.Bytes())
+	if res.ResultCode != ldapserver.ResultUnwillingToPerform {
+		t.Fatal("wrong result code", res.ResultCode)
+	}
+	// Renaming to an equal RDN value doesn't duplicate it
+	req.Reset()
+	req.Write(ldapserver.BerEncodeOctetString("ou=people,dc=example,dc=com"))
+	req.Write(ldapserver.BerEncodeOctetString("ou=People"))
+	req.Write(ldapserver.BerEncodeBoolean(true))
+	res = doResult(t, conn, 3, ldapserver.TypeModifyDNRequestOp, req.Bytes())
+	if res.ResultCode != ldapserver.ResultSuccess {
+		t.Fatal("wrong result code", res.ResultCode)
+	}
+	parent = h.GetEntry(ldapserver.MustParseDN("ou=people,dc=example,dc=com"))
+	if parent.DN.String() != "ou=People,dc=example,dc=com" || len(parent.GetAttributeValues("ou")) != 1 {
+		t.Fatal("RDN values were not updated", parent.DN, parent.Attributes)
+	}
+	// An empty new superior moves the entry under the root
+	res = doResult(t, conn, 4, ldapserver.TypeModifyDNRequestOp, (&ldapserver.ModifyDNRequest{
+		Object: "ou=people,dc=example,dc=com", NewRDN: "ou=people", HasNewSuperior: true,
+	}).Encode())
+	if res.ResultCode != ldapserver.ResultSuccess {
+		t.Fatal("wrong result code", res.ResultCode)
+	}
+	if h.GetEntry(ldapserver.MustParseDN("uid=jdoe,ou=people")) == nil {
+		t.Fatal("entry was not moved under the root")
+	}
+}
+
+func TestMemoryCompare(t *testing.T) {
+	h := newTestDirectory(t)
+	conn := startTestServer(t, h)
+	compare := func(id ldapserver.MessageID, attr string, value string) ldapserver.LDAPResultCode {
+		ava := ldapserver.BerEncodeSequence(append(ldapserver.BerEncodeOctetString(attr),
+			ldapserver.BerEncodeOctetString(value)...))
+		req := append(ldapserver.BerEncodeOctetString("uid=jdoe,ou=users,dc=example,dc=com"), ava...)
+		return doResult(t, conn, id, ldapserver.TypeCompareRequestOp, req).ResultCode
+	}
+	if rc := compare(1, "sn", "Doe"); rc != ldapserver.ResultCompareTrue {
+		t.Fatal("wrong result code", rc)
+	}
+	if rc := compare(2, "sn", "Smith"); rc != ldapserver.ResultCompareFalse {
+		t.Fatal("wrong result code", rc)
+	}
+	if rc := compare(3, "mail", "jdoe@example.com"); rc != ldapserver.ResultNoSuchAttribute {
+		t.Fatal("wrong result code", rc)
+	}
+}
+
+func TestMemorySearch(t *testing.T) {
+	h := newTestDirectory(t)
+	conn := startTestServer(t, h)
+	present := ldapserver.BerEncodeElement(ldapserver.BerContextSpecificType(7, false), []byte("objectClass"))
+	for i, c := range []struct {
+		base  string
+		scope ldapserver.SearchScope
+		dns   []string
+	}{
+		{"dc=example,dc=com", ldapserver.SearchScopeBaseObject, []string{"dc=example,dc=com"}},
+		{"dc=example,dc=com", ldapserver.SearchScopeSingleLevel, []string{"ou=users,dc=example,dc=com"}},
+		{"DC=Example,DC=Com", ldapserver.SearchScopeWholeSubtree, []string{
+			"dc=example,dc=com", "ou=users,dc=example,dc=com", "uid=jdoe,ou=users,dc=example,dc=com"}},
+		{"dc=example,dc=com", ldapserver.SearchScopeSubordinateSubtree, []string{
+			"ou=users,dc=example,dc=com", "uid=jdoe,ou=users,dc=example,dc=com"}},
+	} {
+		responses := doRequest(t, conn, ldapserver.MessageID(i+1), ldapserver.TypeSearchRequestOp,
+			encodeSearchRequest(c.base, c.scope, present))
+		var dns []string
+		for _, r := range responses[:len(responses)-1] {
+			seq, err := ldapserver.BerGetSequence(r.ProtocolOp.Data)
+			if err != nil {
+				t.Fatal("Error parsing entry:", err)
+			}
+			dns = append(dns, ldapserver.BerGetOctetString(seq[0].Data))
+		}
+		if !slicesEqual(dns, c.dns) {
+			t.Fatalf("wrong entries returned for scope %d: %v", c.scope, dns)
+		}
+	}
+	res := doResult(t, conn, 10, ldapserver.TypeSearchRequestOp,
+		encodeSearchRequest("ou=missing,dc=example,dc=com", ldapserver.SearchScopeWholeSubtree, present))
+	if res.ResultCode != ldapserver.ResultNoSuchObject || res.MatchedDN != "dc=example,dc=com" {
+		t.Fatal("wrong result", res.ResultCode, res.MatchedDN)
+	}
+}
@@ -52,13 +52,20 @@ type Message struct {
 	MessageID  MessageID
 	ProtocolOp BerRawElement
 	Controls   []Control
+	// Values of the request controls decoded by the server's ControlRegistry
+	decodedControls map[OID]TypedControl
 }
 
 // Read a Message from the io.Reader.
 // Does not parse the ProtocolOp element data.
 func ReadLDAPMessage(r io.Reader) (*Message, error) {
+	return readLDAPMessage(r, &Limits{})
+}
+
+// Read a Message from the io.Reader, enforcing the message size and control limits
+func readLDAPMessage(r io.Reader, limits *Limits) (*Message, error) {
 	// Read the element
-	raw, err := BerReadElement(r)
+	raw, err := berReadElement(r, limits.MaxMessageSize)
 	if err != nil {
 		return nil, err
 	}
@@ -104,6 +111,9 @@ func ReadLDAPMessage(r io.Reader) (*Message, error) {
 		if err != nil {
 			return nil, err
 		}
+		if limits.MaxControls > 0 && len(c_seq) > limits.MaxControls {
+			return nil, ErrLimitExceeded.WithInfo("number of controls", len(c_seq))
+		}
 		for _, c := range c_seq {
 			// Control ::= SEQUENCE {
 			if c.Type != BerTypeSequence {
@@ -177,7 +187,8 @@ func (msg *Message) EncodeWithHeader() []byte {
 			}
 			csdata.Write(BerEncodeSequence(cdata.Bytes()))
 		}
-		data.Write(BerEncodeSequence(csdata.Bytes()))
+		// controls [0] Controls OPTIONAL
+		data.Write(BerEncodeElement(BerContextSpecificType(0, true), csdata.Bytes()))
 	}
 	return BerEncodeSequence(data.Bytes())
 }
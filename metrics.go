@@ -0,0 +1,215 @@
+package ldapserver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Interface for collecting server metrics.
+// Methods are called concurrently from the connection goroutines.
+type Metrics interface {
+	// Called when a connection is accepted, with whether it uses TLS from the start (LDAPS)
+	ConnectionOpened(isTLS bool)
+	// Called when a connection is closed
+	ConnectionClosed()
+	// Called when TLS is set up on a connection with StartTLS
+	StartTLS()
+	// Called when the final response of an operation is sent
+	OperationCompleted(opType BerType, resultCode LDAPResultCode, elapsed time.Duration)
+	// Called with the size of each LDAP message received
+	BytesReceived(n int)
+	// Called with the size of each LDAP message sent
+	BytesSent(n int)
+}
+
+// Upper bounds in seconds of the operation latency histogram buckets
+var DefaultLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Key of the operation counters
+type operationMetricKey struct {
+	opType     BerType
+	resultCode LDAPResultCode
+}
+
+// Latency histogram of an operation type
+type latencyHistogram struct {
+	// Number of observations in each bucket (not cumulative)
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// A Metrics implementation that keeps counters in memory
+// and exports them in the Prometheus text exposition format.
+// It implements http.Handler for serving a /metrics endpoint.
+// The zero value is ready to use, with the DefaultLatencyBuckets.
+type PrometheusMetrics struct {
+	// Upper bounds in seconds of the latency histogram buckets, in increasing order
+	buckets []float64
+	// Lock protecting the counters
+	lock sync.Mutex
+	// Connections accepted, indexed by whether they use TLS
+	connectionsAccepted [2]uint64
+	connectionsActive   int64
+	startTLS            uint64
+	operations          map[operationMetricKey]uint64
+	latencies           map[BerType]*latencyHistogram
+	bytesReceived       uint64
+	bytesSent           uint64
+}
+
+// Create a new metrics collector with the DefaultLatencyBuckets.
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{
+		buckets:    DefaultLatencyBuckets,
+		operations: make(map[operationMetricKey]uint64),
+		latencies:  make(map[BerType]*latencyHistogram),
+	}
+}
+
+func (m *PrometheusMetrics) ConnectionOpened(isTLS bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if isTLS {
+		m.connectionsAccepted[1]++
+	} else {
+		m.connectionsAccepted[0]++
+	}
+	m.connectionsActive++
+}
+
+func (m *PrometheusMetrics) ConnectionClosed() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.connectionsActive--
+}
+
+func (m *PrometheusMetrics) StartTLS() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.startTLS++
+}
+
+func (m *PrometheusMetrics) OperationCompleted(opType BerType, resultCode LDAPResultCode, elapsed time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.operations == nil {
+		// Zero value
+		m.buckets = DefaultLatencyBuckets
+		m.operations = make(map[operationMetricKey]uint64)
+		m.latencies = make(map[BerType]*latencyHistogram)
+	}
+	m.operations[operationMetricKey{opType, resultCode}]++
+	h := m.latencies[opType]
+	if h == nil {
+		h = &latencyHistogram{counts: make([]uint64, len(m.buckets))}
+		m.latencies[opType] = h
+	}
+	seconds := elapsed.Seconds()
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+func (m *PrometheusMetrics) BytesReceived(n int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.bytesReceived += uint64(n)
+}
+
+func (m *PrometheusMetrics) BytesSent(n int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.bytesSent += uint64(n)
+}
+
+// Write the metrics in the Prometheus text exposition format.
+func (m *PrometheusMetrics) WriteText(w io.Writer) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	b := bufio.NewWriter(w)
+	header := func(name string, mtype string, help string) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, mtype)
+	}
+
+	header("ldap_connections_accepted_total", "counter", "Connections accepted, by whether they use TLS from the start.")
+	fmt.Fprintf(b, "ldap_connections_accepted_total{tls=\"false\"} %d\n", m.connectionsAccepted[0])
+	fmt.Fprintf(b, "ldap_connections_accepted_total{tls=\"true\"} %d\n", m.connectionsAccepted[1])
+	header("ldap_connections_active", "gauge", "Connections currently open.")
+	fmt.Fprintf(b, "ldap_connections_active %d\n", m.connectionsActive)
+	header("ldap_starttls_total", "counter", "Connections upgraded to TLS with StartTLS.")
+	fmt.Fprintf(b, "ldap_starttls_total %d\n", m.startTLS)
+
+	header("ldap_operations_total", "counter", "Operations completed, by operation type and result code.")
+	keys := make([]operationMetricKey, 0, len(m.operations))
+	for key := range m.operations {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].opType != keys[j].opType {
+			return OperationName(keys[i].opType) < OperationName(keys[j].opType)
+		}
+		return keys[i].resultCode < keys[j].resultCode
+	})
+	for _, key := range keys {
+		fmt.Fprintf(b, "ldap_operations_total{op=%q,result_code=\"%d\"} %d\n",
+			OperationName(key.opType), key.resultCode, m.operations[key])
+	}
+
+	header("ldap_operation_duration_seconds", "histogram", "Time from receiving a request to sending its final response.")
+	opTypes := make([]BerType, 0, len(m.latencies))
+	for opType := range m.latencies {
+		opTypes = append(opTypes, opType)
+	}
+	sort.Slice(opTypes, func(i, j int) bool {
+		return OperationName(opTypes[i]) < OperationName(opTypes[j])
+	})
+	for _, opType := range opTypes {
+		h := m.latencies[opType]
+		name := OperationName(opType)
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(b, "ldap_operation_duration_seconds_bucket{op=%q,le=%q} %d\n",
+				name, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(b, "ldap_operation_duration_seconds_bucket{op=%q,le=\"+Inf\"} %d\n", name, h.count)
+		fmt.Fprintf(b, "ldap_operation_duration_seconds_sum{op=%q} %s\n", name, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(b, "ldap_operation_duration_seconds_count{op=%q} %d\n", name, h.count)
+	}
+
+	header("ldap_received_bytes_total", "counter", "Bytes of LDAP messages received.")
+	fmt.Fprintf(b, "ldap_received_bytes_total %d\n", m.bytesReceived)
+	header("ldap_sent_bytes_total", "counter", "Bytes of LDAP messages sent.")
+	fmt.Fprintf(b, "ldap_sent_bytes_total %d\n", m.bytesSent)
+	return b.Flush()
+}
+
+// Serve the metrics in the Prometheus text exposition format.
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteText(w)
+}
+
+// Reader that counts the bytes read
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
@@ -0,0 +1,208 @@
+package ldapserver
+
+import (
+	"context"
+)
+
+// A function that wraps a Handler, e.g. to check or record requests
+// before passing them on to the wrapped handler.
+//
+// Middlewares that only need to change a few operations can embed the wrapped Handler
+// in a struct and override those methods.
+// To see every operation, use Interceptor.
+type Middleware func(next Handler) Handler
+
+// Wraps the handler with the middlewares.
+// The first middleware sees each request first.
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// Function called by an Interceptor for every request.
+//
+// The request is one of *AddRequest, *BindRequest, *CompareRequest,
+// string (the DN of a Delete request), *ExtendedRequest, *ModifyRequest,
+// *ModifyDNRequest, *SearchRequest, MessageID (the target of an Abandon request),
+// or nil for unrecognized requests.
+//
+// Calling next passes the request on to the wrapped handler, with the specified context,
+// and returns when it has finished.
+// To reject the request, send a response (e.g. with RejectRequest) instead of calling next.
+type InterceptorFunc func(ctx context.Context, conn *Conn, msg *Message, req any, next func(context.Context))
+
+// Returns a middleware that calls the function for every request
+func Interceptor(f InterceptorFunc) Middleware {
+	return func(next Handler) Handler {
+		return &interceptor{f: f, next: next}
+	}
+}
+
+// Handler that passes every request through an InterceptorFunc
+type interceptor struct {
+	f    InterceptorFunc
+	next Handler
+}
+
+func (h *interceptor) Abandon(ctx context.Context, conn *Conn, msg *Message, messageID MessageID) {
+	h.f(ctx, conn, msg, messageID, func(ctx context.Context) {
+		h.next.Abandon(ctx, conn, msg, messageID)
+	})
+}
+
+func (h *interceptor) Add(ctx context.Context, conn *Conn, msg *Message, req *AddRequest) {
+	h.f(ctx, conn, msg, req, func(ctx context.Context) {
+		h.next.Add(ctx, conn, msg, req)
+	})
+}
+
+func (h *interceptor) Bind(ctx context.Context, conn *Conn, msg *Message, req *BindRequest) {
+	h.f(ctx, conn, msg, req, func(ctx context.Context) {
+		h.next.Bind(ctx, conn, msg, req)
+	})
+}
+
+func (h *interceptor) Compare(ctx context.Context, conn *Conn, msg *Message, req *CompareRequest) {
+	h.f(ctx, conn, msg, req, func(ctx context.Context) {
+		h.next.Compare(ctx, conn, msg, req)
+	})
+}
+
+func (h *interceptor) Delete(ctx context.Context, conn *Conn, msg *Message, dn string) {
+	h.f(ctx, conn, msg, dn, func(ctx context.Context) {
+		h.next.Delete(ctx, conn, msg, dn)
+	})
+}
+
+func (h *interceptor) Extended(ctx context.Context, conn *Conn, msg *Message, req *ExtendedRequest) {
+	h.f(ctx, conn, msg, req, func(ctx context.Context) {
+		h.next.Extended(ctx, conn, msg, req)
+	})
+}
+
+func (h *interceptor) Modify(ctx context.Context, conn *Conn, msg *Message, req *ModifyRequest) {
+	h.f(ctx, conn, msg, req, func(ctx context.Context) {
+		h.next.Modify(ctx, conn, msg, req)
+	})
+}
+
+func (h *interceptor) ModifyDN(ctx context.Context, conn *Conn, msg *Message, req *ModifyDNRequest) {
+	h.f(ctx, conn, msg, req, func(ctx context.Context) {
+		h.next.ModifyDN(ctx, conn, msg, req)
+	})
+}
+
+func (h *interceptor) Search(ctx context.Context, conn *Conn, msg *Message, req *SearchRequest) {
+	h.f(ctx, conn, msg, req, func(ctx context.Context) {
+		h.next.Search(ctx, conn, msg, req)
+	})
+}
+
+func (h *interceptor) Other(ctx context.Context, conn *Conn, msg *Message) {
+	h.f(ctx, conn, msg, nil, func(ctx context.Context) {
+		h.next.Other(ctx, conn, msg)
+	})
+}
+
+// Passes on the values added by the wrapped handler
+func (h *interceptor) AddToRootDSE(dse *RootDSE) {
+	if p, ok := h.next.(RootDSEProvider); ok {
+		p.AddToRootDSE(dse)
+	}
+}
+
+// Passes on the controls registered by the wrapped handler
+func (h *interceptor) RegisterControls(registry *ControlRegistry) {
+	if p, ok := h.next.(ControlProvider); ok {
+		p.RegisterControls(registry)
+	}
+}
+
+func (h *interceptor) Unwrap() Handler {
+	return h.next
+}
+
+// Response types of the requests that have a response
+var responseTypes = map[BerType]BerType{
+	TypeAddRequestOp:      TypeAddResponseOp,
+	TypeBindRequestOp:     TypeBindResponseOp,
+	TypeCompareRequestOp:  TypeCompareResponseOp,
+	TypeDeleteRequestOp:   TypeDeleteResponseOp,
+	TypeExtendedRequestOp: TypeExtendedResponseOp,
+	TypeModifyRequestOp:   TypeModifyResponseOp,
+	TypeModifyDNRequestOp: TypeModifyDNResponseOp,
+	TypeSearchRequestOp:   TypeSearchResultDoneOp,
+}
+
+// Sends the final response for the request with the specified result code,
+// using the response type that matches the request.
+// Nothing is sent for requests without a response, such as Abandon requests.
+func RejectRequest(conn *Conn, msg *Message, resultCode LDAPResultCode, diagnosticMessage string) {
+	rtype, ok := responseTypes[msg.ProtocolOp.Type]
+	if !ok {
+		return
+	}
+	conn.SendResult(msg.MessageID, nil, rtype, resultCode.AsResult(diagnosticMessage))
+}
+
+// Middleware that rejects Bind requests on connections without TLS
+// with the confidentialityRequired result code.
+func RequireTLSForBind(next Handler) Handler {
+	return Interceptor(func(ctx context.Context, conn *Conn, msg *Message, req any, call func(context.Context)) {
+		if _, ok := req.(*BindRequest); ok && !conn.IsTLS() {
+			RejectRequest(conn, msg, ResultConfidentialityRequired, "TLS is required for the Bind operation")
+			return
+		}
+		call(ctx)
+	})(next)
+}
+
+// Middleware that rejects requests on connections whose Authentication is nil
+// with the strongerAuthRequired result code.
+// Bind, Abandon and StartTLS requests are always allowed.
+// The Root DSE and the subschema subentry are always readable since the server answers them.
+// Unrecognized operations, which have no result to reject them with,
+// are answered with a Notice of Disconnection with the protocolError result code.
+func RequireAuthentication(next Handler) Handler {
+	return Interceptor(func(ctx context.Context, conn *Conn, msg *Message, req any, call func(context.Context)) {
+		if conn.Authentication == nil {
+			allowed := false
+			switch r := req.(type) {
+			case nil:
+				conn.NotifyDisconnect(ResultProtocolError, "operation type not recognized")
+				conn.Close()
+				return
+			case *BindRequest, MessageID:
+				allowed = true
+			case *ExtendedRequest:
+				allowed = r.Name == OIDStartTLS
+			}
+			if !allowed {
+				RejectRequest(conn, msg, ResultStrongerAuthRequired, "authentication required")
+				return
+			}
+		}
+		call(ctx)
+	})(next)
+}
+
+// Middleware that rejects Add, Delete, Modify, ModifyDN and Password Modify requests
+// with the unwillingToPerform result code.
+func ReadOnly(next Handler) Handler {
+	return Interceptor(func(ctx context.Context, conn *Conn, msg *Message, req any, call func(context.Context)) {
+		readOnly := false
+		switch r := req.(type) {
+		case *AddRequest, string, *ModifyRequest, *ModifyDNRequest:
+			readOnly = true
+		case *ExtendedRequest:
+			readOnly = r.Name == OIDPasswordModify
+		}
+		if readOnly {
+			RejectRequest(conn, msg, ResultUnwillingToPerform, "the directory is read-only")
+			return
+		}
+		call(ctx)
+	})(next)
+}
@@ -0,0 +1,118 @@
+package ldapserver_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/merlinz01/ldapserver"
+)
+
+func TestMiddlewareChain(t *testing.T) {
+	var lock sync.Mutex
+	var calls []string
+	record := func(name string) ldapserver.Middleware {
+		return ldapserver.Interceptor(func(ctx context.Context, conn *ldapserver.Conn, msg *ldapserver.Message, req any, next func(context.Context)) {
+			lock.Lock()
+			calls = append(calls, name+" before")
+			lock.Unlock()
+			if dn, ok := req.(string); !ok || dn != "dc=example,dc=com" {
+				t.Error("wrong request", req)
+			}
+			next(ctx)
+			lock.Lock()
+			calls = append(calls, name+" after")
+			lock.Unlock()
+		})
+	}
+	handler := ldapserver.Chain(newTestDirectory(t), record("first"), record("second"))
+	conn := startTestServer(t, handler)
+	res := doResult(t, conn, 1, ldapserver.TypeDeleteRequestOp, []byte("dc=example,dc=com"))
+	if res.ResultCode != ldapserver.ResultNotAllowedOnNonLeaf {
+		t.Fatal("wrong result code", res.ResultCode)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	expected := []string{"first before", "second before", "second after", "first after"}
+	if !slicesEqual(calls, expected) {
+		t.Fatal("wrong calls", calls)
+	}
+}
+
+// Handler recording the operations passed to Other
+type otherHandler struct {
+	ldapserver.BaseHandler
+	calls chan ldapserver.BerType
+}
+
+func (h *otherHandler) Other(ctx context.Context, conn *ldapserver.Conn, msg *ldapserver.Message) {
+	h.calls <- msg.ProtocolOp.Type
+}
+
+func TestBuiltinMiddlewares(t *testing.T) {
+	handler := ldapserver.Chain(newTestDirectory(t),
+		ldapserver.RequireTLSForBind, ldapserver.RequireAuthentication, ldapserver.ReadOnly)
+	conn := startTestServer(t, handler)
+
+	res := doResult(t, conn, 1, ldapserver.TypeBindRequestOp, (&ldapserver.BindRequest{
+		Version: 3, Name: "uid=jdoe,ou=users,dc=example,dc=com", AuthType: ldapserver.AuthenticationTypeSimple, Credentials: "secret",
+	}).Encode())
+	if res.ResultCode != ldapserver.ResultConfidentialityRequired {
+		t.Fatal("wrong Bind result code", res.ResultCode)
+	}
+
+	res = doResult(t, conn, 2, ldapserver.TypeSearchRequestOp, (&ldapserver.SearchRequest{
+		BaseObject: "dc=example,dc=com", Scope: ldapserver.SearchScopeWholeSubtree,
+	}).Encode())
+	if res.ResultCode != ldapserver.ResultStrongerAuthRequired {
+		t.Fatal("wrong Search result code", res.ResultCode)
+	}
+	res = doResult(t, conn, 3, ldapserver.TypeSearchRequestOp, (&ldapserver.SearchRequest{
+		BaseObject: "", Scope: ldapserver.SearchScopeBaseObject,
+	}).Encode())
+	if res.ResultCode == ldapserver.ResultStrongerAuthRequired {
+		t.Fatal("root DSE search rejected")
+	}
+	res = doResult(t, conn, 4, ldapserver.TypeExtendedRequestOp, (&ldapserver.ExtendedRequest{
+		Name: ldapserver.OIDPasswordModify,
+	}).Encode())
+	if res.ResultCode != ldapserver.ResultStrongerAuthRequired {
+		t.Fatal("wrong Extended result code", res.ResultCode)
+	}
+
+	readOnly := ldapserver.ReadOnly(newTestDirectory(t))
+	conn = startTestServer(t, readOnly)
+	res = doResult(t, conn, 1, ldapserver.TypeDeleteRequestOp, []byte("uid=jdoe,ou=users,dc=example,dc=com"))
+	if res.ResultCode != ldapserver.ResultUnwillingToPerform {
+		t.Fatal("wrong Delete result code", res.ResultCode)
+	}
+	res = doResult(t, conn, 2, ldapserver.TypeCompareRequestOp, (&ldapserver.CompareRequest{
+		Object: "uid=jdoe,ou=users,dc=example,dc=com", Attribute: "sn", Value: "Doe",
+	}).Encode())
+	if res.ResultCode != ldapserver.ResultCompareTrue {
+		t.Fatal("wrong Compare result code", res.ResultCode)
+	}
+
+	// Unrecognized operations of unauthenticated clients are not passed on
+	h := &otherHandler{calls: make(chan ldapserver.BerType, 1)}
+	conn = startTestServer(t, ldapserver.RequireAuthentication(h))
+	msg := ldapserver.Message{MessageID: 1}
+	msg.ProtocolOp.Type = ldapserver.BerType(0b01011110)
+	if _, err := conn.Write(msg.EncodeWithHeader()); err != nil {
+		t.Fatal("Error sending request:", err)
+	}
+	notice, err := ldapserver.ReadLDAPMessage(conn)
+	if err != nil {
+		t.Fatal("Error reading notice:", err)
+	}
+	ext, err := ldapserver.GetExtendedResult(notice.ProtocolOp.Data)
+	if err != nil {
+		t.Fatal("Error parsing notice:", err)
+	}
+	if ext.ResponseName != ldapserver.OIDNoticeOfDisconnection || ext.ResultCode != ldapserver.ResultProtocolError {
+		t.Fatal("wrong notice", ext)
+	}
+	if len(h.calls) != 0 {
+		t.Fatal("unrecognized operation passed to the handler")
+	}
+}
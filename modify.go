@@ -1,5 +1,7 @@
 package ldapserver
 
+import "bytes"
+
 // ModifyRequest ::= [APPLICATION 6] SEQUENCE {
 // 	object   LDAPDN,
 // 	changes  SEQUENCE OF change SEQUENCE {
@@ -78,3 +80,18 @@ func GetModifyRequest(data []byte) (*ModifyRequest, error) {
 	}
 	return &ModifyRequest{Object: object, Changes: changes}, nil
 }
+
+// Return the BER-encoded struct (without element header)
+func (r *ModifyRequest) Encode() []byte {
+	b := bytes.NewBuffer(nil)
+	b.Write(BerEncodeOctetString(r.Object))
+	cb := bytes.NewBuffer(nil)
+	for _, change := range r.Changes {
+		c := bytes.NewBuffer(nil)
+		c.Write(BerEncodeEnumerated(int64(change.Operation)))
+		c.Write(BerEncodeSequence(change.Modification.Encode()))
+		cb.Write(BerEncodeSequence(c.Bytes()))
+	}
+	b.Write(BerEncodeSequence(cb.Bytes()))
+	return b.Bytes()
+}
@@ -1,5 +1,7 @@
 package ldapserver
 
+import "bytes"
+
 // ModifyDNRequest ::= [APPLICATION 12] SEQUENCE {
 // 	entry        LDAPDN,
 // 	newrdn       RelativeLDAPDN,
@@ -10,6 +12,8 @@ type ModifyDNRequest struct {
 	NewRDN       string
 	DeleteOldRDN bool
 	NewSuperior  string
+	// Whether the request has a new superior, which is "" to move the entry under the root
+	HasNewSuperior bool
 }
 
 // Return a ModifyDNRequest from BER-encoded data
@@ -37,11 +41,24 @@ func GetModifyDNRequest(data []byte) (*ModifyDNRequest, error) {
 		return nil, err
 	}
 	newSuperior := ""
-	if len(seq) == 4 {
+	hasNewSuperior := len(seq) == 4
+	if hasNewSuperior {
 		if seq[3].Type != BerContextSpecificType(0, false) {
 			return nil, ErrWrongElementType.WithInfo("ModifyDNRequest new superior type", seq[3].Type)
 		}
 		newSuperior = BerGetOctetString(seq[3].Data)
 	}
-	return &ModifyDNRequest{entry, newRDN, deleteOldRDN, newSuperior}, nil
+	return &ModifyDNRequest{entry, newRDN, deleteOldRDN, newSuperior, hasNewSuperior}, nil
+}
+
+// Return the BER-encoded struct (without element header)
+func (r *ModifyDNRequest) Encode() []byte {
+	b := bytes.NewBuffer(nil)
+	b.Write(BerEncodeOctetString(r.Object))
+	b.Write(BerEncodeOctetString(r.NewRDN))
+	b.Write(BerEncodeBoolean(r.DeleteOldRDN))
+	if r.HasNewSuperior || r.NewSuperior != "" {
+		b.Write(BerEncodeElement(BerContextSpecificType(0, false), []byte(r.NewSuperior)))
+	}
+	return b.Bytes()
 }
@@ -0,0 +1,477 @@
+package ldapserver
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// A Handler that dispatches operations to other handlers by naming context,
+// similar to http.ServeMux.
+//
+// Operations targeting an entry are passed to the handler of the longest naming context
+// that contains the entry's DN.
+// Search requests whose scope includes other naming contexts below the base object
+// are also passed to the handlers of those naming contexts,
+// and their results are combined.
+// ModifyDN requests that would move an entry to another handler
+// fail with the affectsMultipleDSAs result code.
+type LDAPMux struct {
+	// Mutex protecting the naming contexts
+	lock sync.RWMutex
+	// Registered naming contexts, longest first
+	contexts []muxEntry
+	// Handler for operations outside all naming contexts,
+	// and for Extended and unrecognized requests.
+	// If nil, operations outside all naming contexts fail with the noSuchObject result code
+	// (Bind requests with invalidCredentials, except anonymous Binds which succeed),
+	// and the other requests are handled as by BaseHandler.
+	Default Handler
+}
+
+// A naming context and its handler
+type muxEntry struct {
+	namingContext DN
+	// Naming context with lower-case attribute types and values, for matching
+	normalized DN
+	handler    Handler
+}
+
+// Create a new, empty LDAPMux
+func NewLDAPMux() *LDAPMux {
+	return &LDAPMux{}
+}
+
+// Register the handler for the naming context.
+// Panics if the naming context is not a valid DN or is already registered.
+func (m *LDAPMux) Handle(namingContext string, handler Handler) {
+	dn, err := ParseDN(namingContext)
+	if err != nil {
+		panic("ldapserver: invalid naming context " + namingContext)
+	}
+	if handler == nil {
+		panic("ldapserver: nil handler")
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	normalized := normalizeDN(dn)
+	for _, e := range m.contexts {
+		if e.normalized.Equal(normalized) {
+			panic("ldapserver: multiple registrations for " + namingContext)
+		}
+	}
+	m.contexts = append(m.contexts, muxEntry{namingContext: dn, normalized: normalized, handler: handler})
+	sort.SliceStable(m.contexts, func(i, j int) bool {
+		return len(m.contexts[i].namingContext) > len(m.contexts[j].namingContext)
+	})
+}
+
+// Returns the registered naming contexts, longest first
+func (m *LDAPMux) NamingContexts() []DN {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	contexts := make([]DN, len(m.contexts))
+	for i, e := range m.contexts {
+		contexts[i] = e.namingContext
+	}
+	return contexts
+}
+
+// Returns the handler and the naming context for the DN,
+// or nil if the DN is not within any naming context
+func (m *LDAPMux) Handler(dn DN) (Handler, DN) {
+	e := m.match(dn)
+	if e == nil {
+		return nil, nil
+	}
+	return e.handler, e.namingContext
+}
+
+// Returns the entry of the longest naming context containing the DN, or nil
+func (m *LDAPMux) match(dn DN) *muxEntry {
+	dn = normalizeDN(dn)
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for i, e := range m.contexts {
+		if e.normalized.Equal(dn) || e.normalized.IsSuperior(dn) {
+			return &m.contexts[i]
+		}
+	}
+	return nil
+}
+
+// Returns the Default handler or a BaseHandler
+func (m *LDAPMux) defaultHandler() Handler {
+	if m.Default == nil {
+		return &BaseHandler{}
+	}
+	return m.Default
+}
+
+// Returns the handler for the DN of the request,
+// or sends an error result and returns nil if there is none
+func (m *LDAPMux) route(conn *Conn, msg *Message, dn string) Handler {
+	parsed, err := ParseDN(dn)
+	if err != nil {
+		RejectRequest(conn, msg, ResultInvalidDNSyntax, "the DN is invalid")
+		return nil
+	}
+	if e := m.match(parsed); e != nil {
+		return e.handler
+	}
+	if m.Default != nil {
+		return m.Default
+	}
+	RejectRequest(conn, msg, ResultNoSuchObject, "the DN is not within a naming context of this server")
+	return nil
+}
+
+func (m *LDAPMux) Abandon(ctx context.Context, conn *Conn, msg *Message, messageID MessageID) {
+	// The operation's handler is not known, so notify all of them
+	m.lock.RLock()
+	contexts := m.contexts
+	m.lock.RUnlock()
+	for _, e := range contexts {
+		e.handler.Abandon(ctx, conn, msg, messageID)
+	}
+	m.defaultHandler().Abandon(ctx, conn, msg, messageID)
+}
+
+func (m *LDAPMux) Add(ctx context.Context, conn *Conn, msg *Message, req *AddRequest) {
+	if h := m.route(conn, msg, req.Entry); h != nil {
+		h.Add(ctx, conn, msg, req)
+	}
+}
+
+func (m *LDAPMux) Bind(ctx context.Context, conn *Conn, msg *Message, req *BindRequest) {
+	if dn, err := ParseDN(req.Name); err == nil && m.match(dn) == nil && m.Default == nil {
+		if req.Name == "" && req.AuthType == AuthenticationTypeSimple && req.Credentials == "" {
+			conn.Authentication = nil
+			conn.SendResult(msg.MessageID, nil, TypeBindResponseOp, ResultSuccess.AsResult(""))
+		} else {
+			conn.SendResult(msg.MessageID, nil, TypeBindResponseOp, ResultInvalidCredentials.AsResult(""))
+		}
+		return
+	}
+	if h := m.route(conn, msg, req.Name); h != nil {
+		h.Bind(ctx, conn, msg, req)
+	}
+}
+
+func (m *LDAPMux) Compare(ctx context.Context, conn *Conn, msg *Message, req *CompareRequest) {
+	if h := m.route(conn, msg, req.Object); h != nil {
+		h.Compare(ctx, conn, msg, req)
+	}
+}
+
+func (m *LDAPMux) Delete(ctx context.Context, conn *Conn, msg *Message, dn string) {
+	if h := m.route(conn, msg, dn); h != nil {
+		h.Delete(ctx, conn, msg, dn)
+	}
+}
+
+// Password Modify requests are passed to the handler of the naming context
+// containing the user identity of the request, or the DN the connection is bound as
+// if the user identity is empty and conn.Authentication is a DN or a DN string.
+// Other Extended requests, and Password Modify requests for other users, are passed to the Default handler.
+func (m *LDAPMux) Extended(ctx context.Context, conn *Conn, msg *Message, req *ExtendedRequest) {
+	if req.Name == OIDPasswordModify {
+		if pmr, err := GetPasswordModifyRequest(req.Value); err == nil {
+			if h := m.passwordModifyHandler(conn, pmr); h != nil {
+				h.Extended(ctx, conn, msg, req)
+				return
+			}
+		}
+	}
+	m.defaultHandler().Extended(ctx, conn, msg, req)
+}
+
+func (m *LDAPMux) Modify(ctx context.Context, conn *Conn, msg *Message, req *ModifyRequest) {
+	if h := m.route(conn, msg, req.Object); h != nil {
+		h.Modify(ctx, conn, msg, req)
+	}
+}
+
+func (m *LDAPMux) ModifyDN(ctx context.Context, conn *Conn, msg *Message, req *ModifyDNRequest) {
+	dn, err := ParseDN(req.Object)
+	if err != nil {
+		RejectRequest(conn, msg, ResultInvalidDNSyntax, "the DN is invalid")
+		return
+	}
+	newRDN, err := ParseDN(req.NewRDN)
+	if err != nil || len(newRDN) != 1 {
+		RejectRequest(conn, msg, ResultInvalidDNSyntax, "the new RDN is invalid")
+		return
+	}
+	var newParent DN
+	if req.HasNewSuperior {
+		newParent, err = ParseDN(req.NewSuperior)
+		if err != nil {
+			RejectRequest(conn, msg, ResultInvalidDNSyntax, "the new superior DN is invalid")
+			return
+		}
+	} else if len(dn) > 0 {
+		newParent = dn[:len(dn)-1]
+	}
+	e := m.match(dn)
+	if e != m.match(newParent.WithRDN(newRDN[0])) {
+		RejectRequest(conn, msg, ResultAffectsMultipleDSAs, "the entry cannot be moved to another naming context")
+		return
+	}
+	if e != nil {
+		e.handler.ModifyDN(ctx, conn, msg, req)
+	} else if m.Default != nil {
+		m.Default.ModifyDN(ctx, conn, msg, req)
+	} else {
+		RejectRequest(conn, msg, ResultNoSuchObject, "the DN is not within a naming context of this server")
+	}
+}
+
+func (m *LDAPMux) Other(ctx context.Context, conn *Conn, msg *Message) {
+	m.defaultHandler().Other(ctx, conn, msg)
+}
+
+// Publishes the registered naming contexts, and the other values
+// added by the handlers that implement RootDSEProvider.
+// The naming contexts added by the Default handler are also published.
+func (m *LDAPMux) AddToRootDSE(dse *RootDSE) {
+	m.lock.RLock()
+	contexts := m.contexts
+	m.lock.RUnlock()
+	for _, e := range contexts {
+		dse.addNamingContext(e.namingContext.String())
+	}
+	for _, e := range contexts {
+		if p, ok := e.handler.(RootDSEProvider); ok {
+			contributed := &RootDSE{}
+			p.AddToRootDSE(contributed)
+			// The handler's entries are only reachable within the registered naming context
+			contributed.NamingContexts = nil
+			dse.merge(contributed)
+		}
+	}
+	if p, ok := m.Default.(RootDSEProvider); ok {
+		contributed := &RootDSE{}
+		p.AddToRootDSE(contributed)
+		dse.merge(contributed)
+	}
+}
+
+// Registers the controls supported by the handlers that implement ControlProvider,
+// including the Default handler.
+// Since requests are routed by DN, a control supported by any of the handlers is accepted.
+func (m *LDAPMux) RegisterControls(registry *ControlRegistry) {
+	m.lock.RLock()
+	contexts := m.contexts
+	m.lock.RUnlock()
+	for _, e := range contexts {
+		if p, ok := e.handler.(ControlProvider); ok {
+			p.RegisterControls(registry)
+		}
+	}
+	if p, ok := m.Default.(ControlProvider); ok {
+		p.RegisterControls(registry)
+	}
+}
+
+// Returns the handler for the user of a Password Modify request,
+// or nil if it is not within a naming context and there is no Default handler
+func (m *LDAPMux) passwordModifyHandler(conn *Conn, req *PasswordModifyRequest) Handler {
+	identity := req.UserIdentity
+	if identity == "" {
+		// The user the connection is bound as, if the Bind handler stored its DN
+		switch a := conn.Authentication.(type) {
+		case DN:
+			identity = a.String()
+		case string:
+			identity = a
+		}
+	}
+	if dn, err := ParseDN(identity); err == nil {
+		if e := m.match(dn); e != nil {
+			return e.handler
+		}
+	}
+	return m.Default
+}
+
+// Passes the request to the PasswordModifier of the handler for the user,
+// found as described for Extended.
+func (m *LDAPMux) ModifyPassword(ctx context.Context, conn *Conn, msg *Message, req *PasswordModifyRequest) (string, *Result) {
+	h := m.passwordModifyHandler(conn, req)
+	if h == nil {
+		return "", ResultNoSuchObject.AsResult("the user is not within a naming context of this server")
+	}
+	pm := passwordModifier(h)
+	if pm == nil {
+		return "", ResultUnwillingToPerform.AsResult("the Password Modify operation is not supported for this user")
+	}
+	return pm.ModifyPassword(ctx, conn, msg, req)
+}
+
+// Returns true if the Default handler or any of the handlers of the naming contexts
+// implements PasswordModifier
+func (m *LDAPMux) hasPasswordModifier() bool {
+	m.lock.RLock()
+	contexts := m.contexts
+	m.lock.RUnlock()
+	for _, e := range contexts {
+		if passwordModifier(e.handler) != nil {
+			return true
+		}
+	}
+	return m.Default != nil && passwordModifier(m.Default) != nil
+}
+
+// A handler and the request to pass to it for a Search that spans several naming contexts
+type muxSearchTarget struct {
+	handler Handler
+	req     *SearchRequest
+}
+
+// Returns the handlers to search for the request:
+// the handler containing the base object, if any,
+// followed by those of the naming contexts below the base object within the scope
+func (m *LDAPMux) searchTargets(base DN, req *SearchRequest) []muxSearchTarget {
+	var targets []muxSearchTarget
+	if e := m.match(base); e != nil {
+		targets = append(targets, muxSearchTarget{e.handler, req})
+	} else if m.Default != nil {
+		targets = append(targets, muxSearchTarget{m.Default, req})
+	}
+	if req.Scope == SearchScopeBaseObject {
+		return targets
+	}
+	base = normalizeDN(base)
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	// Search shorter naming contexts first
+	for i := len(m.contexts) - 1; i >= 0; i-- {
+		e := m.contexts[i]
+		if !base.IsSuperior(e.normalized) {
+			continue
+		}
+		sub := *req
+		sub.BaseObject = e.namingContext.String()
+		switch req.Scope {
+		case SearchScopeSingleLevel:
+			if !base.IsParent(e.normalized) {
+				continue
+			}
+			sub.Scope = SearchScopeBaseObject
+		default:
+			sub.Scope = SearchScopeWholeSubtree
+		}
+		targets = append(targets, muxSearchTarget{e.handler, &sub})
+	}
+	return targets
+}
+
+func (m *LDAPMux) Search(ctx context.Context, conn *Conn, msg *Message, req *SearchRequest) {
+	base, err := ParseDN(req.BaseObject)
+	if err != nil {
+		RejectRequest(conn, msg, ResultInvalidDNSyntax, "the base DN is invalid")
+		return
+	}
+	targets := m.searchTargets(base, req)
+	switch {
+	case len(targets) == 0:
+		RejectRequest(conn, msg, ResultNoSuchObject, "the DN is not within a naming context of this server")
+		return
+	case len(targets) == 1 && targets[0].req == req:
+		targets[0].handler.Search(ctx, conn, msg, req)
+		return
+	}
+	search := &muxSearch{sizeLimit: int(req.SizeLimit)}
+	pop := conn.pushResponseFilter(msg.MessageID, search.filter)
+	for _, target := range targets {
+		subCtx, cancel := context.WithCancel(ctx)
+		search.setCancel(cancel)
+		target.handler.Search(subCtx, conn, msg, target.req)
+		cancel()
+		if ctx.Err() != nil || search.sizeLimitReached() {
+			break
+		}
+	}
+	pop()
+	if ctx.Err() == context.Canceled {
+		// Abandoned
+		return
+	}
+	controls, res := search.result(ctx)
+	conn.SendResult(msg.MessageID, controls, TypeSearchResultDoneOp, res)
+}
+
+// State of a Search that spans several naming contexts
+type muxSearch struct {
+	lock      sync.Mutex
+	sizeLimit int
+	entries   int
+	// Whether an entry was dropped because of the size limit
+	exceeded bool
+	// Cancels the current handler's search
+	cancel context.CancelFunc
+	// SearchResultDone responses of the handlers
+	results []muxSearchResult
+}
+
+// A SearchResultDone response of a handler
+type muxSearchResult struct {
+	controls []Control
+	res      Encodable
+}
+
+func (s *muxSearch) setCancel(cancel context.CancelFunc) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.cancel = cancel
+}
+
+func (s *muxSearch) sizeLimitReached() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.exceeded
+}
+
+// Passes entries and references on to the client until the size limit is reached,
+// and keeps the SearchResultDone responses
+func (s *muxSearch) filter(controls []Control, rtype BerType, res Encodable) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch rtype {
+	case TypeSearchResultEntryOp:
+		if s.sizeLimit > 0 && s.entries >= s.sizeLimit {
+			s.exceeded = true
+			s.cancel()
+			return false
+		}
+		s.entries++
+	case TypeSearchResultDoneOp:
+		s.results = append(s.results, muxSearchResult{controls, res})
+		return false
+	}
+	return true
+}
+
+// Returns the combined SearchResultDone response:
+// the first unsuccessful result of a handler, or else the last successful one
+func (s *muxSearch) result(ctx context.Context) ([]Control, Encodable) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.exceeded {
+		return nil, ResultSizeLimitExceeded.AsResult("")
+	}
+	for _, r := range s.results {
+		if code, ok := resultCodeOf(r.res); ok && code != ResultSuccess {
+			return r.controls, r.res
+		}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, ResultTimeLimitExceeded.AsResult("")
+	}
+	if len(s.results) > 0 {
+		r := s.results[len(s.results)-1]
+		return r.controls, r.res
+	}
+	return nil, ResultSuccess.AsResult("")
+}
@@ -0,0 +1,123 @@
+package ldapserver_test
+
+import (
+	"testing"
+
+	"github.com/merlinz01/ldapserver"
+)
+
+func newTestMux(t *testing.T) *ldapserver.LDAPMux {
+	legacy := ldapserver.NewMemoryHandler()
+	for _, e := range []*ldapserver.Entry{
+		ldapserver.NewEntry(ldapserver.MustParseDN("ou=legacy,dc=example,dc=com"),
+			ldapserver.Attribute{Description: "objectClass", Values: []string{"organizationalUnit"}},
+			ldapserver.Attribute{Description: "ou", Values: []string{"legacy"}}),
+		ldapserver.NewEntry(ldapserver.MustParseDN("uid=old,ou=legacy,dc=example,dc=com"),
+			ldapserver.Attribute{Description: "objectClass", Values: []string{"person"}},
+			ldapserver.Attribute{Description: "uid", Values: []string{"old"}},
+			ldapserver.Attribute{Description: "sn", Values: []string{"Old"}}),
+	} {
+		if err := legacy.AddEntry(e); err != nil {
+			t.Fatal("Error adding entry:", err)
+		}
+	}
+	mux := ldapserver.NewLDAPMux()
+	mux.Handle("dc=example,dc=com", newTestDirectory(t))
+	mux.Handle("ou=legacy,dc=example,dc=com", legacy)
+	return mux
+}
+
+func TestMuxRouting(t *testing.T) {
+	mux := newTestMux(t)
+	if h, nc := mux.Handler(ldapserver.MustParseDN("uid=x,OU=legacy,DC=example,DC=com")); h == nil || nc.String() != "ou=legacy,dc=example,dc=com" {
+		t.Fatal("wrong naming context", nc)
+	}
+	if h, _ := mux.Handler(ldapserver.MustParseDN("dc=example,dc=org")); h != nil {
+		t.Fatal("DN outside the naming contexts matched")
+	}
+	conn := startTestServer(t, mux)
+
+	res := doResult(t, conn, 1, ldapserver.TypeCompareRequestOp, (&ldapserver.CompareRequest{
+		Object: "uid=old,ou=legacy,dc=example,dc=com", Attribute: "sn", Value: "old"}).Encode())
+	if res.ResultCode != ldapserver.ResultCompareTrue {
+		t.Fatal("wrong Compare result code", res.ResultCode)
+	}
+	res = doResult(t, conn, 2, ldapserver.TypeCompareRequestOp, (&ldapserver.CompareRequest{
+		Object: "uid=jdoe,ou=users,dc=example,dc=com", Attribute: "sn", Value: "doe"}).Encode())
+	if res.ResultCode != ldapserver.ResultCompareTrue {
+		t.Fatal("wrong Compare result code", res.ResultCode)
+	}
+	res = doResult(t, conn, 3, ldapserver.TypeDeleteRequestOp, []byte("dc=example,dc=org"))
+	if res.ResultCode != ldapserver.ResultNoSuchObject {
+		t.Fatal("wrong Delete result code", res.ResultCode)
+	}
+	res = doResult(t, conn, 4, ldapserver.TypeBindRequestOp, (&ldapserver.BindRequest{
+		Version: 3, AuthType: ldapserver.AuthenticationTypeSimple, Credentials: ""}).Encode())
+	if res.ResultCode != ldapserver.ResultSuccess {
+		t.Fatal("wrong anonymous Bind result code", res.ResultCode)
+	}
+
+	res = doResult(t, conn, 5, ldapserver.TypeModifyDNRequestOp, (&ldapserver.ModifyDNRequest{
+		Object: "uid=old,ou=legacy,dc=example,dc=com", NewRDN: "uid=old", DeleteOldRDN: true,
+		NewSuperior: "ou=users,dc=example,dc=com"}).Encode())
+	if res.ResultCode != ldapserver.ResultAffectsMultipleDSAs {
+		t.Fatal("wrong ModifyDN result code", res.ResultCode)
+	}
+	res = doResult(t, conn, 6, ldapserver.TypeModifyDNRequestOp, (&ldapserver.ModifyDNRequest{
+		Object: "uid=old,ou=legacy,dc=example,dc=com", NewRDN: "uid=older", DeleteOldRDN: true}).Encode())
+	if res.ResultCode != ldapserver.ResultSuccess {
+		t.Fatal("wrong ModifyDN result code", res.ResultCode)
+	}
+}
+
+func TestMuxSearch(t *testing.T) {
+	conn := startTestServer(t, newTestMux(t))
+	search := func(id ldapserver.MessageID, base string, scope ldapserver.SearchScope, sizeLimit uint32) ([]string, ldapserver.LDAPResultCode) {
+		t.Helper()
+		responses := doRequest(t, conn, id, ldapserver.TypeSearchRequestOp, (&ldapserver.SearchRequest{
+			BaseObject: base, Scope: scope, SizeLimit: sizeLimit,
+			Filter: ldapserver.MustParseFilter("(objectClass=*)"),
+		}).Encode())
+		var dns []string
+		for _, res := range responses[:len(responses)-1] {
+			entry, err := ldapserver.GetSearchResultEntry(res.ProtocolOp.Data)
+			if err != nil {
+				t.Fatal("Error parsing entry:", err)
+			}
+			dns = append(dns, entry.ObjectName)
+		}
+		res, err := ldapserver.GetResult(responses[len(responses)-1].ProtocolOp.Data)
+		if err != nil {
+			t.Fatal("Error parsing result:", err)
+		}
+		return dns, res.ResultCode
+	}
+
+	dns, code := search(1, "dc=example,dc=com", ldapserver.SearchScopeWholeSubtree, 0)
+	if code != ldapserver.ResultSuccess || len(dns) != 5 {
+		t.Fatal("wrong subtree search results", code, dns)
+	}
+	if dns[3] != "ou=legacy,dc=example,dc=com" || dns[4] != "uid=old,ou=legacy,dc=example,dc=com" {
+		t.Fatal("wrong entries from the second naming context", dns)
+	}
+	dns, code = search(2, "dc=example,dc=com", ldapserver.SearchScopeSingleLevel, 0)
+	if code != ldapserver.ResultSuccess || !slicesEqual(dns, []string{"ou=users,dc=example,dc=com", "ou=legacy,dc=example,dc=com"}) {
+		t.Fatal("wrong one-level search results", code, dns)
+	}
+	dns, code = search(3, "dc=example,dc=com", ldapserver.SearchScopeWholeSubtree, 4)
+	if code != ldapserver.ResultSizeLimitExceeded || len(dns) != 4 {
+		t.Fatal("wrong size-limited search results", code, dns)
+	}
+	dns, code = search(4, "ou=legacy,dc=example,dc=com", ldapserver.SearchScopeWholeSubtree, 0)
+	if code != ldapserver.ResultSuccess || len(dns) != 2 {
+		t.Fatal("wrong search results", code, dns)
+	}
+	dns, code = search(5, "DC=com", ldapserver.SearchScopeWholeSubtree, 0)
+	if code != ldapserver.ResultSuccess || len(dns) != 5 {
+		t.Fatal("wrong search results above the naming contexts", code, dns)
+	}
+	_, code = search(6, "dc=org", ldapserver.SearchScopeWholeSubtree, 0)
+	if code != ldapserver.ResultNoSuchObject {
+		t.Fatal("wrong result code outside the naming contexts", code)
+	}
+}
@@ -33,7 +33,10 @@ const (
 	OIDNoticeOfDisconnection   OID = "1.3.6.1.4.1.1466.20036"
 	OIDObjectClass             OID = "2.5.4.0"
 	OIDObjectClasses           OID = "2.5.21.6"
+	OIDPagedResults            OID = "1.2.840.113556.1.4.319"
 	OIDPasswordModify          OID = "1.3.6.1.4.1.4203.1.11.1"
+	OIDServerSideSortRequest   OID = "1.2.840.113556.1.4.473"
+	OIDServerSideSortResponse  OID = "1.2.840.113556.1.4.474"
 	OIDStartTLS                OID = "1.3.6.1.4.1.1466.20037"
 	OIDStructuralObjectClass   OID = "2.5.21.9"
 	OIDSubschema               OID = "2.5.20.1"
@@ -44,6 +47,8 @@ const (
 	OIDSupportedLDAPVersion    OID = "1.3.6.1.4.1.1466.101.120.15"
 	OIDSupportedSASLMechanisms OID = "1.3.6.1.4.1.1466.101.120.14"
 	OIDTop                     OID = "2.5.6.0"
+	OIDVLVRequest              OID = "2.16.840.1.113730.3.4.9"
+	OIDVLVResponse             OID = "2.16.840.1.113730.3.4.10"
 )
 
 var validOID = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*$`)
@@ -0,0 +1,297 @@
+package ldapserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+)
+
+// The Simple Paged Results control (RFC 2696)
+//
+//	realSearchControlValue ::= SEQUENCE {
+//		size            INTEGER (0..maxInt),
+//		                     -- requested page size from client
+//		                     -- result set size estimate from server
+//		cookie          OCTET STRING }
+type PagedResultsControl struct {
+	// Page size requested by the client,
+	// or the server's estimate of the total number of entries in a response
+	Size uint32
+	// Opaque cookie identifying the paged search,
+	// empty in the first request and in the response to the last page
+	Cookie string
+}
+
+func (c *PagedResultsControl) ControlType() OID {
+	return OIDPagedResults
+}
+
+func (c *PagedResultsControl) EncodeValue() string {
+	data := BerEncodeInteger(int64(c.Size))
+	data = append(data, BerEncodeOctetString(c.Cookie)...)
+	return string(BerEncodeSequence(data))
+}
+
+// Decode the value of a Simple Paged Results control
+func DecodePagedResultsControl(value string) (TypedControl, error) {
+	seq, err := controlValueSequence(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(seq) != 2 {
+		return nil, ErrWrongSequenceLength.WithInfo("PagedResultsControl sequence length", len(seq))
+	}
+	if seq[0].Type != BerTypeInteger {
+		return nil, ErrWrongElementType.WithInfo("PagedResultsControl size type", seq[0].Type)
+	}
+	size, err := BerGetInteger(seq[0].Data)
+	if err != nil {
+		return nil, err
+	}
+	if size < 0 || size > 2147483647 {
+		return nil, ErrIntegerTooLarge.WithInfo("PagedResultsControl size", size)
+	}
+	if seq[1].Type != BerTypeOctetString {
+		return nil, ErrWrongElementType.WithInfo("PagedResultsControl cookie type", seq[1].Type)
+	}
+	return &PagedResultsControl{Size: uint32(size), Cookie: BerGetOctetString(seq[1].Data)}, nil
+}
+
+// Returns the elements of a control value that is a BER-encoded SEQUENCE
+func controlValueSequence(value string) ([]BerRawElement, error) {
+	elem, err := BerReadElement(strings.NewReader(value))
+	if err != nil {
+		return nil, err
+	}
+	if elem.Type != BerTypeSequence {
+		return nil, ErrWrongElementType.WithInfo("control value type", elem.Type)
+	}
+	return BerGetSequence(elem.Data)
+}
+
+// Maximum number of unfinished paged searches kept for a connection.
+// When it is exceeded, the oldest is discarded.
+const maxPagedSearches = 8
+
+// Maximum number of entries and references kept for a paged search.
+// When it is exceeded, the wrapped handler's Search is cancelled
+// and the paged search fails with the adminLimitExceeded result code.
+const maxPagedResponses = 10000
+
+// Middleware that implements the Simple Paged Results control (RFC 2696) for Search requests.
+//
+// The first request of a paged search is passed on to the wrapped handler,
+// and the entries and references it returns are kept on the connection
+// until the client has retrieved all the pages, abandons the paged search with a size of 0,
+// or starts too many other paged searches.
+// At most 8 paged searches of 10000 entries and references are kept for each connection;
+// searches with more results fail with the adminLimitExceeded result code.
+// The control is registered as supported, so the wrapped handler need not know about it.
+func PagedResults(next Handler) Handler {
+	return &pagedResultsHandler{Handler: next}
+}
+
+// Handler that pages the results of the wrapped handler's Search operations
+type pagedResultsHandler struct {
+	Handler
+}
+
+// A response held back by a middleware to be sent later
+type heldResponse struct {
+	controls []Control
+	rtype    BerType
+	res      Encodable
+}
+
+// The remaining responses of a paged search
+type pagedSearch struct {
+	// Encoded Search request, which the requests for the next pages must repeat
+	request []byte
+	// Entries and references not yet sent
+	responses []heldResponse
+	// Number of entries in the whole result
+	entries int
+	// SearchResultDone response of the handler
+	done *heldResponse
+	// Whether the handler returned more than maxPagedResponses entries and references
+	exceeded bool
+	// Cancels the handler's Search when exceeded
+	cancel context.CancelFunc
+}
+
+// Keeps the responses of the wrapped handler instead of sending them
+func (s *pagedSearch) collect(controls []Control, rtype BerType, res Encodable) bool {
+	if rtype == TypeSearchResultDoneOp {
+		s.done = &heldResponse{controls, rtype, res}
+		return false
+	}
+	if len(s.responses) >= maxPagedResponses {
+		if !s.exceeded {
+			s.exceeded = true
+			s.cancel()
+		}
+		return false
+	}
+	if rtype == TypeSearchResultEntryOp {
+		s.entries++
+	}
+	s.responses = append(s.responses, heldResponse{controls, rtype, res})
+	return false
+}
+
+func (h *pagedResultsHandler) Search(ctx context.Context, conn *Conn, msg *Message, req *SearchRequest) {
+	paged, ok := msg.DecodedControl(OIDPagedResults).(*PagedResultsControl)
+	if !ok {
+		h.Handler.Search(ctx, conn, msg, req)
+		return
+	}
+	request := req.Encode()
+	var search *pagedSearch
+	if paged.Cookie == "" {
+		if paged.Size == 0 {
+			sendPagedDone(conn, msg, &PagedResultsControl{}, nil)
+			return
+		}
+		searchCtx, cancel := context.WithCancel(ctx)
+		search = &pagedSearch{request: request, cancel: cancel}
+		pop := conn.pushResponseFilter(msg.MessageID, search.collect)
+		h.Handler.Search(searchCtx, conn, msg, req)
+		pop()
+		cancel()
+	} else {
+		search = conn.takePagedSearch(paged.Cookie)
+		if search == nil || !bytes.Equal(search.request, request) {
+			RejectRequest(conn, msg, ResultUnwillingToPerform, "invalid paged results cookie")
+			return
+		}
+		if paged.Size == 0 {
+			// Abandoned by the client
+			sendPagedDone(conn, msg, &PagedResultsControl{}, nil)
+			return
+		}
+	}
+	if ctx.Err() == context.Canceled {
+		// Abandoned or disconnected
+		return
+	}
+	if search.exceeded {
+		sendPagedDone(conn, msg, &PagedResultsControl{}, &heldResponse{rtype: TypeSearchResultDoneOp,
+			res: ResultAdminLimitExceeded.AsResult("too many results for a paged search")})
+		return
+	}
+	// Send the next page, counting only entries towards the page size
+	sent := uint32(0)
+	for len(search.responses) > 0 && sent < paged.Size {
+		r := search.responses[0]
+		search.responses = search.responses[1:]
+		if err := conn.SendResult(msg.MessageID, r.controls, r.rtype, r.res); err != nil {
+			return
+		}
+		if r.rtype == TypeSearchResultEntryOp {
+			sent++
+		}
+	}
+	// Send the references following the last entry of the page
+	for len(search.responses) > 0 && search.responses[0].rtype != TypeSearchResultEntryOp {
+		r := search.responses[0]
+		search.responses = search.responses[1:]
+		if err := conn.SendResult(msg.MessageID, r.controls, r.rtype, r.res); err != nil {
+			return
+		}
+	}
+	if ctx.Err() == context.Canceled {
+		return
+	}
+	response := &PagedResultsControl{Size: uint32(search.entries)}
+	if len(search.responses) > 0 {
+		response.Cookie = conn.putPagedSearch(search)
+		sendPagedDone(conn, msg, response, nil)
+		return
+	}
+	sendPagedDone(conn, msg, response, search.done)
+}
+
+// Send the SearchResultDone response of a page with the paged results response control.
+// If done is nil, the result is success.
+func sendPagedDone(conn *Conn, msg *Message, control *PagedResultsControl, done *heldResponse) {
+	controls := []Control{NewControl(control, false)}
+	var res Encodable = ResultSuccess.AsResult("")
+	if done != nil {
+		for _, c := range done.controls {
+			if c.OID != OIDPagedResults {
+				controls = append(controls, c)
+			}
+		}
+		res = done.res
+	}
+	conn.SendResult(msg.MessageID, controls, TypeSearchResultDoneOp, res)
+}
+
+func (h *pagedResultsHandler) RegisterControls(registry *ControlRegistry) {
+	registry.Register(OIDPagedResults, DecodePagedResultsControl, TypeSearchRequestOp)
+	if p, ok := h.Handler.(ControlProvider); ok {
+		p.RegisterControls(registry)
+	}
+}
+
+func (h *pagedResultsHandler) AddToRootDSE(dse *RootDSE) {
+	if p, ok := h.Handler.(RootDSEProvider); ok {
+		p.AddToRootDSE(dse)
+	}
+}
+
+// Unfinished paged searches of a connection
+type pagedSearches struct {
+	lock sync.Mutex
+	// Searches keyed by cookie
+	searches map[string]*pagedSearch
+	// Cookies from the oldest to the newest
+	cookies []string
+}
+
+// Keep the paged search on the connection and return its new cookie
+func (c *Conn) putPagedSearch(search *pagedSearch) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	cookie := hex.EncodeToString(b)
+	p := &c.pagedSearches
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.searches == nil {
+		p.searches = make(map[string]*pagedSearch)
+	}
+	if len(p.cookies) >= maxPagedSearches {
+		delete(p.searches, p.cookies[0])
+		p.cookies = p.cookies[1:]
+	}
+	p.searches[cookie] = search
+	p.cookies = append(p.cookies, cookie)
+	return cookie
+}
+
+// Remove the paged search with the cookie from the connection and return it,
+// or nil if there is none
+func (c *Conn) takePagedSearch(cookie string) *pagedSearch {
+	p := &c.pagedSearches
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	search := p.searches[cookie]
+	if search == nil {
+		return nil
+	}
+	delete(p.searches, cookie)
+	for i, c := range p.cookies {
+		if c == cookie {
+			p.cookies = append(p.cookies[:i], p.cookies[i+1:]...)
+			break
+		}
+	}
+	return search
+}
+
+func (h *pagedResultsHandler) Unwrap() Handler {
+	return h.Handler
+}
@@ -0,0 +1,149 @@
+package ldapserver_test
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/merlinz01/ldapserver"
+)
+
+// Send a paged Search request and return the entry DNs and the response control
+func doPagedSearch(t *testing.T, conn net.Conn, id ldapserver.MessageID, search []byte,
+	paged *ldapserver.PagedResultsControl) (ldapserver.LDAPResultCode, []string, *ldapserver.PagedResultsControl) {
+	t.Helper()
+	msg := ldapserver.Message{MessageID: id, Controls: []ldapserver.Control{ldapserver.NewControl(paged, true)}}
+	msg.ProtocolOp.Type = ldapserver.TypeSearchRequestOp
+	msg.ProtocolOp.Data = search
+	if _, err := conn.Write(msg.EncodeWithHeader()); err != nil {
+		t.Fatal("Error sending request:", err)
+	}
+	var dns []string
+	for {
+		res, err := ldapserver.ReadLDAPMessage(conn)
+		if err != nil {
+			t.Fatal("Error reading response:", err)
+		}
+		if res.ProtocolOp.Type == ldapserver.TypeSearchResultEntryOp {
+			entry, err := ldapserver.GetSearchResultEntry(res.ProtocolOp.Data)
+			if err != nil {
+				t.Fatal("Error parsing entry:", err)
+			}
+			dns = append(dns, entry.ObjectName)
+			continue
+		}
+		result, err := ldapserver.GetResult(res.ProtocolOp.Data)
+		if err != nil {
+			t.Fatal("Error parsing result:", err)
+		}
+		c := res.GetControl(ldapserver.OIDPagedResults)
+		if c == nil {
+			return result.ResultCode, dns, nil
+		}
+		response, err := ldapserver.DecodePagedResultsControl(c.ControlValue)
+		if err != nil {
+			t.Fatal("Error decoding control:", err)
+		}
+		return result.ResultCode, dns, response.(*ldapserver.PagedResultsControl)
+	}
+}
+
+func TestPagedResultsControl(t *testing.T) {
+	c := &ldapserver.PagedResultsControl{Size: 100, Cookie: "abc"}
+	decoded, err := ldapserver.DecodePagedResultsControl(c.EncodeValue())
+	if err != nil {
+		t.Fatal("Error decoding control:", err)
+	}
+	if *decoded.(*ldapserver.PagedResultsControl) != *c {
+		t.Fatal("wrong decoded control", decoded)
+	}
+	for _, value := range []string{"", "\x30\x00", "\x30\x03\x02\x01\x01", "\x30\x05\x02\x01\xff\x04\x00", "\x04\x00"} {
+		if _, err := ldapserver.DecodePagedResultsControl(value); err == nil {
+			t.Fatalf("invalid control value %q accepted", value)
+		}
+	}
+}
+
+func TestPagedResults(t *testing.T) {
+	conn := startTestServer(t, ldapserver.PagedResults(newTestDirectory(t)))
+	search := (&ldapserver.SearchRequest{BaseObject: "dc=example,dc=com", Scope: ldapserver.SearchScopeWholeSubtree}).Encode()
+
+	code, dns, paged := doPagedSearch(t, conn, 1, search, &ldapserver.PagedResultsControl{Size: 2})
+	if code != ldapserver.ResultSuccess || len(dns) != 2 || paged == nil || paged.Cookie == "" || paged.Size != 3 {
+		t.Fatal("wrong first page", code, dns, paged)
+	}
+	all := dns
+	// The cookie is only valid for the same request
+	other := (&ldapserver.SearchRequest{BaseObject: "ou=users,dc=example,dc=com", Scope: ldapserver.SearchScopeWholeSubtree}).Encode()
+	code, _, _ = doPagedSearch(t, conn, 2, other, &ldapserver.PagedResultsControl{Size: 2, Cookie: "invalid"})
+	if code != ldapserver.ResultUnwillingToPerform {
+		t.Fatal("invalid cookie not rejected", code)
+	}
+	code, dns, paged = doPagedSearch(t, conn, 3, search, &ldapserver.PagedResultsControl{Size: 2, Cookie: paged.Cookie})
+	if code != ldapserver.ResultSuccess || len(dns) != 1 || paged == nil || paged.Cookie != "" {
+		t.Fatal("wrong last page", code, dns, paged)
+	}
+	all = append(all, dns...)
+	if !slicesEqual(all, []string{"dc=example,dc=com", "ou=users,dc=example,dc=com", "uid=jdoe,ou=users,dc=example,dc=com"}) {
+		t.Fatal("wrong entries", all)
+	}
+
+	// A size of 0 abandons the paged search
+	_, _, paged = doPagedSearch(t, conn, 4, search, &ldapserver.PagedResultsControl{Size: 1})
+	code, dns, _ = doPagedSearch(t, conn, 5, search, &ldapserver.PagedResultsControl{Cookie: paged.Cookie})
+	if code != ldapserver.ResultSuccess || len(dns) != 0 {
+		t.Fatal("wrong response to abandoning", code, dns)
+	}
+	code, _, _ = doPagedSearch(t, conn, 6, search, &ldapserver.PagedResultsControl{Size: 1, Cookie: paged.Cookie})
+	if code != ldapserver.ResultUnwillingToPerform {
+		t.Fatal("abandoned cookie not rejected", code)
+	}
+
+	// Errors of the handler are returned with the last page
+	missing := (&ldapserver.SearchRequest{BaseObject: "dc=missing", Scope: ldapserver.SearchScopeWholeSubtree}).Encode()
+	code, _, paged = doPagedSearch(t, conn, 7, missing, &ldapserver.PagedResultsControl{Size: 1})
+	if code != ldapserver.ResultNoSuchObject || paged == nil || paged.Cookie != "" {
+		t.Fatal("wrong error response", code, paged)
+	}
+
+	responses := doRequest(t, conn, 8, ldapserver.TypeSearchRequestOp, (&ldapserver.SearchRequest{
+		Scope: ldapserver.SearchScopeBaseObject, Attributes: []string{"supportedControl"},
+	}).Encode())
+	entry, err := ldapserver.GetSearchResultEntry(responses[0].ProtocolOp.Data)
+	if err != nil {
+		t.Fatal("Error parsing entry:", err)
+	}
+	if len(entry.Attributes) != 1 || !slicesEqual(entry.Attributes[0].Values, []string{string(ldapserver.OIDPagedResults)}) {
+		t.Fatal("wrong supported controls", entry.Attributes)
+	}
+}
+
+// Handler returning entries until its context is done, recording how many it returned
+type endlessHandler struct {
+	ldapserver.BaseHandler
+	returned chan int
+}
+
+func (h *endlessHandler) Search(ctx context.Context, conn *ldapserver.Conn, msg *ldapserver.Message, req *ldapserver.SearchRequest) {
+	n := 0
+	for ; ctx.Err() == nil && n < 100000; n++ {
+		conn.SendResult(msg.MessageID, nil, ldapserver.TypeSearchResultEntryOp,
+			&ldapserver.SearchResultEntry{ObjectName: "cn=" + strconv.Itoa(n) + ",dc=example,dc=com"})
+	}
+	h.returned <- n
+	conn.SendResult(msg.MessageID, nil, ldapserver.TypeSearchResultDoneOp, ldapserver.ResultSuccess.AsResult(""))
+}
+
+func TestPagedResultsLimit(t *testing.T) {
+	h := &endlessHandler{returned: make(chan int, 1)}
+	conn := startTestServer(t, ldapserver.PagedResults(h))
+	search := (&ldapserver.SearchRequest{BaseObject: "dc=example,dc=com", Scope: ldapserver.SearchScopeWholeSubtree}).Encode()
+	code, dns, paged := doPagedSearch(t, conn, 1, search, &ldapserver.PagedResultsControl{Size: 10})
+	if code != ldapserver.ResultAdminLimitExceeded || len(dns) != 0 || paged == nil || paged.Cookie != "" {
+		t.Fatal("too many results not rejected", code, len(dns), paged)
+	}
+	if n := <-h.returned; n >= 100000 {
+		t.Fatal("handler not cancelled", n)
+	}
+}
@@ -0,0 +1,176 @@
+package ldapserver
+
+import (
+	"context"
+)
+
+// Value of a Password Modify extended request (RFC 3062)
+//
+//	PasswdModifyRequestValue ::= SEQUENCE {
+//		userIdentity    [0]  OCTET STRING OPTIONAL
+//		oldPasswd       [1]  OCTET STRING OPTIONAL
+//		newPasswd       [2]  OCTET STRING OPTIONAL }
+type PasswordModifyRequest struct {
+	// User whose password is changed, usually a DN,
+	// or "" for the user the connection is bound as
+	UserIdentity string
+	// Current password of the user, or "" if not provided
+	OldPasswd string
+	// New password, or "" for the server to generate one
+	NewPasswd string
+}
+
+// Value of a Password Modify extended response (RFC 3062)
+//
+//	PasswdModifyResponseValue ::= SEQUENCE {
+//		genPasswd       [0]     OCTET STRING OPTIONAL }
+type PasswordModifyResponse struct {
+	// Password generated by the server, or "" if the request had a new password
+	GenPasswd string
+}
+
+// Return a PasswordModifyRequest from the value of an Extended request.
+// An empty value is a request with none of the fields.
+func GetPasswordModifyRequest(value string) (*PasswordModifyRequest, error) {
+	req := &PasswordModifyRequest{}
+	if value == "" {
+		return req, nil
+	}
+	seq, err := controlValueSequence(value)
+	if err != nil {
+		return nil, err
+	}
+	fields := []*string{&req.UserIdentity, &req.OldPasswd, &req.NewPasswd}
+	next := 0
+	for _, elem := range seq {
+		tag := -1
+		for i := next; i < len(fields); i++ {
+			if elem.Type == BerContextSpecificType(uint8(i), false) {
+				tag = i
+				break
+			}
+		}
+		if tag < 0 {
+			return nil, ErrWrongElementType.WithInfo("PasswdModifyRequestValue element type", elem.Type)
+		}
+		*fields[tag] = BerGetOctetString(elem.Data)
+		next = tag + 1
+	}
+	return req, nil
+}
+
+// Return the BER-encoded request value
+func (r *PasswordModifyRequest) Encode() []byte {
+	var data []byte
+	for i, field := range []string{r.UserIdentity, r.OldPasswd, r.NewPasswd} {
+		if field != "" {
+			data = append(data, BerEncodeElement(BerContextSpecificType(uint8(i), false), []byte(field))...)
+		}
+	}
+	return BerEncodeSequence(data)
+}
+
+// Return a PasswordModifyResponse from the value of an Extended response.
+// An empty value is a response without a generated password.
+func GetPasswordModifyResponse(value string) (*PasswordModifyResponse, error) {
+	res := &PasswordModifyResponse{}
+	if value == "" {
+		return res, nil
+	}
+	seq, err := controlValueSequence(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(seq) > 1 {
+		return nil, ErrWrongSequenceLength.WithInfo("PasswdModifyResponseValue sequence length", len(seq))
+	}
+	if len(seq) == 1 {
+		if seq[0].Type != BerContextSpecificType(0, false) {
+			return nil, ErrWrongElementType.WithInfo("PasswdModifyResponseValue genPasswd type", seq[0].Type)
+		}
+		res.GenPasswd = BerGetOctetString(seq[0].Data)
+	}
+	return res, nil
+}
+
+// Return the BER-encoded response value
+func (r *PasswordModifyResponse) Encode() []byte {
+	var data []byte
+	if r.GenPasswd != "" {
+		data = BerEncodeElement(BerContextSpecificType(0, false), []byte(r.GenPasswd))
+	}
+	return BerEncodeSequence(data)
+}
+
+// Interface for handlers that support the Password Modify extended operation (RFC 3062).
+//
+// BaseHandler.Extended decodes Password Modify requests and passes them to the server's handler
+// if it implements PasswordModifier, looking through the middlewares that implement HandlerWrapper.
+// LDAPMux implements PasswordModifier by passing the requests on to the handler for the user.
+// The extension is then published in the Root DSE as supportedExtension.
+type PasswordModifier interface {
+	// Change the password of the user identified by req.UserIdentity,
+	// or of the user the connection is bound as if it is "".
+	// If req.OldPasswd is set, it must match the current password.
+	// If req.NewPasswd is "", the handler should generate a new password and return it as genPasswd.
+	//
+	// Returns the generated password, if any, and the result to send,
+	// or nil for success.
+	ModifyPassword(ctx context.Context, conn *Conn, msg *Message, req *PasswordModifyRequest) (genPasswd string, res *Result)
+}
+
+// Interface for middlewares that wrap another handler,
+// so that the interfaces implemented by the wrapped handler, such as PasswordModifier, can be found.
+// The middlewares of this package implement it.
+type HandlerWrapper interface {
+	// Returns the wrapped handler that Extended requests are passed to
+	Unwrap() Handler
+}
+
+// Implemented by PasswordModifiers that pass the requests on to other handlers, such as LDAPMux
+type passwordModifierRouter interface {
+	// Returns true if any of the handlers implements PasswordModifier
+	hasPasswordModifier() bool
+}
+
+// Returns the handler that implements PasswordModifier, or nil if there is none
+func passwordModifier(h Handler) PasswordModifier {
+	for h != nil {
+		if m, ok := h.(PasswordModifier); ok {
+			if r, ok := m.(passwordModifierRouter); ok && !r.hasPasswordModifier() {
+				return nil
+			}
+			return m
+		}
+		w, ok := h.(HandlerWrapper)
+		if !ok {
+			return nil
+		}
+		h = w.Unwrap()
+	}
+	return nil
+}
+
+// Perform a Password Modify request with the PasswordModifier
+func modifyPassword(ctx context.Context, conn *Conn, msg *Message, req *ExtendedRequest, m PasswordModifier) {
+	pmr, err := GetPasswordModifyRequest(req.Value)
+	if err != nil {
+		conn.Logger().Info("Invalid Password Modify request", "message_id", msg.MessageID, "error", err)
+		res := &ExtendedResult{Result: *ResultProtocolError.AsResult("the Password Modify request value is invalid")}
+		conn.SendResult(msg.MessageID, nil, TypeExtendedResponseOp, res)
+		return
+	}
+	genPasswd, result := m.ModifyPassword(ctx, conn, msg, pmr)
+	if ctx.Err() != nil {
+		// Abandoned or disconnected
+		return
+	}
+	if result == nil {
+		result = ResultSuccess.AsResult("")
+	}
+	res := &ExtendedResult{Result: *result}
+	if genPasswd != "" {
+		res.ResponseValue = string((&PasswordModifyResponse{GenPasswd: genPasswd}).Encode())
+	}
+	conn.SendResult(msg.MessageID, nil, TypeExtendedResponseOp, res)
+}
@@ -0,0 +1,201 @@
+package ldapserver_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/merlinz01/ldapserver"
+)
+
+// Handler storing the password of a single user
+type passwordHandler struct {
+	ldapserver.BaseHandler
+	password string
+}
+
+func (h *passwordHandler) ModifyPassword(ctx context.Context, conn *ldapserver.Conn, msg *ldapserver.Message,
+	req *ldapserver.PasswordModifyRequest) (string, *ldapserver.Result) {
+	user := req.UserIdentity
+	if user == "" {
+		user, _ = conn.Authentication.(string)
+	}
+	if user != "uid=jdoe,ou=users,dc=example,dc=com" {
+		return "", ldapserver.ResultNoSuchObject.AsResult("unknown user")
+	}
+	if req.OldPasswd != "" && req.OldPasswd != h.password {
+		return "", ldapserver.ResultInvalidCredentials.AsResult("wrong password")
+	}
+	if req.NewPasswd == "" {
+		h.password = "generated"
+		return h.password, nil
+	}
+	h.password = req.NewPasswd
+	return "", nil
+}
+
+// Binds as any user, storing the DN in conn.Authentication
+func (h *passwordHandler) Bind(ctx context.Context, conn *ldapserver.Conn, msg *ldapserver.Message, req *ldapserver.BindRequest) {
+	conn.Authentication = req.Name
+	conn.SendResult(msg.MessageID, nil, ldapserver.TypeBindResponseOp, ldapserver.ResultSuccess.AsResult(""))
+}
+
+// Middleware from outside the package
+type wrappingHandler struct {
+	ldapserver.Handler
+}
+
+func (h wrappingHandler) Unwrap() ldapserver.Handler {
+	return h.Handler
+}
+
+func TestPasswordModifyValues(t *testing.T) {
+	for _, req := range []*ldapserver.PasswordModifyRequest{
+		{},
+		{UserIdentity: "uid=jdoe,dc=example,dc=com", OldPasswd: "old", NewPasswd: "new"},
+		{NewPasswd: "new"},
+	} {
+		decoded, err := ldapserver.GetPasswordModifyRequest(string(req.Encode()))
+		if err != nil {
+			t.Fatal("Error decoding request:", err)
+		}
+		if *decoded != *req {
+			t.Fatal("wrong decoded request", decoded)
+		}
+	}
+	// Out of order and unknown fields
+	for _, value := range []string{"\x30\x04\x81\x00\x80\x00", "\x30\x02\x83\x00", "\x04\x00"} {
+		if _, err := ldapserver.GetPasswordModifyRequest(value); err == nil {
+			t.Fatalf("invalid request value %q accepted", value)
+		}
+	}
+	res := &ldapserver.PasswordModifyResponse{GenPasswd: "secret"}
+	decoded, err := ldapserver.GetPasswordModifyResponse(string(res.Encode()))
+	if err != nil || decoded.GenPasswd != "secret" {
+		t.Fatal("wrong decoded response", decoded, err)
+	}
+}
+
+func TestPasswordModify(t *testing.T) {
+	h := &passwordHandler{password: "old"}
+	conn := startTestServer(t, ldapserver.Chain(h, ldapserver.RequireTLSForBind))
+	modify := func(id ldapserver.MessageID, conn net.Conn, value string) *ldapserver.ExtendedResult {
+		t.Helper()
+		responses := doRequest(t, conn, id, ldapserver.TypeExtendedRequestOp,
+			(&ldapserver.ExtendedRequest{Name: ldapserver.OIDPasswordModify, Value: value}).Encode())
+		res, err := ldapserver.GetExtendedResult(responses[0].ProtocolOp.Data)
+		if err != nil {
+			t.Fatal("Error parsing result:", err)
+		}
+		return res
+	}
+
+	res := modify(1, conn, string((&ldapserver.PasswordModifyRequest{
+		UserIdentity: "uid=jdoe,ou=users,dc=example,dc=com", OldPasswd: "old", NewPasswd: "new"}).Encode()))
+	if res.ResultCode != ldapserver.ResultSuccess || res.ResponseValue != "" || h.password != "new" {
+		t.Fatal("password not changed", res.ResultCode, h.password)
+	}
+	res = modify(2, conn, string((&ldapserver.PasswordModifyRequest{
+		UserIdentity: "uid=jdoe,ou=users,dc=example,dc=com", OldPasswd: "old", NewPasswd: "other"}).Encode()))
+	if res.ResultCode != ldapserver.ResultInvalidCredentials || h.password != "new" {
+		t.Fatal("wrong old password not rejected", res.ResultCode)
+	}
+	res = modify(3, conn, string((&ldapserver.PasswordModifyRequest{UserIdentity: "uid=jdoe,ou=users,dc=example,dc=com"}).Encode()))
+	if res.ResultCode != ldapserver.ResultSuccess {
+		t.Fatal("password not generated", res.ResultCode)
+	}
+	if gen, err := ldapserver.GetPasswordModifyResponse(res.ResponseValue); err != nil || gen.GenPasswd != "generated" {
+		t.Fatal("wrong generated password", gen, err)
+	}
+	res = modify(4, conn, "invalid")
+	if res.ResultCode != ldapserver.ResultProtocolError {
+		t.Fatal("invalid request value not rejected", res.ResultCode)
+	}
+
+	responses := doRequest(t, conn, 5, ldapserver.TypeSearchRequestOp, (&ldapserver.SearchRequest{
+		Scope: ldapserver.SearchScopeBaseObject, Attributes: []string{"supportedExtension"},
+	}).Encode())
+	entry, err := ldapserver.GetSearchResultEntry(responses[0].ProtocolOp.Data)
+	if err != nil {
+		t.Fatal("Error parsing entry:", err)
+	}
+	if len(entry.Attributes) != 1 || !slicesEqual(entry.Attributes[0].Values, []string{string(ldapserver.OIDPasswordModify)}) {
+		t.Fatal("wrong supported extensions", entry.Attributes)
+	}
+
+	// Handlers that do not implement PasswordModifier do not support the operation
+	conn = startTestServer(t, &ldapserver.BaseHandler{})
+	if res := modify(1, conn, ""); res.ResultCode != ldapserver.ResultProtocolError {
+		t.Fatal("unsupported Password Modify not rejected", res.ResultCode)
+	}
+}
+
+func TestPasswordModifyMux(t *testing.T) {
+	users := &passwordHandler{password: "old"}
+	mux := ldapserver.NewLDAPMux()
+	mux.Handle("ou=users,dc=example,dc=com", wrappingHandler{users})
+	mux.Handle("ou=groups,dc=example,dc=com", ldapserver.ReadOnly(&passwordHandler{}))
+	mux.Handle("ou=devices,dc=example,dc=com", &ldapserver.BaseHandler{})
+	conn := startTestServer(t, wrappingHandler{mux})
+	modify := func(id ldapserver.MessageID, req *ldapserver.PasswordModifyRequest) ldapserver.LDAPResultCode {
+		t.Helper()
+		return doResult(t, conn, id, ldapserver.TypeExtendedRequestOp,
+			(&ldapserver.ExtendedRequest{Name: ldapserver.OIDPasswordModify, Value: string(req.Encode())}).Encode()).ResultCode
+	}
+
+	code := modify(1, &ldapserver.PasswordModifyRequest{UserIdentity: "uid=jdoe,ou=users,dc=example,dc=com", NewPasswd: "new"})
+	if code != ldapserver.ResultSuccess || users.password != "new" {
+		t.Fatal("password not changed", code, users.password)
+	}
+	for _, tc := range []struct {
+		user string
+		code ldapserver.LDAPResultCode
+	}{
+		// Middlewares of the naming context apply
+		{"cn=admins,ou=groups,dc=example,dc=com", ldapserver.ResultUnwillingToPerform},
+		{"cn=printer,ou=devices,dc=example,dc=com", ldapserver.ResultUnwillingToPerform},
+		{"uid=jdoe,dc=example,dc=org", ldapserver.ResultNoSuchObject},
+		// Not bound
+		{"", ldapserver.ResultNoSuchObject},
+	} {
+		if code := modify(2, &ldapserver.PasswordModifyRequest{UserIdentity: tc.user, NewPasswd: "other"}); code != tc.code {
+			t.Fatal("wrong result code for", tc.user, code)
+		}
+	}
+	// The user the connection is bound as
+	doResult(t, conn, 3, ldapserver.TypeBindRequestOp, (&ldapserver.BindRequest{
+		Version: 3, Name: "uid=jdoe,ou=users,dc=example,dc=com", AuthType: ldapserver.AuthenticationTypeSimple, Credentials: "new",
+	}).Encode())
+	if code := modify(4, &ldapserver.PasswordModifyRequest{NewPasswd: "bound"}); code != ldapserver.ResultSuccess || users.password != "bound" {
+		t.Fatal("password of the bound user not changed", code, users.password)
+	}
+
+	supported := func(conn net.Conn) []string {
+		t.Helper()
+		responses := doRequest(t, conn, 5, ldapserver.TypeSearchRequestOp, (&ldapserver.SearchRequest{
+			Scope: ldapserver.SearchScopeBaseObject, Attributes: []string{"supportedExtension"},
+		}).Encode())
+		entry, err := ldapserver.GetSearchResultEntry(responses[0].ProtocolOp.Data)
+		if err != nil {
+			t.Fatal("Error parsing entry:", err)
+		}
+		if len(entry.Attributes) == 0 {
+			return nil
+		}
+		return entry.Attributes[0].Values
+	}
+	if extensions := supported(conn); !slicesEqual(extensions, []string{string(ldapserver.OIDPasswordModify)}) {
+		t.Fatal("wrong supported extensions", extensions)
+	}
+
+	// A mux without PasswordModifiers does not support the operation
+	mux = ldapserver.NewLDAPMux()
+	mux.Handle("dc=example,dc=com", &ldapserver.BaseHandler{})
+	conn = startTestServer(t, mux)
+	if extensions := supported(conn); extensions != nil {
+		t.Fatal("wrong supported extensions", extensions)
+	}
+	if code := modify(1, &ldapserver.PasswordModifyRequest{UserIdentity: "uid=jdoe,dc=example,dc=com"}); code != ldapserver.ResultProtocolError {
+		t.Fatal("unsupported Password Modify not rejected", code)
+	}
+}
@@ -0,0 +1,120 @@
+package ldapserver
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A token bucket rate limit.
+// Each attempt takes a token from the bucket, and tokens are added at the specified rate
+// up to the burst size. Attempts are refused while the bucket is empty.
+// A zero Rate means no limit, and a Burst less than 1 is treated as 1.
+type RateLimit struct {
+	// Tokens added per second
+	Rate float64
+	// Maximum number of tokens, i.e. the number of attempts allowed at once
+	Burst int
+}
+
+// Type of a limit enforced by the server on connections and Bind requests
+type LimitType string
+
+// Limits enforced by the server on connections and Bind requests
+const (
+	LimitMaxConnections      LimitType = "max_connections"
+	LimitMaxConnectionsPerIP LimitType = "max_connections_per_ip"
+	LimitBindRatePerIP       LimitType = "bind_rate_per_ip"
+	LimitBindRatePerDN       LimitType = "bind_rate_per_dn"
+)
+
+// Describes a connection or Bind request that exceeded one of the server's limits
+type LimitExceeded struct {
+	// The limit that was exceeded
+	Limit LimitType
+	// Address of the client
+	RemoteAddr net.Addr
+	// DN of the Bind request, for the Bind rate limits
+	BindDN string
+}
+
+// A token bucket for one key
+type tokenBucket struct {
+	tokens float64
+	// Time the tokens were last updated
+	updated time.Time
+}
+
+// Token buckets keyed by client IP or bind DN
+type rateLimiter struct {
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+	// Time full buckets were last removed
+	swept time.Time
+}
+
+// Time between removals of full buckets
+const rateLimiterSweepInterval = time.Minute
+
+// Takes a token from the bucket for the key.
+// Returns false if the bucket is empty.
+func (l *rateLimiter) allow(key string, limit RateLimit, now time.Time) bool {
+	if limit.Rate <= 0 {
+		return true
+	}
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+		l.swept = now
+	}
+	if now.Sub(l.swept) > rateLimiterSweepInterval {
+		// Forget the keys whose buckets have filled up again
+		for k, b := range l.buckets {
+			if b.tokens+now.Sub(b.updated).Seconds()*limit.Rate >= burst {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+	b := l.buckets[key]
+	if b == nil {
+		b = &tokenBucket{tokens: burst, updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.updated).Seconds() * limit.Rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.updated = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Returns the IP address of a client address, used to key the per-IP limits
+func remoteIP(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// Returns the normalized form of a bind DN, used to key the per-DN limit
+func bindDNKey(name string) string {
+	if dn, err := ParseDN(name); err == nil {
+		name = dn.String()
+	}
+	return strings.ToLower(name)
+}
@@ -1,6 +1,9 @@
 package ldapserver
 
-import "bytes"
+import (
+	"bytes"
+	"strconv"
+)
 
 // LDAP result code
 type LDAPResultCode uint32
@@ -43,7 +46,11 @@ const (
 	ResultUnavailable                 LDAPResultCode = 52
 	ResultUnwillingToPerform          LDAPResultCode = 53
 	ResultLoopDetect                  LDAPResultCode = 54
-	// 55-63 unused
+	// 55-59 unused
+	// 60-61 defined for the Virtual List View control
+	ResultSortControlMissing LDAPResultCode = 60
+	ResultOffsetRangeError   LDAPResultCode = 61
+	// 62-63 unused
 	ResultNamingViolation           LDAPResultCode = 64
 	ResultObjectClassViolation      LDAPResultCode = 65
 	ResultNotAllowedOnNonLeaf       LDAPResultCode = 66
@@ -51,12 +58,15 @@ const (
 	ResultEntryAlreadyExists        LDAPResultCode = 68
 	ResultObjectClassModsProhibited LDAPResultCode = 69
 	// 70 reserved
-	ResultAffectsMultibleDSAs LDAPResultCode = 70
+	ResultAffectsMultipleDSAs LDAPResultCode = 71
 	// 72-79 unused
 	ResultOther LDAPResultCode = 80
 	// extensible, more codes possible
 )
 
+// Deprecated: misspelled and with the wrong value, use ResultAffectsMultipleDSAs.
+const ResultAffectsMultibleDSAs = ResultAffectsMultipleDSAs
+
 //	LDAPResult ::= SEQUENCE {
 //			resultCode         ENUMERATED {
 //	         -- Defined result codes --
@@ -85,37 +95,59 @@ func GetResult(data []byte) (*Result, error) {
 	if err != nil {
 		return nil, err
 	}
-	if len(seq) != 3 && len(seq) != 4 {
+	res, rest, err := getResultComponents(seq, "LDAPResult")
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
 		return nil, ErrWrongSequenceLength.WithInfo("LDAPResult sequence length", len(seq))
 	}
+	return res, nil
+}
+
+// Parse the LDAPResult components at the start of a response sequence.
+// Returns the Result and the remaining elements of the sequence.
+func getResultComponents(seq []BerRawElement, name string) (*Result, []BerRawElement, error) {
+	if len(seq) < 3 {
+		return nil, nil, ErrWrongSequenceLength.WithInfo(name+" sequence length", len(seq))
+	}
 	if seq[0].Type != BerTypeEnumerated {
-		return nil, ErrWrongElementType.WithInfo("LDAPResult result code type", seq[0].Type)
+		return nil, nil, ErrWrongElementType.WithInfo(name+" result code type", seq[0].Type)
 	}
 	resultCode, err := BerGetInteger(seq[0].Data)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if resultCode < 0 || resultCode > maxInt {
+		return nil, nil, ErrIntegerTooLarge.WithInfo(name+" result code", resultCode)
 	}
 	if seq[1].Type != BerTypeOctetString {
-		return nil, ErrWrongElementType.WithInfo("LDAPResult matched DN type", seq[1].Type)
+		return nil, nil, ErrWrongElementType.WithInfo(name+" matched DN type", seq[1].Type)
 	}
 	matchedDN := BerGetOctetString(seq[1].Data)
 	if seq[2].Type != BerTypeOctetString {
-		return nil, ErrWrongElementType.WithInfo("LDAPResult diagnostic message type", seq[2].Type)
+		return nil, nil, ErrWrongElementType.WithInfo(name+" diagnostic message type", seq[2].Type)
 	}
 	diagnosticMsg := BerGetOctetString(seq[2].Data)
+	rest := seq[3:]
+	// referral [3] Referral OPTIONAL
 	var referral []string
-	if len(seq) == 4 {
-		if seq[3].Type.Class() != BerClassContextSpecific ||
-			seq[3].Type.TagNumber() != 3 {
-			return nil, ErrWrongElementType.WithInfo("LDAPResult referral type", seq[3].Type)
-		}
-		r_seq, err := BerGetSequence(seq[3].Data)
+	if len(rest) > 0 && rest[0].Type.Class() == BerClassContextSpecific && rest[0].Type.TagNumber() == 3 {
+		r_seq, err := BerGetSequence(rest[0].Data)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		// Referral ::= SEQUENCE SIZE (1..MAX) OF uri URI
+		if len(r_seq) == 0 {
+			return nil, nil, ErrWrongSequenceLength.WithInfo(name+" referral sequence length", len(r_seq))
 		}
 		for _, rr := range r_seq {
+			if rr.Type != BerTypeOctetString {
+				return nil, nil, ErrWrongElementType.WithInfo(name+" referral URI type", rr.Type)
+			}
 			referral = append(referral, BerGetOctetString(rr.Data))
 		}
+		rest = rest[1:]
 	}
 	res := &Result{
 		ResultCode:        LDAPResultCode(resultCode),
@@ -123,7 +155,7 @@ func GetResult(data []byte) (*Result, error) {
 		DiagnosticMessage: diagnosticMsg,
 		Referral:          referral,
 	}
-	return res, nil
+	return res, rest, nil
 }
 
 // Return the BER-encoded struct (without element header)
@@ -137,23 +169,58 @@ func (r *Result) Encode() []byte {
 		for _, ref := range r.Referral {
 			referrals.Write(BerEncodeOctetString(ref))
 		}
-		w.Write(BerEncodeSequence(referrals.Bytes()))
+		w.Write(BerEncodeElement(BerContextSpecificType(3, true), referrals.Bytes()))
 	}
 	return w.Bytes()
 }
 
+// Return an IntermediateResponse from BER-encoded data
+func GetIntermediateResponse(data []byte) (*IntermediateResponse, error) {
+	seq, err := BerGetSequence(data)
+	if err != nil {
+		return nil, err
+	}
+	r := &IntermediateResponse{}
+	// responseName [0] LDAPOID OPTIONAL
+	if len(seq) > 0 && seq[0].Type == BerContextSpecificType(0, false) {
+		r.Name = BerGetOctetString(seq[0].Data)
+		if err = OID(r.Name).Validate(); err != nil {
+			return nil, err
+		}
+		seq = seq[1:]
+	}
+	// responseValue [1] OCTET STRING OPTIONAL
+	if len(seq) > 0 && seq[0].Type == BerContextSpecificType(1, false) {
+		r.Value = BerGetOctetString(seq[0].Data)
+		seq = seq[1:]
+	}
+	if len(seq) != 0 {
+		return nil, ErrWrongElementType.WithInfo("IntermediateResponse element type", seq[0].Type)
+	}
+	return r, nil
+}
+
 // Return the BER-encoded struct (without element header)
 func (r *IntermediateResponse) Encode() []byte {
 	w := bytes.NewBuffer(nil)
 	if r.Name != "" {
-		w.Write(BerEncodeElement(BerContextSpecificType(0, false), BerEncodeOctetString(r.Name)))
+		w.Write(BerEncodeElement(BerContextSpecificType(0, false), []byte(r.Name)))
 	}
 	if r.Value != "" {
-		w.Write(BerEncodeElement(BerContextSpecificType(1, false), BerEncodeOctetString(r.Value)))
+		w.Write(BerEncodeElement(BerContextSpecificType(1, false), []byte(r.Value)))
 	}
 	return w.Bytes()
 }
 
+// Returns a description of the result, allowing a Result to be used as an error
+func (r *Result) Error() string {
+	msg := "LDAP result code " + strconv.FormatUint(uint64(r.ResultCode), 10)
+	if r.DiagnosticMessage != "" {
+		msg += ": " + r.DiagnosticMessage
+	}
+	return msg
+}
+
 func (r LDAPResultCode) AsResult(diagnosticMessage string) *Result {
 	res := &Result{
 		ResultCode:        r,
@@ -0,0 +1,185 @@
+package ldapserver
+
+import "context"
+
+// Information published in the Root DSE, the entry with the empty DN
+// that clients read to discover the capabilities of the server (RFC 4512 section 5.1).
+type RootDSE struct {
+	// DNs of the naming contexts held by the server
+	NamingContexts []string
+	// OIDs of the supported request controls
+	SupportedControls []OID
+	// OIDs of the supported extended operations
+	SupportedExtensions []OID
+	// OIDs of the supported features, e.g. "+" for all operational attributes
+	SupportedFeatures []OID
+	// Names of the supported SASL mechanisms
+	SupportedSASLMechanisms []string
+	// DN of the subschema entry
+	SubschemaSubentry string
+	// Other operational attributes, e.g. vendorName and vendorVersion
+	Attributes []Attribute
+}
+
+// Interface for handlers that publish information in the Root DSE,
+// such as the naming contexts they hold and the controls and extensions they support.
+// AddToRootDSE is called by Conn.RootDSE for each Search request for the Root DSE.
+type RootDSEProvider interface {
+	AddToRootDSE(dse *RootDSE)
+}
+
+// Feature OID for requesting all operational attributes with "+" (RFC 3673)
+const OIDAllOperationalAttributes OID = "1.3.6.1.4.1.4203.1.5.1"
+
+// Feature OID for the absolute true and false filters (RFC 4526)
+const OIDAbsoluteTrueFalseFilters OID = "1.3.6.1.4.1.4203.1.5.3"
+
+// Add the values of another RootDSE, skipping duplicates
+func (d *RootDSE) merge(other *RootDSE) {
+	for _, nc := range other.NamingContexts {
+		d.addNamingContext(nc)
+	}
+	d.SupportedControls = appendOIDs(d.SupportedControls, other.SupportedControls...)
+	d.SupportedExtensions = appendOIDs(d.SupportedExtensions, other.SupportedExtensions...)
+	d.SupportedFeatures = appendOIDs(d.SupportedFeatures, other.SupportedFeatures...)
+	for _, mech := range other.SupportedSASLMechanisms {
+		if !containsFold(d.SupportedSASLMechanisms, mech) {
+			d.SupportedSASLMechanisms = append(d.SupportedSASLMechanisms, mech)
+		}
+	}
+	if d.SubschemaSubentry == "" {
+		d.SubschemaSubentry = other.SubschemaSubentry
+	}
+	for _, attr := range other.Attributes {
+		d.Attributes = append(d.Attributes, Attribute{
+			Description: attr.Description,
+			Values:      append([]string(nil), attr.Values...),
+		})
+	}
+}
+
+// Add a naming context unless an equivalent DN is already present
+func (d *RootDSE) addNamingContext(namingContext string) {
+	dn, err := ParseDN(namingContext)
+	if err != nil {
+		return
+	}
+	normalized := normalizeDN(dn)
+	for _, nc := range d.NamingContexts {
+		if existing, err := ParseDN(nc); err == nil && normalizeDN(existing).Equal(normalized) {
+			return
+		}
+	}
+	d.NamingContexts = append(d.NamingContexts, namingContext)
+}
+
+// Append the OIDs that are not already in the list
+func appendOIDs(list []OID, oids ...OID) []OID {
+	for _, oid := range oids {
+		found := false
+		for _, o := range list {
+			if o == oid {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, oid)
+		}
+	}
+	return list
+}
+
+// Returns the Root DSE as an entry with the empty DN
+func (d *RootDSE) Entry() *Entry {
+	entry := NewEntry(nil,
+		Attribute{Description: "objectClass", Values: []string{"top"}},
+		Attribute{Description: "supportedLDAPVersion", Values: []string{"3"}},
+	)
+	add := func(description string, values []string) {
+		if len(values) > 0 {
+			entry.Attributes = append(entry.Attributes, Attribute{Description: description, Values: values})
+		}
+	}
+	oids := func(list []OID) []string {
+		values := make([]string, len(list))
+		for i, oid := range list {
+			values[i] = string(oid)
+		}
+		return values
+	}
+	add("namingContexts", d.NamingContexts)
+	add("supportedControl", oids(d.SupportedControls))
+	add("supportedExtension", oids(d.SupportedExtensions))
+	add("supportedFeatures", oids(d.SupportedFeatures))
+	add("supportedSASLMechanisms", d.SupportedSASLMechanisms)
+	if d.SubschemaSubentry != "" {
+		add("subschemaSubentry", []string{d.SubschemaSubentry})
+	}
+	for _, attr := range d.Attributes {
+		add(attr.Description, append([]string(nil), attr.Values...))
+	}
+	return entry
+}
+
+// Returns true if the Search request reads the Root DSE,
+// i.e. it is a base object search of the empty DN
+func (r *SearchRequest) IsRootDSESearch() bool {
+	return r.BaseObject == "" && r.Scope == SearchScopeBaseObject
+}
+
+// Collect the Root DSE values configured on the server and added by its handler
+func (s *LDAPServer) rootDSE() *RootDSE {
+	dse := &RootDSE{}
+	if s.RootDSE != nil {
+		dse.merge(s.RootDSE)
+	}
+	if p, ok := s.Handler.(RootDSEProvider); ok {
+		contributed := &RootDSE{}
+		p.AddToRootDSE(contributed)
+		dse.merge(contributed)
+	}
+	return dse
+}
+
+// Returns the information published in the Root DSE for the connection:
+// the features and supported controls of the server, the server's RootDSE and Schema,
+// and the values added by its handler.
+func (c *Conn) RootDSE() *RootDSE {
+	dse := &RootDSE{
+		SupportedFeatures: []OID{OIDAllOperationalAttributes, OIDAbsoluteTrueFalseFilters},
+	}
+	if c.TLSConfig != nil {
+		dse.SupportedExtensions = append(dse.SupportedExtensions, OIDStartTLS)
+	}
+	if passwordModifier(c.handler) != nil {
+		dse.SupportedExtensions = append(dse.SupportedExtensions, OIDPasswordModify)
+	}
+	dse.SupportedControls = c.controls.OIDs()
+	if c.rootDSE != nil {
+		dse.merge(c.rootDSE())
+	}
+	if c.schema != nil && dse.SubschemaSubentry == "" {
+		dse.SubschemaSubentry = SubschemaDN
+	}
+	return dse
+}
+
+// Answer a Search request for the Root DSE with the connection's RootDSE.
+// The server calls this for Search requests where IsRootDSESearch is true
+// instead of passing them to its handler.
+func SearchRootDSE(ctx context.Context, conn *Conn, msg *Message, req *SearchRequest) {
+	entry := conn.RootDSE().Entry()
+	if req.Filter == nil || req.Filter.Match(entry) == FilterTrue {
+		err := conn.SendResult(msg.MessageID, nil, TypeSearchResultEntryOp,
+			entry.operationalSearchResultEntry([]string{"objectClass"}, req.Attributes, req.TypesOnly))
+		if err != nil {
+			return
+		}
+	}
+	if ctx.Err() != nil {
+		// Abandoned or disconnected
+		return
+	}
+	conn.SendResult(msg.MessageID, nil, TypeSearchResultDoneOp, ResultSuccess.AsResult(""))
+}
@@ -0,0 +1,109 @@
+package ldapserver_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/merlinz01/ldapserver"
+)
+
+func TestRootDSE(t *testing.T) {
+	conn := startTestServer(t, ldapserver.Chain(newTestMux(t), ldapserver.RequireAuthentication))
+	search := func(id ldapserver.MessageID, filter string, attributes ...string) *ldapserver.SearchResultEntry {
+		t.Helper()
+		responses := doRequest(t, conn, id, ldapserver.TypeSearchRequestOp, (&ldapserver.SearchRequest{
+			Scope: ldapserver.SearchScopeBaseObject, Filter: ldapserver.MustParseFilter(filter), Attributes: attributes,
+		}).Encode())
+		res, err := ldapserver.GetResult(responses[len(responses)-1].ProtocolOp.Data)
+		if err != nil {
+			t.Fatal("Error parsing result:", err)
+		}
+		if res.ResultCode != ldapserver.ResultSuccess {
+			t.Fatal("wrong result code", res.ResultCode)
+		}
+		if len(responses) == 1 {
+			return nil
+		}
+		entry, err := ldapserver.GetSearchResultEntry(responses[0].ProtocolOp.Data)
+		if err != nil {
+			t.Fatal("Error parsing entry:", err)
+		}
+		if entry.ObjectName != "" {
+			t.Fatal("wrong Root DSE DN", entry.ObjectName)
+		}
+		return entry
+	}
+	values := func(entry *ldapserver.SearchResultEntry, description string) []string {
+		for _, attr := range entry.Attributes {
+			if attr.Description == description {
+				return attr.Values
+			}
+		}
+		return nil
+	}
+
+	entry := search(1, "(objectClass=*)")
+	if len(entry.Attributes) != 1 || !slicesEqual(values(entry, "objectClass"), []string{"top"}) {
+		t.Fatal("wrong user attributes", entry.Attributes)
+	}
+	entry = search(2, "(objectClass=*)", "+")
+	if !slicesEqual(values(entry, "namingContexts"), []string{"ou=legacy,dc=example,dc=com", "dc=example,dc=com"}) {
+		t.Fatal("wrong naming contexts", values(entry, "namingContexts"))
+	}
+	if !slicesEqual(values(entry, "supportedLDAPVersion"), []string{"3"}) {
+		t.Fatal("wrong supported LDAP versions", values(entry, "supportedLDAPVersion"))
+	}
+	if values(entry, "objectClass") != nil || values(entry, "supportedFeatures") == nil {
+		t.Fatal("wrong operational attributes", entry.Attributes)
+	}
+	entry = search(3, "(objectClass=*)", "supportedLDAPVersion", "SUPPORTEDEXTENSION")
+	if len(entry.Attributes) != 1 || entry.Attributes[0].Description != "supportedLDAPVersion" {
+		t.Fatal("wrong requested attributes", entry.Attributes)
+	}
+	entry = search(4, "(objectClass=*)", "1.1")
+	if len(entry.Attributes) != 0 {
+		t.Fatal("attributes returned for 1.1", entry.Attributes)
+	}
+	if entry = search(5, "(namingContexts=dc=example,dc=org)"); entry != nil {
+		t.Fatal("Root DSE returned for non-matching filter")
+	}
+	if entry = search(6, "(namingContexts=DC=Example,DC=com)"); entry == nil {
+		t.Fatal("Root DSE not returned for matching filter")
+	}
+}
+
+// Handler that rejects all Search requests and publishes a naming context
+type noSearchHandler struct {
+	ldapserver.BaseHandler
+}
+
+func (h *noSearchHandler) Search(ctx context.Context, conn *ldapserver.Conn, msg *ldapserver.Message, req *ldapserver.SearchRequest) {
+	conn.SendResult(msg.MessageID, nil, ldapserver.TypeSearchResultDoneOp, ldapserver.ResultUnwillingToPerform.AsResult(""))
+}
+
+func (h *noSearchHandler) AddToRootDSE(dse *ldapserver.RootDSE) {
+	dse.NamingContexts = append(dse.NamingContexts, "dc=example,dc=com")
+}
+
+func TestRootDSEAnsweredByServer(t *testing.T) {
+	conn := startTestServer(t, &noSearchHandler{})
+	responses := doRequest(t, conn, 1, ldapserver.TypeSearchRequestOp, (&ldapserver.SearchRequest{
+		Scope: ldapserver.SearchScopeBaseObject, Attributes: []string{"namingContexts"},
+	}).Encode())
+	if len(responses) != 2 {
+		t.Fatal("Root DSE not returned", len(responses))
+	}
+	entry, err := ldapserver.GetSearchResultEntry(responses[0].ProtocolOp.Data)
+	if err != nil {
+		t.Fatal("Error parsing entry:", err)
+	}
+	if len(entry.Attributes) != 1 || !slicesEqual(entry.Attributes[0].Values, []string{"dc=example,dc=com"}) {
+		t.Fatal("wrong naming contexts", entry.Attributes)
+	}
+	res := doResult(t, conn, 2, ldapserver.TypeSearchRequestOp, (&ldapserver.SearchRequest{
+		BaseObject: "dc=example,dc=com", Scope: ldapserver.SearchScopeBaseObject,
+	}).Encode())
+	if res.ResultCode != ldapserver.ResultUnwillingToPerform {
+		t.Fatal("Search not passed to the handler", res.ResultCode)
+	}
+}
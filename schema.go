@@ -0,0 +1,672 @@
+package ldapserver
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Usage of an attribute type
+type AttributeUsage uint8
+
+// Defined attribute usages
+const (
+	UsageUserApplications     AttributeUsage = 0
+	UsageDirectoryOperation   AttributeUsage = 1
+	UsageDistributedOperation AttributeUsage = 2
+	UsageDSAOperation         AttributeUsage = 3
+)
+
+func (u AttributeUsage) String() string {
+	switch u {
+	case UsageDirectoryOperation:
+		return "directoryOperation"
+	case UsageDistributedOperation:
+		return "distributedOperation"
+	case UsageDSAOperation:
+		return "dSAOperation"
+	}
+	return "userApplications"
+}
+
+// Kind of an object class
+type ObjectClassKind uint8
+
+// Defined object class kinds
+const (
+	ObjectClassStructural ObjectClassKind = 0
+	ObjectClassAbstract   ObjectClassKind = 1
+	ObjectClassAuxiliary  ObjectClassKind = 2
+)
+
+func (k ObjectClassKind) String() string {
+	switch k {
+	case ObjectClassAbstract:
+		return "ABSTRACT"
+	case ObjectClassAuxiliary:
+		return "AUXILIARY"
+	}
+	return "STRUCTURAL"
+}
+
+// An attribute type definition (RFC 4512 section 4.1.2)
+type AttributeType struct {
+	OID OID
+	// Short names of the attribute type, the first being the primary name
+	Names       []string
+	Description string
+	Obsolete    bool
+	// Name or OID of the supertype
+	Superior string
+	// Names or OIDs of the equality, ordering and substrings matching rules
+	Equality   string
+	Ordering   string
+	Substrings string
+	// OID of the syntax, and the suggested maximum length of values or 0
+	Syntax       OID
+	SyntaxLength int
+	SingleValue  bool
+	Collective   bool
+	// Whether the values can only be changed by the server
+	NoUserModification bool
+	Usage              AttributeUsage
+	// Extensions, e.g. X-ORIGIN, keyed by name
+	Extensions map[string][]string
+}
+
+// Returns the primary name of the attribute type, or its OID if it has no name
+func (a *AttributeType) Name() string {
+	if len(a.Names) > 0 {
+		return a.Names[0]
+	}
+	return string(a.OID)
+}
+
+// Returns whether the attribute type is an operational attribute
+func (a *AttributeType) IsOperational() bool {
+	return a.Usage != UsageUserApplications
+}
+
+// Returns the definition in RFC 4512 syntax
+func (a *AttributeType) String() string {
+	var b schemaBuilder
+	b.start(a.OID, a.Names, a.Description, a.Obsolete)
+	b.word("SUP", a.Superior)
+	b.word("EQUALITY", a.Equality)
+	b.word("ORDERING", a.Ordering)
+	b.word("SUBSTR", a.Substrings)
+	if a.Syntax != "" {
+		syntax := string(a.Syntax)
+		if a.SyntaxLength > 0 {
+			syntax += "{" + strconv.Itoa(a.SyntaxLength) + "}"
+		}
+		b.word("SYNTAX", syntax)
+	}
+	b.flag("SINGLE-VALUE", a.SingleValue)
+	b.flag("COLLECTIVE", a.Collective)
+	b.flag("NO-USER-MODIFICATION", a.NoUserModification)
+	if a.Usage != UsageUserApplications {
+		b.word("USAGE", a.Usage.String())
+	}
+	return b.end(a.Extensions)
+}
+
+// An object class definition (RFC 4512 section 4.1.1)
+type ObjectClass struct {
+	OID OID
+	// Short names of the object class, the first being the primary name
+	Names       []string
+	Description string
+	Obsolete    bool
+	// Names or OIDs of the superclasses
+	Superiors []string
+	Kind      ObjectClassKind
+	// Names or OIDs of the required and allowed attribute types
+	Must []string
+	May  []string
+	// Extensions, e.g. X-ORIGIN, keyed by name
+	Extensions map[string][]string
+}
+
+// Returns the primary name of the object class, or its OID if it has no name
+func (o *ObjectClass) Name() string {
+	if len(o.Names) > 0 {
+		return o.Names[0]
+	}
+	return string(o.OID)
+}
+
+// Returns the definition in RFC 4512 syntax
+func (o *ObjectClass) String() string {
+	var b schemaBuilder
+	b.start(o.OID, o.Names, o.Description, o.Obsolete)
+	b.list("SUP", o.Superiors)
+	b.flag(o.Kind.String(), true)
+	b.list("MUST", o.Must)
+	b.list("MAY", o.May)
+	return b.end(o.Extensions)
+}
+
+// A matching rule definition (RFC 4512 section 4.1.3).
+// The implementation of the rule is a MatchingRule.
+type MatchingRuleDescription struct {
+	OID OID
+	// Short names of the matching rule, the first being the primary name
+	Names       []string
+	Description string
+	Obsolete    bool
+	// OID of the syntax of assertion values
+	Syntax OID
+	// Extensions, e.g. X-ORIGIN, keyed by name
+	Extensions map[string][]string
+}
+
+// Returns the definition in RFC 4512 syntax
+func (m *MatchingRuleDescription) String() string {
+	var b schemaBuilder
+	b.start(m.OID, m.Names, m.Description, m.Obsolete)
+	b.word("SYNTAX", string(m.Syntax))
+	return b.end(m.Extensions)
+}
+
+// A LDAP syntax definition (RFC 4512 section 4.1.5)
+type LDAPSyntax struct {
+	OID         OID
+	Description string
+	// Extensions, e.g. X-NOT-HUMAN-READABLE, keyed by name
+	Extensions map[string][]string
+	// Returns true if the value conforms to the syntax.
+	// If nil when the syntax is added to a Schema, it is set for the syntaxes of RFC 4517
+	// that can be checked, and other values are accepted as-is.
+	Validate func(value string) bool
+}
+
+// Returns the definition in RFC 4512 syntax
+func (s *LDAPSyntax) String() string {
+	var b schemaBuilder
+	b.start(s.OID, nil, s.Description, false)
+	return b.end(s.Extensions)
+}
+
+// Builder for the RFC 4512 syntax of definitions
+type schemaBuilder struct {
+	strings.Builder
+}
+
+func (b *schemaBuilder) start(oid OID, names []string, description string, obsolete bool) {
+	b.WriteString("( ")
+	b.WriteString(string(oid))
+	if len(names) == 1 {
+		b.WriteString(" NAME " + escapeQDString(names[0]))
+	} else if len(names) > 1 {
+		b.WriteString(" NAME (")
+		for _, name := range names {
+			b.WriteString(" " + escapeQDString(name))
+		}
+		b.WriteString(" )")
+	}
+	if description != "" {
+		b.WriteString(" DESC " + escapeQDString(description))
+	}
+	b.flag("OBSOLETE", obsolete)
+}
+
+func (b *schemaBuilder) flag(keyword string, present bool) {
+	if present {
+		b.WriteString(" " + keyword)
+	}
+}
+
+func (b *schemaBuilder) word(keyword string, value string) {
+	if value != "" {
+		b.WriteString(" " + keyword + " " + value)
+	}
+}
+
+func (b *schemaBuilder) list(keyword string, values []string) {
+	if len(values) == 1 {
+		b.word(keyword, values[0])
+	} else if len(values) > 1 {
+		b.WriteString(" " + keyword + " ( " + strings.Join(values, " $ ") + " )")
+	}
+}
+
+func (b *schemaBuilder) end(extensions map[string][]string) string {
+	keys := make([]string, 0, len(extensions))
+	for key := range extensions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values := extensions[key]
+		if len(values) == 1 {
+			b.WriteString(" " + key + " " + escapeQDString(values[0]))
+			continue
+		}
+		b.WriteString(" " + key + " (")
+		for _, v := range values {
+			b.WriteString(" " + escapeQDString(v))
+		}
+		b.WriteString(" )")
+	}
+	b.WriteString(" )")
+	return b.String()
+}
+
+// Definitions in RFC 4512 syntax, e.g. the contents of a schema file
+type SchemaDefinitions struct {
+	LDAPSyntaxes   []string
+	MatchingRules  []string
+	AttributeTypes []string
+	ObjectClasses  []string
+}
+
+// A directory schema holding attribute type, object class, matching rule and syntax definitions.
+//
+// Names and OIDs are resolved case-insensitively.
+// A Schema is a MatchingRuleResolver that selects the matching rules of attribute types,
+// so it can be used to evaluate filters according to the schema,
+// e.g. by setting it as the MatchingRules of a MemoryHandler.
+type Schema struct {
+	// Implementations of the matching rules named by the attribute types.
+	// Attributes that are not in the schema, or whose matching rules are not implemented,
+	// use the rules of the resolver for the attribute.
+	// If nil, DefaultMatchingRules is used.
+	MatchingRules MatchingRuleResolver
+	// Lock protecting the definitions
+	lock sync.RWMutex
+	// Definitions keyed by lowercased name and OID
+	syntaxes       map[string]*LDAPSyntax
+	matchingRules  map[string]*MatchingRuleDescription
+	attributeTypes map[string]*AttributeType
+	objectClasses  map[string]*ObjectClass
+	// Definitions in the order they were added
+	syntaxList        []*LDAPSyntax
+	matchingRuleList  []*MatchingRuleDescription
+	attributeTypeList []*AttributeType
+	objectClassList   []*ObjectClass
+}
+
+// Create a new empty schema.
+func NewSchema() *Schema {
+	return &Schema{
+		syntaxes:       make(map[string]*LDAPSyntax),
+		matchingRules:  make(map[string]*MatchingRuleDescription),
+		attributeTypes: make(map[string]*AttributeType),
+		objectClasses:  make(map[string]*ObjectClass),
+	}
+}
+
+// Create a new schema with the core, COSINE and inetOrgPerson definitions.
+func NewDefaultSchema() *Schema {
+	s := NewSchema()
+	for _, defs := range []*SchemaDefinitions{CoreSchema, CosineSchema, InetOrgPersonSchema} {
+		if err := s.Load(defs); err != nil {
+			panic(err)
+		}
+	}
+	return s
+}
+
+// Parse the definitions and add them to the schema,
+// syntaxes first, then matching rules, attribute types and object classes.
+// Definitions may only refer to definitions added before them.
+func (s *Schema) Load(defs *SchemaDefinitions) error {
+	for _, def := range defs.LDAPSyntaxes {
+		syntax, err := ParseLDAPSyntax(def)
+		if err == nil {
+			err = s.AddLDAPSyntax(syntax)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, def := range defs.MatchingRules {
+		mr, err := ParseMatchingRuleDescription(def)
+		if err == nil {
+			err = s.AddMatchingRuleDescription(mr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, def := range defs.AttributeTypes {
+		at, err := ParseAttributeType(def)
+		if err == nil {
+			err = s.AddAttributeType(at)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, def := range defs.ObjectClasses {
+		oc, err := ParseObjectClass(def)
+		if err == nil {
+			err = s.AddObjectClass(oc)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the keys of a definition, or an error if any of them is already used
+func schemaKeys[T any](m map[string]T, oid OID, names []string) ([]string, error) {
+	keys := []string{string(oid)}
+	for _, name := range names {
+		keys = append(keys, strings.ToLower(name))
+	}
+	for _, key := range keys {
+		if _, ok := m[key]; ok {
+			return nil, ErrInvalidSchemaDefinition.WithInfo("duplicate name or OID", key)
+		}
+	}
+	return keys, nil
+}
+
+// Add a syntax definition.
+func (s *Schema) AddLDAPSyntax(syntax *LDAPSyntax) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	keys, err := schemaKeys(s.syntaxes, syntax.OID, nil)
+	if err != nil {
+		return err
+	}
+	if syntax.Validate == nil {
+		syntax.Validate = syntaxValidators[syntax.OID]
+	}
+	for _, key := range keys {
+		s.syntaxes[key] = syntax
+	}
+	s.syntaxList = append(s.syntaxList, syntax)
+	return nil
+}
+
+// Add a matching rule definition. Its syntax must be defined.
+func (s *Schema) AddMatchingRuleDescription(mr *MatchingRuleDescription) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.syntaxes[string(mr.Syntax)] == nil {
+		return ErrInvalidSchemaDefinition.WithInfo("unknown syntax", mr.Syntax)
+	}
+	keys, err := schemaKeys(s.matchingRules, mr.OID, mr.Names)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		s.matchingRules[key] = mr
+	}
+	s.matchingRuleList = append(s.matchingRuleList, mr)
+	return nil
+}
+
+// Add an attribute type definition.
+// Its supertype, matching rules and syntax must be defined.
+func (s *Schema) AddAttributeType(at *AttributeType) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if at.Superior != "" {
+		sup := s.attributeTypes[strings.ToLower(at.Superior)]
+		if sup == nil {
+			return ErrInvalidSchemaDefinition.WithInfo("unknown supertype", at.Superior)
+		}
+		if sup.Usage != at.Usage {
+			return ErrInvalidSchemaDefinition.WithInfo("usage differs from supertype", at.Name())
+		}
+	}
+	for _, rule := range []string{at.Equality, at.Ordering, at.Substrings} {
+		if rule != "" && s.matchingRules[strings.ToLower(rule)] == nil {
+			return ErrInvalidSchemaDefinition.WithInfo("unknown matching rule", rule)
+		}
+	}
+	if at.Syntax != "" && s.syntaxes[string(at.Syntax)] == nil {
+		return ErrInvalidSchemaDefinition.WithInfo("unknown syntax", at.Syntax)
+	}
+	keys, err := schemaKeys(s.attributeTypes, at.OID, at.Names)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		s.attributeTypes[key] = at
+	}
+	s.attributeTypeList = append(s.attributeTypeList, at)
+	return nil
+}
+
+// Add an object class definition.
+// Its superclasses and attribute types must be defined,
+// and its superclasses must be abstract or of the same kind.
+func (s *Schema) AddObjectClass(oc *ObjectClass) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, name := range oc.Superiors {
+		sup := s.objectClasses[strings.ToLower(name)]
+		if sup == nil {
+			return ErrInvalidSchemaDefinition.WithInfo("unknown superclass", name)
+		}
+		if sup.Kind != ObjectClassAbstract && sup.Kind != oc.Kind {
+			return ErrInvalidSchemaDefinition.WithInfo("invalid superclass kind", name)
+		}
+	}
+	for _, name := range append(append([]string(nil), oc.Must...), oc.May...) {
+		if s.attributeTypes[strings.ToLower(name)] == nil {
+			return ErrInvalidSchemaDefinition.WithInfo("unknown attribute type", name)
+		}
+	}
+	keys, err := schemaKeys(s.objectClasses, oc.OID, oc.Names)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		s.objectClasses[key] = oc
+	}
+	s.objectClassList = append(s.objectClassList, oc)
+	return nil
+}
+
+// Returns the attribute type with the name or OID of the attribute description,
+// or nil if it is not defined. Attribute options such as ";binary" are ignored.
+func (s *Schema) AttributeType(description string) *AttributeType {
+	if i := strings.IndexByte(description, ';'); i >= 0 {
+		description = description[:i]
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.attributeTypes[strings.ToLower(description)]
+}
+
+// Returns the object class with the name or OID, or nil if it is not defined
+func (s *Schema) ObjectClass(nameOrOID string) *ObjectClass {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.objectClasses[strings.ToLower(nameOrOID)]
+}
+
+// Returns the matching rule definition with the name or OID, or nil if it is not defined
+func (s *Schema) MatchingRuleDescription(nameOrOID string) *MatchingRuleDescription {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.matchingRules[strings.ToLower(nameOrOID)]
+}
+
+// Returns the syntax definition with the OID, or nil if it is not defined
+func (s *Schema) LDAPSyntax(oid OID) *LDAPSyntax {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.syntaxes[string(oid)]
+}
+
+// Returns the attribute type definitions in the order they were added
+func (s *Schema) AttributeTypes() []*AttributeType {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return append([]*AttributeType(nil), s.attributeTypeList...)
+}
+
+// Returns the object class definitions in the order they were added
+func (s *Schema) ObjectClasses() []*ObjectClass {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return append([]*ObjectClass(nil), s.objectClassList...)
+}
+
+// Returns the matching rule definitions in the order they were added
+func (s *Schema) MatchingRuleDescriptions() []*MatchingRuleDescription {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return append([]*MatchingRuleDescription(nil), s.matchingRuleList...)
+}
+
+// Returns the syntax definitions in the order they were added
+func (s *Schema) LDAPSyntaxes() []*LDAPSyntax {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return append([]*LDAPSyntax(nil), s.syntaxList...)
+}
+
+// Returns true if the attribute descriptions refer to the same attribute type,
+// e.g. "cn", "commonName" and "2.5.4.3".
+// Descriptions that are not in the schema are compared ignoring case.
+func (s *Schema) SameAttributeType(a string, b string) bool {
+	if strings.EqualFold(a, b) {
+		return true
+	}
+	at := s.AttributeType(a)
+	return at != nil && at == s.AttributeType(b)
+}
+
+// Returns the value of a field of the attribute type,
+// inherited from its supertypes if it is not set
+func (s *Schema) inherited(at *AttributeType, field func(*AttributeType) string) string {
+	for at != nil {
+		if value := field(at); value != "" {
+			return value
+		}
+		if at.Superior == "" {
+			break
+		}
+		at = s.AttributeType(at.Superior)
+	}
+	return ""
+}
+
+// Returns the matching rule resolver used for the implementations of the rules
+func (s *Schema) matchingRuleResolver() MatchingRuleResolver {
+	if s.MatchingRules == nil {
+		return DefaultMatchingRules
+	}
+	return s.MatchingRules
+}
+
+// Returns the implementation of the attribute's matching rule selected by the field,
+// or the fallback rule if the attribute or the rule is unknown
+func (s *Schema) attributeRule(attribute string, field func(*AttributeType) string,
+	fallback func(MatchingRuleResolver, string) *MatchingRule) *MatchingRule {
+	rules := s.matchingRuleResolver()
+	at := s.AttributeType(attribute)
+	if at == nil {
+		return fallback(rules, attribute)
+	}
+	name := s.inherited(at, field)
+	if name == "" {
+		return nil
+	}
+	if rule := rules.MatchingRule(name); rule != nil {
+		return rule
+	}
+	return fallback(rules, attribute)
+}
+
+func (s *Schema) EqualityRule(attribute string) *MatchingRule {
+	return s.attributeRule(attribute, func(at *AttributeType) string { return at.Equality },
+		MatchingRuleResolver.EqualityRule)
+}
+
+func (s *Schema) OrderingRule(attribute string) *MatchingRule {
+	return s.attributeRule(attribute, func(at *AttributeType) string { return at.Ordering },
+		MatchingRuleResolver.OrderingRule)
+}
+
+func (s *Schema) SubstringsRule(attribute string) *MatchingRule {
+	return s.attributeRule(attribute, func(at *AttributeType) string { return at.Substrings },
+		MatchingRuleResolver.SubstringsRule)
+}
+
+func (s *Schema) MatchingRule(nameOrOID string) *MatchingRule {
+	return s.matchingRuleResolver().MatchingRule(nameOrOID)
+}
+
+// DN of the subschema subentry published by the server
+const SubschemaDN = "cn=Subschema"
+
+// Returns the subschema subentry publishing the definitions (RFC 4512 section 4.2)
+func (s *Schema) SubschemaEntry() *Entry {
+	entry := NewEntry(MustParseDN(SubschemaDN),
+		Attribute{Description: "objectClass", Values: []string{"top", "subentry", "subschema", "extensibleObject"}},
+		Attribute{Description: "cn", Values: []string{"Subschema"}},
+	)
+	add := func(description string, values []string) {
+		if len(values) > 0 {
+			entry.Attributes = append(entry.Attributes, Attribute{Description: description, Values: values})
+		}
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var values []string
+	for _, syntax := range s.syntaxList {
+		values = append(values, syntax.String())
+	}
+	add("ldapSyntaxes", values)
+	values = nil
+	for _, mr := range s.matchingRuleList {
+		values = append(values, mr.String())
+	}
+	add("matchingRules", values)
+	values = nil
+	for _, at := range s.attributeTypeList {
+		values = append(values, at.String())
+	}
+	add("attributeTypes", values)
+	values = nil
+	for _, oc := range s.objectClassList {
+		values = append(values, oc.String())
+	}
+	add("objectClasses", values)
+	return entry
+}
+
+// Returns the schema published by the server, or nil if there is none
+func (c *Conn) Schema() *Schema {
+	return c.schema
+}
+
+// Returns true if the Search request reads the subschema subentry of the connection's schema,
+// i.e. it is a base object search of SubschemaDN
+func (c *Conn) IsSubschemaSearch(req *SearchRequest) bool {
+	if c.schema == nil || req.Scope != SearchScopeBaseObject {
+		return false
+	}
+	dn, err := ParseDN(req.BaseObject)
+	return err == nil && normalizeDN(dn).Equal(normalizeDN(MustParseDN(SubschemaDN)))
+}
+
+// Answer a Search request for the subschema subentry of the connection's schema.
+// The server calls this for requests where conn.IsSubschemaSearch is true.
+func SearchSubschema(ctx context.Context, conn *Conn, msg *Message, req *SearchRequest) {
+	entry := conn.Schema().SubschemaEntry()
+	if req.Filter == nil || req.Filter.Match(entry) == FilterTrue {
+		err := conn.SendResult(msg.MessageID, nil, TypeSearchResultEntryOp,
+			entry.operationalSearchResultEntry([]string{"objectClass", "cn"}, req.Attributes, req.TypesOnly))
+		if err != nil {
+			return
+		}
+	}
+	if ctx.Err() != nil {
+		// Abandoned or disconnected
+		return
+	}
+	conn.SendResult(msg.MessageID, nil, TypeSearchResultDoneOp, ResultSuccess.AsResult(""))
+}
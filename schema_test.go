@@ -0,0 +1,269 @@
+package ldapserver_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/merlinz01/ldapserver"
+)
+
+func TestParseSchemaDefinitions(t *testing.T) {
+	at, err := ldapserver.ParseAttributeType("( 1.2.3.4 NAME ( 'exampleName' 'exName' ) DESC 'It\\27s an example' " +
+		"SUP name EQUALITY caseExactMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.15{64} SINGLE-VALUE X-ORIGIN ( 'test' 'example' ) )")
+	if err != nil {
+		t.Fatal("Error parsing attribute type:", err)
+	}
+	if at.OID != "1.2.3.4" || !slicesEqual(at.Names, []string{"exampleName", "exName"}) || at.Description != "It's an example" ||
+		at.Superior != "name" || at.Equality != "caseExactMatch" || at.Syntax != "1.3.6.1.4.1.1466.115.121.1.15" ||
+		at.SyntaxLength != 64 || !at.SingleValue || at.Usage != ldapserver.UsageUserApplications ||
+		!slicesEqual(at.Extensions["X-ORIGIN"], []string{"test", "example"}) {
+		t.Fatalf("wrong attribute type %+v", at)
+	}
+	if at.String() != "( 1.2.3.4 NAME ( 'exampleName' 'exName' ) DESC 'It\\27s an example' SUP name EQUALITY caseExactMatch "+
+		"SYNTAX 1.3.6.1.4.1.1466.115.121.1.15{64} SINGLE-VALUE X-ORIGIN ( 'test' 'example' ) )" {
+		t.Fatal("wrong attribute type string", at.String())
+	}
+	oc, err := ldapserver.ParseObjectClass("( 1.2.3.5 NAME 'example' SUP ( top $ person ) AUXILIARY MUST ( cn $ sn ) MAY description )")
+	if err != nil {
+		t.Fatal("Error parsing object class:", err)
+	}
+	if oc.Name() != "example" || oc.Kind != ldapserver.ObjectClassAuxiliary || !slicesEqual(oc.Superiors, []string{"top", "person"}) ||
+		!slicesEqual(oc.Must, []string{"cn", "sn"}) || !slicesEqual(oc.May, []string{"description"}) {
+		t.Fatalf("wrong object class %+v", oc)
+	}
+	if oc.String() != "( 1.2.3.5 NAME 'example' SUP ( top $ person ) AUXILIARY MUST ( cn $ sn ) MAY description )" {
+		t.Fatal("wrong object class string", oc.String())
+	}
+	for _, def := range []string{
+		"",
+		"1.2.3 NAME 'x' )",
+		"( 1.2.3 NAME 'x'",
+		"( x NAME 'x' SYNTAX 1.2 )",
+		"( 1.2.3 NAME 'x )",
+		"( 1.2.3 NAME 'x' NAME 'y' SYNTAX 1.2 )",
+		"( 1.2.3 NAME 'x' )",
+		"( 1.2.3 NAME ( 'x' SYNTAX 1.2 )",
+		"( 1.2.3 NAME 'x' SYNTAX 1.2 USAGE unknown )",
+		"( 1.2.3 NAME 'x' SYNTAX 1.2 MUST cn )",
+		"( 1.2.3 NAME 'x' SYNTAX 1.2{x} )",
+		"( 1.2.3 NAME 'x' SYNTAX 1.2 NO-USER-MODIFICATION )",
+	} {
+		if _, err := ldapserver.ParseAttributeType(def); !errors.Is(err, ldapserver.ErrInvalidSchemaDefinition) {
+			t.Fatalf("wrong error for %q: %v", def, err)
+		}
+	}
+	if _, err := ldapserver.ParseObjectClass("( 1.2.3 NAME 'x' ABSTRACT AUXILIARY )"); !errors.Is(err, ldapserver.ErrInvalidSchemaDefinition) {
+		t.Fatal("wrong error for multiple kinds", err)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	schema := ldapserver.NewDefaultSchema()
+	for _, name := range []string{"cn", "commonName", "CN", "2.5.4.3", "cn;lang-en"} {
+		if at := schema.AttributeType(name); at == nil || at.OID != "2.5.4.3" {
+			t.Fatal("wrong attribute type for", name)
+		}
+	}
+	if schema.ObjectClass("INETORGPERSON") == nil || schema.ObjectClass("2.5.6.6").Name() != "person" {
+		t.Fatal("object classes not resolved")
+	}
+	if !schema.SameAttributeType("surname", "2.5.4.4") || schema.SameAttributeType("sn", "cn") {
+		t.Fatal("wrong attribute type comparison")
+	}
+	// Rules inherited from the supertype
+	if schema.EqualityRule("commonName") != ldapserver.CaseIgnoreMatch || schema.OrderingRule("cn") != nil {
+		t.Fatal("wrong matching rules for cn")
+	}
+	if schema.EqualityRule("userPassword") != ldapserver.OctetStringMatch {
+		t.Fatal("wrong matching rule for userPassword")
+	}
+	err := schema.Load(&ldapserver.SchemaDefinitions{
+		AttributeTypes: []string{"( 1.2.3.4 NAME 'example' SUP noSuchAttribute )"},
+	})
+	if !errors.Is(err, ldapserver.ErrInvalidSchemaDefinition) {
+		t.Fatal("wrong error for unknown supertype", err)
+	}
+	err = schema.Load(&ldapserver.SchemaDefinitions{
+		ObjectClasses: []string{"( 1.2.3.4 NAME 'person' SUP top STRUCTURAL )"},
+	})
+	if !errors.Is(err, ldapserver.ErrInvalidSchemaDefinition) {
+		t.Fatal("wrong error for duplicate name", err)
+	}
+
+	entry := ldapserver.NewEntry(ldapserver.MustParseDN("cn=John Doe,dc=example,dc=com"),
+		ldapserver.Attribute{Description: "objectClass", Values: []string{"person"}},
+		ldapserver.Attribute{Description: "commonName", Values: []string{"John Doe"}},
+		ldapserver.Attribute{Description: "sn", Values: []string{"Doe"}},
+	)
+	for filter, expected := range map[string]ldapserver.FilterResult{
+		"(cn=john doe)":             ldapserver.FilterTrue,
+		"(2.5.4.3=JOHN*)":           ldapserver.FilterTrue,
+		"(surname=*)":               ldapserver.FilterTrue,
+		"(cn>=a)":                   ldapserver.FilterUndefined,
+		"(surname:dn:=example)":     ldapserver.FilterFalse,
+		"(domainComponent:dn:=com)": ldapserver.FilterTrue,
+	} {
+		if res := ldapserver.MustParseFilter(filter).MatchWith(entry, schema); res != expected {
+			t.Fatalf("%s.MatchWith() = %v, want %v", filter, res, expected)
+		}
+	}
+}
+
+func TestSubschema(t *testing.T) {
+	// The server answers for handlers that do not serve the subschema subentry themselves
+	for _, handler := range []ldapserver.Handler{newTestDirectory(t), &ldapserver.BaseHandler{}} {
+		server := ldapserver.NewLDAPServer(handler)
+		server.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+		server.Schema = ldapserver.NewDefaultSchema()
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal("Error listening:", err)
+		}
+		go server.Serve(listener)
+		defer server.Shutdown(context.Background())
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal("Error dialing:", err)
+		}
+		defer conn.Close()
+		search := func(id ldapserver.MessageID, base string, attributes ...string) *ldapserver.SearchResultEntry {
+			t.Helper()
+			responses := doRequest(t, conn, id, ldapserver.TypeSearchRequestOp, (&ldapserver.SearchRequest{
+				BaseObject: base, Scope: ldapserver.SearchScopeBaseObject,
+				Filter: ldapserver.MustParseFilter("(objectClass=subschema)"), Attributes: attributes,
+			}).Encode())
+			if len(responses) != 2 {
+				t.Fatal("wrong number of responses", len(responses))
+			}
+			entry, err := ldapserver.GetSearchResultEntry(responses[0].ProtocolOp.Data)
+			if err != nil {
+				t.Fatal("Error parsing entry:", err)
+			}
+			return entry
+		}
+
+		rootDSE := doRequest(t, conn, 1, ldapserver.TypeSearchRequestOp, (&ldapserver.SearchRequest{
+			Scope: ldapserver.SearchScopeBaseObject, Attributes: []string{"subschemaSubentry"},
+		}).Encode())
+		entry, err := ldapserver.GetSearchResultEntry(rootDSE[0].ProtocolOp.Data)
+		if err != nil {
+			t.Fatal("Error parsing entry:", err)
+		}
+		if len(entry.Attributes) != 1 || !slicesEqual(entry.Attributes[0].Values, []string{ldapserver.SubschemaDN}) {
+			t.Fatal("wrong subschemaSubentry", entry.Attributes)
+		}
+		entry = search(2, "CN=subschema", "attributeTypes", "objectClasses")
+		if entry.ObjectName != ldapserver.SubschemaDN || len(entry.Attributes) != 2 {
+			t.Fatal("wrong subschema entry", entry.ObjectName, len(entry.Attributes))
+		}
+		attributeTypes := entry.Attributes[0].Values
+		if entry.Attributes[0].Description != "attributeTypes" || len(attributeTypes) != len(server.Schema.AttributeTypes()) {
+			t.Fatal("wrong attribute types", entry.Attributes[0].Description, len(attributeTypes))
+		}
+		for _, v := range attributeTypes {
+			if _, err := ldapserver.ParseAttributeType(v); err != nil {
+				t.Fatal("Error parsing published attribute type:", err)
+			}
+		}
+		entry = search(3, ldapserver.SubschemaDN)
+		if len(entry.Attributes) != 2 {
+			t.Fatal("operational attributes returned by default", len(entry.Attributes))
+		}
+	}
+}
+
+func TestSchemaValidation(t *testing.T) {
+	schema := ldapserver.NewDefaultSchema()
+	attrs := func(pairs ...string) []ldapserver.Attribute {
+		var attributes []ldapserver.Attribute
+		for i := 0; i < len(pairs); i += 2 {
+			attributes = append(attributes, ldapserver.Attribute{Description: pairs[i], Values: strings.Split(pairs[i+1], "|")})
+		}
+		return attributes
+	}
+	for _, test := range []struct {
+		attributes []ldapserver.Attribute
+		expected   ldapserver.LDAPResultCode
+	}{
+		{attrs("objectClass", "inetOrgPerson", "cn", "John Doe", "sn", "Doe", "mail", "john@example.com"), ldapserver.ResultSuccess},
+		{attrs("objectClass", "person|organizationalPerson|inetOrgPerson", "commonName", "John Doe", "surname", "Doe"), ldapserver.ResultSuccess},
+		{attrs("objectClass", "person", "cn", "John Doe", "sn", "Doe", "mail", "john@example.com"), ldapserver.ResultObjectClassViolation},
+		{attrs("objectClass", "person|extensibleObject", "cn", "John Doe", "sn", "Doe", "mail", "john@example.com"), ldapserver.ResultSuccess},
+		{attrs("objectClass", "person", "cn", "John Doe"), ldapserver.ResultObjectClassViolation},
+		{attrs("objectClass", "noSuchClass", "cn", "John Doe"), ldapserver.ResultObjectClassViolation},
+		{attrs("objectClass", "person|organization", "cn", "John Doe", "sn", "Doe", "o", "Example"), ldapserver.ResultObjectClassViolation},
+		{attrs("objectClass", "top", "cn", "John Doe"), ldapserver.ResultObjectClassViolation},
+		{attrs("objectClass", "person", "cn", "John Doe", "sn", "Doe", "noSuchAttribute", "x"), ldapserver.ResultUndefinedAttributeType},
+		{attrs("objectClass", "inetOrgPerson", "cn", "John Doe", "sn", "Doe", "displayName", "John|Johnny"), ldapserver.ResultConstraintViolation},
+		{attrs("objectClass", "inetOrgPerson", "cn", "John Doe", "sn", "Doe", "manager", "not a DN"), ldapserver.ResultInvalidAttributeSyntax},
+		{attrs("objectClass", "person", "cn", "John Doe", "sn", "Doe", "telephoneNumber", "+1 555 0100"), ldapserver.ResultSuccess},
+		{attrs("objectClass", "person", "cn", "John Doe", "sn", "Doe", "telephoneNumber", "call me"), ldapserver.ResultSuccess},
+		{attrs("objectClass", "person", "cn", "John Doe", "sn", "Doe", "telephoneNumber", "555*0100"), ldapserver.ResultInvalidAttributeSyntax},
+	} {
+		res := schema.ValidateAddRequest(&ldapserver.AddRequest{Entry: "cn=John Doe,dc=example,dc=com", Attributes: test.attributes})
+		code := ldapserver.ResultSuccess
+		if res != nil {
+			code = res.ResultCode
+		}
+		if code != test.expected {
+			t.Fatalf("ValidateAddRequest(%v) = %v, want %v", test.attributes, res, test.expected)
+		}
+	}
+	res := schema.ValidateAddRequest(&ldapserver.AddRequest{
+		Entry:      "cn=John Doe,dc=example,dc=com",
+		Attributes: attrs("objectClass", "person", "cn", "John Doe", "sn", "Doe", "createTimestamp", "20240101000000Z"),
+	})
+	if res == nil || res.ResultCode != ldapserver.ResultConstraintViolation {
+		t.Fatal("wrong result for NO-USER-MODIFICATION attribute", res)
+	}
+
+	dir := ldapserver.NewMemoryHandler()
+	dir.Schema = schema
+	err := dir.AddEntry(ldapserver.NewEntry(ldapserver.MustParseDN("dc=example,dc=com"),
+		attrs("objectClass", "domain", "dc", "example")...))
+	if err != nil {
+		t.Fatal("Error adding entry:", err)
+	}
+	err = dir.AddEntry(ldapserver.NewEntry(ldapserver.MustParseDN("cn=John Doe,dc=example,dc=com"),
+		attrs("objectClass", "person", "cn", "John Doe", "sn", "Doe", "createTimestamp", "20240101000000Z")...))
+	if err != nil {
+		t.Fatal("Error adding entry with operational attribute:", err)
+	}
+	err = dir.AddEntry(ldapserver.NewEntry(ldapserver.MustParseDN("cn=Jane Doe,dc=example,dc=com"),
+		attrs("objectClass", "person", "cn", "Jane Doe")...))
+	var result *ldapserver.Result
+	if !errors.As(err, &result) || result.ResultCode != ldapserver.ResultObjectClassViolation {
+		t.Fatal("wrong error for invalid entry", err)
+	}
+	entry := dir.GetEntry(ldapserver.MustParseDN("cn=John Doe,dc=example,dc=com"))
+	for _, test := range []struct {
+		changes  []ldapserver.ModifyChange
+		expected ldapserver.LDAPResultCode
+	}{
+		{[]ldapserver.ModifyChange{{Operation: ldapserver.ModifyReplace, Modification: attrs("description", "Example")[0]}}, ldapserver.ResultSuccess},
+		{[]ldapserver.ModifyChange{{Operation: ldapserver.ModifyDelete, Modification: ldapserver.Attribute{Description: "sn"}}}, ldapserver.ResultObjectClassViolation},
+		{[]ldapserver.ModifyChange{{Operation: ldapserver.ModifyAdd, Modification: attrs("noSuchAttribute", "x")[0]}}, ldapserver.ResultUndefinedAttributeType},
+		{[]ldapserver.ModifyChange{{Operation: ldapserver.ModifyReplace, Modification: attrs("modifyTimestamp", "20240101000000Z")[0]}}, ldapserver.ResultConstraintViolation},
+		{[]ldapserver.ModifyChange{{Operation: ldapserver.ModifyAdd, Modification: attrs("seeAlso", "not a DN")[0]}}, ldapserver.ResultInvalidAttributeSyntax},
+		{[]ldapserver.ModifyChange{{Operation: ldapserver.ModifyAdd, Modification: attrs("objectClass", "organizationalPerson")[0]}}, ldapserver.ResultObjectClassModsProhibited},
+		{[]ldapserver.ModifyChange{
+			{Operation: ldapserver.ModifyAdd, Modification: attrs("objectClass", "inetOrgPerson")[0]},
+			{Operation: ldapserver.ModifyAdd, Modification: attrs("mail", "john@example.com")[0]},
+		}, ldapserver.ResultObjectClassModsProhibited},
+	} {
+		req := &ldapserver.ModifyRequest{Object: "cn=John Doe,dc=example,dc=com", Changes: test.changes}
+		res := schema.ValidateModifyRequest(req, entry)
+		code := ldapserver.ResultSuccess
+		if res != nil {
+			code = res.ResultCode
+		}
+		if code != test.expected {
+			t.Fatalf("ValidateModifyRequest(%v) = %v, want %v", test.changes, res, test.expected)
+		}
+	}
+}
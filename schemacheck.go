@@ -0,0 +1,295 @@
+package ldapserver
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validators for the values of the syntaxes of RFC 4517 section 3.3,
+// used for the syntaxes added without a Validate function.
+// Syntaxes not listed here accept any value.
+var syntaxValidators = map[OID]func(value string) bool{
+	"1.3.6.1.4.1.1466.115.121.1.6":  validBitString,
+	"1.3.6.1.4.1.1466.115.121.1.7":  validBoolean,
+	"1.3.6.1.4.1.1466.115.121.1.11": validCountryString,
+	"1.3.6.1.4.1.1466.115.121.1.12": validDN,
+	"1.3.6.1.4.1.1466.115.121.1.15": validDirectoryString,
+	"1.3.6.1.4.1.1466.115.121.1.24": validGeneralizedTime,
+	"1.3.6.1.4.1.1466.115.121.1.26": validIA5String,
+	"1.3.6.1.4.1.1466.115.121.1.27": validInteger,
+	"1.3.6.1.4.1.1466.115.121.1.36": validNumericString,
+	"1.3.6.1.4.1.1466.115.121.1.38": validOIDValue,
+	"1.3.6.1.4.1.1466.115.121.1.41": validPostalAddress,
+	"1.3.6.1.4.1.1466.115.121.1.44": validPrintableString,
+	"1.3.6.1.4.1.1466.115.121.1.50": validPrintableString,
+}
+
+var (
+	bitStringPattern       = regexp.MustCompile(`^'[01]*'B$`)
+	integerPattern         = regexp.MustCompile(`^-?(0|[1-9][0-9]*)$`)
+	numericStringPattern   = regexp.MustCompile(`^[0-9 ]+$`)
+	descrPattern           = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*$`)
+	printableStringPattern = regexp.MustCompile(`^[A-Za-z0-9'()+,\-./:?= ]+$`)
+)
+
+func validBitString(value string) bool {
+	return bitStringPattern.MatchString(value)
+}
+
+func validBoolean(value string) bool {
+	return value == "TRUE" || value == "FALSE"
+}
+
+func validCountryString(value string) bool {
+	return len(value) == 2 && validPrintableString(value)
+}
+
+func validDN(value string) bool {
+	_, err := ParseDN(value)
+	return err == nil
+}
+
+func validDirectoryString(value string) bool {
+	return value != "" && utf8.ValidString(value)
+}
+
+func validGeneralizedTime(value string) bool {
+	_, err := parseGeneralizedTime(value)
+	return err == nil
+}
+
+func validIA5String(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if value[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+func validInteger(value string) bool {
+	return integerPattern.MatchString(value)
+}
+
+func validNumericString(value string) bool {
+	return numericStringPattern.MatchString(value)
+}
+
+// Numeric OID or short name
+func validOIDValue(value string) bool {
+	return OID(value).Validate() == nil || descrPattern.MatchString(value)
+}
+
+// Lines separated by "$", none of them empty
+func validPostalAddress(value string) bool {
+	if !utf8.ValidString(value) {
+		return false
+	}
+	for _, line := range strings.Split(value, "$") {
+		if line == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func validPrintableString(value string) bool {
+	return printableStringPattern.MatchString(value)
+}
+
+// Returns true if the value conforms to the syntax of the attribute type,
+// inherited from its supertypes if it is not set
+func (s *Schema) validValue(at *AttributeType, value string) bool {
+	oid := s.inherited(at, func(at *AttributeType) string { return string(at.Syntax) })
+	if oid == "" {
+		return true
+	}
+	syntax := s.LDAPSyntax(OID(oid))
+	return syntax == nil || syntax.Validate == nil || syntax.Validate(value)
+}
+
+// Returns the result for the first value that does not conform to the attribute's syntax, or nil
+func (s *Schema) checkValues(at *AttributeType, description string, values []string) *Result {
+	for _, v := range values {
+		if !s.validValue(at, v) {
+			return ResultInvalidAttributeSyntax.AsResult("invalid value for attribute " + description)
+		}
+	}
+	return nil
+}
+
+// Adds the object class and its superclasses to the set
+func (s *Schema) addObjectClassChain(classes map[*ObjectClass]bool, oc *ObjectClass) {
+	if classes[oc] {
+		return
+	}
+	classes[oc] = true
+	for _, name := range oc.Superiors {
+		if sup := s.ObjectClass(name); sup != nil {
+			s.addObjectClassChain(classes, sup)
+		}
+	}
+}
+
+// Returns the structural object class of the entry, i.e. the most specific
+// of its structural object classes, which must be a subclass of all the others.
+func (s *Schema) structuralObjectClass(entry *Entry) (*ObjectClass, *Result) {
+	values := attributeValues(entry, "objectClass", s)
+	if len(values) == 0 {
+		return nil, ResultObjectClassViolation.AsResult("the entry has no object class")
+	}
+	var structural []*ObjectClass
+	for _, v := range values {
+		oc := s.ObjectClass(v)
+		if oc == nil {
+			return nil, ResultObjectClassViolation.AsResult("unknown object class " + v)
+		}
+		if oc.Kind == ObjectClassStructural {
+			structural = append(structural, oc)
+		}
+	}
+	for _, candidate := range structural {
+		chain := make(map[*ObjectClass]bool)
+		s.addObjectClassChain(chain, candidate)
+		found := true
+		for _, oc := range structural {
+			if !chain[oc] {
+				found = false
+				break
+			}
+		}
+		if found {
+			return candidate, nil
+		}
+	}
+	if len(structural) == 0 {
+		return nil, ResultObjectClassViolation.AsResult("the entry has no structural object class")
+	}
+	return nil, ResultObjectClassViolation.AsResult("the entry has more than one structural object class chain")
+}
+
+// Check an entry against the schema:
+// its attribute types must be defined and its values must conform to their syntaxes,
+// single-valued attributes must not have more than one value,
+// its object classes must be defined with a single chain of structural classes,
+// and it must have the attributes required by its object classes and no others
+// unless it is an extensibleObject. Operational attributes are always allowed.
+//
+// Returns nil if the entry is valid, or the result to send otherwise.
+func (s *Schema) ValidateEntry(entry *Entry) *Result {
+	// Group the values by attribute type, merging aliases such as cn and commonName
+	values := make(map[*AttributeType][]string)
+	var types []*AttributeType
+	for _, attr := range entry.Attributes {
+		at := s.AttributeType(attr.Description)
+		if at == nil {
+			return ResultUndefinedAttributeType.AsResult("undefined attribute type " + attr.Description)
+		}
+		if res := s.checkValues(at, attr.Description, attr.Values); res != nil {
+			return res
+		}
+		if _, ok := values[at]; !ok {
+			types = append(types, at)
+		}
+		values[at] = append(values[at], attr.Values...)
+	}
+	for _, at := range types {
+		if at.SingleValue && len(values[at]) > 1 {
+			return ResultConstraintViolation.AsResult("attribute " + at.Name() + " is single-valued")
+		}
+	}
+	if _, res := s.structuralObjectClass(entry); res != nil {
+		return res
+	}
+	classes := make(map[*ObjectClass]bool)
+	for _, v := range attributeValues(entry, "objectClass", s) {
+		s.addObjectClassChain(classes, s.ObjectClass(v))
+	}
+	allowed := make(map[*AttributeType]bool)
+	extensible := false
+	for oc := range classes {
+		if oc.OID == OIDExtensibleObject {
+			extensible = true
+		}
+		for _, name := range oc.Must {
+			at := s.AttributeType(name)
+			if len(values[at]) == 0 {
+				return ResultObjectClassViolation.AsResult("missing attribute " + name + " required by object class " + oc.Name())
+			}
+			allowed[at] = true
+		}
+		for _, name := range oc.May {
+			allowed[s.AttributeType(name)] = true
+		}
+	}
+	if !extensible {
+		for _, at := range types {
+			if !at.IsOperational() && !allowed[at] {
+				return ResultObjectClassViolation.AsResult("attribute " + at.Name() + " is not allowed by the object classes")
+			}
+		}
+	}
+	return nil
+}
+
+// Check an Add request against the schema: the entry must be valid
+// and must not have attributes that users cannot modify, e.g. createTimestamp.
+//
+// Returns nil if the request is valid, or the result to send otherwise.
+func (s *Schema) ValidateAddRequest(req *AddRequest) *Result {
+	dn, err := ParseDN(req.Entry)
+	if err != nil {
+		return ResultInvalidDNSyntax.AsResult("the provided DN is invalid")
+	}
+	for _, attr := range req.Attributes {
+		if at := s.AttributeType(attr.Description); at != nil && at.NoUserModification {
+			return ResultConstraintViolation.AsResult("attribute " + attr.Description + " cannot be modified")
+		}
+	}
+	return s.ValidateEntry(NewEntry(dn, req.Attributes...))
+}
+
+// Check a Modify request against the schema and the entry it modifies.
+// The modified attribute types must be defined, users must be able to modify them,
+// and the added values must conform to their syntaxes.
+// If entry is not nil, the changes are applied to a copy of it
+// which must be valid and keep the same structural object class.
+//
+// Returns nil if the request is valid, or the result to send otherwise,
+// which may be the result of applying a change that cannot be applied.
+func (s *Schema) ValidateModifyRequest(req *ModifyRequest, entry *Entry) *Result {
+	for _, change := range req.Changes {
+		mod := &change.Modification
+		at := s.AttributeType(mod.Description)
+		if at == nil {
+			return ResultUndefinedAttributeType.AsResult("undefined attribute type " + mod.Description)
+		}
+		if at.NoUserModification {
+			return ResultConstraintViolation.AsResult("attribute " + mod.Description + " cannot be modified")
+		}
+		if change.Operation != ModifyDelete {
+			if res := s.checkValues(at, mod.Description, mod.Values); res != nil {
+				return res
+			}
+		}
+	}
+	if entry == nil {
+		return nil
+	}
+	modified := entry.Clone()
+	for _, change := range req.Changes {
+		if res := applyModifyChange(modified, &change); res != nil {
+			return res
+		}
+	}
+	if res := s.ValidateEntry(modified); res != nil {
+		return res
+	}
+	before, res := s.structuralObjectClass(entry)
+	after, _ := s.structuralObjectClass(modified)
+	if res == nil && before != after {
+		return ResultObjectClassModsProhibited.AsResult("the structural object class cannot be changed")
+	}
+	return nil
+}
@@ -0,0 +1,378 @@
+package ldapserver
+
+import (
+	"strconv"
+	"strings"
+)
+
+// A token of a schema definition
+type schemaToken struct {
+	value string
+	// Whether the token is a quoted string
+	quoted bool
+}
+
+// Splits a RFC 4512 definition into parentheses, dollar signs,
+// quoted strings and bare words.
+func tokenizeSchemaDefinition(def string) ([]schemaToken, error) {
+	var tokens []schemaToken
+	for i := 0; i < len(def); {
+		switch c := def[i]; c {
+		case ' ', '\t', '\r', '\n':
+			i++
+		case '(', ')', '$':
+			tokens = append(tokens, schemaToken{value: string(c)})
+			i++
+		case '\'':
+			end := strings.IndexByte(def[i+1:], '\'')
+			if end < 0 {
+				return nil, ErrInvalidSchemaDefinition.WithInfo("unterminated string at position", i)
+			}
+			s, err := unescapeQDString(def[i+1 : i+1+end])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, schemaToken{value: s, quoted: true})
+			i += end + 2
+		default:
+			end := strings.IndexAny(def[i:], " \t\r\n()$'")
+			if end < 0 {
+				end = len(def) - i
+			}
+			tokens = append(tokens, schemaToken{value: def[i : i+end]})
+			i += end
+		}
+	}
+	return tokens, nil
+}
+
+// Decodes the \27 and \5C escapes of a qdstring
+func unescapeQDString(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", ErrInvalidSchemaDefinition.WithInfo("invalid escape in string", s)
+		}
+		switch strings.ToUpper(s[i+1 : i+3]) {
+		case "27":
+			b.WriteByte('\'')
+		case "5C":
+			b.WriteByte('\\')
+		default:
+			return "", ErrInvalidSchemaDefinition.WithInfo("invalid escape in string", s)
+		}
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// Encodes a string as a qdstring
+func escapeQDString(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\5C")
+	s = strings.ReplaceAll(s, "'", "\\27")
+	return "'" + s + "'"
+}
+
+// Fields of a schema definition, keyed by keyword
+type schemaFields map[string][]string
+
+// Keywords without a value
+var schemaFlags = map[string]bool{
+	"OBSOLETE":             true,
+	"SINGLE-VALUE":         true,
+	"COLLECTIVE":           true,
+	"NO-USER-MODIFICATION": true,
+	"ABSTRACT":             true,
+	"STRUCTURAL":           true,
+	"AUXILIARY":            true,
+}
+
+// Parses a RFC 4512 definition of the form ( numericoid *( keyword [value] ) )
+// into its OID and fields.
+// Values are either a single word or quoted string,
+// or a parenthesized list of them, optionally separated by dollar signs.
+func parseSchemaDefinition(def string) (OID, schemaFields, error) {
+	tokens, err := tokenizeSchemaDefinition(def)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(tokens) < 3 || tokens[0].value != "(" || tokens[0].quoted {
+		return "", nil, ErrInvalidSchemaDefinition.WithInfo("expected", "(")
+	}
+	last := tokens[len(tokens)-1]
+	if last.value != ")" || last.quoted {
+		return "", nil, ErrInvalidSchemaDefinition.WithInfo("expected", ")")
+	}
+	oid := OID(tokens[1].value)
+	if tokens[1].quoted || oid.Validate() != nil {
+		return "", nil, ErrInvalidSchemaDefinition.WithInfo("invalid OID", tokens[1].value)
+	}
+	fields := make(schemaFields)
+	tokens = tokens[2 : len(tokens)-1]
+	for len(tokens) > 0 {
+		keyword := tokens[0]
+		tokens = tokens[1:]
+		if keyword.quoted || keyword.value == "(" || keyword.value == ")" || keyword.value == "$" {
+			return "", nil, ErrInvalidSchemaDefinition.WithInfo("expected keyword, got", keyword.value)
+		}
+		if _, ok := fields[keyword.value]; ok {
+			return "", nil, ErrInvalidSchemaDefinition.WithInfo("duplicate keyword", keyword.value)
+		}
+		if schemaFlags[keyword.value] {
+			fields[keyword.value] = nil
+			continue
+		}
+		if len(tokens) == 0 {
+			return "", nil, ErrInvalidSchemaDefinition.WithInfo("missing value for", keyword.value)
+		}
+		var values []string
+		if tokens[0].value == "(" && !tokens[0].quoted {
+			i := 1
+			for ; i < len(tokens) && (tokens[i].value != ")" || tokens[i].quoted); i++ {
+				if tokens[i].value == "(" && !tokens[i].quoted {
+					return "", nil, ErrInvalidSchemaDefinition.WithInfo("nested list in", keyword.value)
+				}
+				if tokens[i].value != "$" || tokens[i].quoted {
+					values = append(values, tokens[i].value)
+				}
+			}
+			if i == len(tokens) {
+				return "", nil, ErrInvalidSchemaDefinition.WithInfo("unterminated list in", keyword.value)
+			}
+			tokens = tokens[i+1:]
+		} else {
+			if tokens[0].value == ")" || tokens[0].value == "$" {
+				return "", nil, ErrInvalidSchemaDefinition.WithInfo("missing value for", keyword.value)
+			}
+			values = []string{tokens[0].value}
+			tokens = tokens[1:]
+		}
+		fields[keyword.value] = values
+	}
+	return oid, fields, nil
+}
+
+// Returns an error if the definition has keywords other than the allowed ones
+// and extensions
+func (f schemaFields) check(allowed ...string) error {
+	for keyword := range f {
+		if strings.HasPrefix(keyword, "X-") {
+			continue
+		}
+		found := false
+		for _, a := range allowed {
+			if keyword == a {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrInvalidSchemaDefinition.WithInfo("unexpected keyword", keyword)
+		}
+	}
+	return nil
+}
+
+// Returns whether the flag is present
+func (f schemaFields) flag(keyword string) bool {
+	_, ok := f[keyword]
+	return ok
+}
+
+// Returns the single value of the keyword, or "" if it is not present
+func (f schemaFields) single(keyword string) (string, error) {
+	values := f[keyword]
+	if len(values) > 1 {
+		return "", ErrInvalidSchemaDefinition.WithInfo("multiple values for", keyword)
+	}
+	if len(values) == 0 {
+		return "", nil
+	}
+	return values[0], nil
+}
+
+// Returns the extensions of the definition
+func (f schemaFields) extensions() map[string][]string {
+	var ext map[string][]string
+	for keyword, values := range f {
+		if strings.HasPrefix(keyword, "X-") {
+			if ext == nil {
+				ext = make(map[string][]string)
+			}
+			ext[keyword] = values
+		}
+	}
+	return ext
+}
+
+// Parses a RFC 4512 AttributeTypeDescription.
+func ParseAttributeType(def string) (*AttributeType, error) {
+	oid, f, err := parseSchemaDefinition(def)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.check("NAME", "DESC", "OBSOLETE", "SUP", "EQUALITY", "ORDERING", "SUBSTR", "SYNTAX",
+		"SINGLE-VALUE", "COLLECTIVE", "NO-USER-MODIFICATION", "USAGE"); err != nil {
+		return nil, err
+	}
+	at := &AttributeType{
+		OID:                oid,
+		Names:              f["NAME"],
+		Obsolete:           f.flag("OBSOLETE"),
+		SingleValue:        f.flag("SINGLE-VALUE"),
+		Collective:         f.flag("COLLECTIVE"),
+		NoUserModification: f.flag("NO-USER-MODIFICATION"),
+		Extensions:         f.extensions(),
+	}
+	for _, field := range []struct {
+		keyword string
+		value   *string
+	}{
+		{"DESC", &at.Description}, {"SUP", &at.Superior}, {"EQUALITY", &at.Equality},
+		{"ORDERING", &at.Ordering}, {"SUBSTR", &at.Substrings},
+	} {
+		if *field.value, err = f.single(field.keyword); err != nil {
+			return nil, err
+		}
+	}
+	syntax, err := f.single("SYNTAX")
+	if err != nil {
+		return nil, err
+	}
+	if i := strings.IndexByte(syntax, '{'); i >= 0 {
+		// noidlen = numericoid [ LCURLY len RCURLY ]
+		if !strings.HasSuffix(syntax, "}") {
+			return nil, ErrInvalidSchemaDefinition.WithInfo("invalid syntax length", syntax)
+		}
+		at.SyntaxLength, err = strconv.Atoi(syntax[i+1 : len(syntax)-1])
+		if err != nil || at.SyntaxLength < 0 {
+			return nil, ErrInvalidSchemaDefinition.WithInfo("invalid syntax length", syntax)
+		}
+		syntax = syntax[:i]
+	}
+	at.Syntax = OID(syntax)
+	if at.Syntax != "" && at.Syntax.Validate() != nil {
+		return nil, ErrInvalidSchemaDefinition.WithInfo("invalid syntax OID", syntax)
+	}
+	usage, err := f.single("USAGE")
+	if err != nil {
+		return nil, err
+	}
+	switch usage {
+	case "", "userApplications":
+		at.Usage = UsageUserApplications
+	case "directoryOperation":
+		at.Usage = UsageDirectoryOperation
+	case "distributedOperation":
+		at.Usage = UsageDistributedOperation
+	case "dSAOperation":
+		at.Usage = UsageDSAOperation
+	default:
+		return nil, ErrInvalidSchemaDefinition.WithInfo("invalid usage", usage)
+	}
+	if at.Superior == "" && at.Syntax == "" {
+		return nil, ErrInvalidSchemaDefinition.WithInfo("attribute type has neither SUP nor SYNTAX", oid)
+	}
+	if at.Collective && at.Usage != UsageUserApplications {
+		return nil, ErrInvalidSchemaDefinition.WithInfo("collective attribute type must have user application usage", oid)
+	}
+	if at.NoUserModification && at.Usage == UsageUserApplications {
+		return nil, ErrInvalidSchemaDefinition.WithInfo("user application attribute type cannot be NO-USER-MODIFICATION", oid)
+	}
+	return at, nil
+}
+
+// Parses a RFC 4512 ObjectClassDescription.
+func ParseObjectClass(def string) (*ObjectClass, error) {
+	oid, f, err := parseSchemaDefinition(def)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.check("NAME", "DESC", "OBSOLETE", "SUP", "ABSTRACT", "STRUCTURAL", "AUXILIARY", "MUST", "MAY"); err != nil {
+		return nil, err
+	}
+	oc := &ObjectClass{
+		OID:        oid,
+		Names:      f["NAME"],
+		Obsolete:   f.flag("OBSOLETE"),
+		Superiors:  f["SUP"],
+		Kind:       ObjectClassStructural,
+		Must:       f["MUST"],
+		May:        f["MAY"],
+		Extensions: f.extensions(),
+	}
+	if oc.Description, err = f.single("DESC"); err != nil {
+		return nil, err
+	}
+	kinds := 0
+	if f.flag("ABSTRACT") {
+		oc.Kind = ObjectClassAbstract
+		kinds++
+	}
+	if f.flag("STRUCTURAL") {
+		kinds++
+	}
+	if f.flag("AUXILIARY") {
+		oc.Kind = ObjectClassAuxiliary
+		kinds++
+	}
+	if kinds > 1 {
+		return nil, ErrInvalidSchemaDefinition.WithInfo("multiple object class kinds", oid)
+	}
+	return oc, nil
+}
+
+// Parses a RFC 4512 MatchingRuleDescription.
+func ParseMatchingRuleDescription(def string) (*MatchingRuleDescription, error) {
+	oid, f, err := parseSchemaDefinition(def)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.check("NAME", "DESC", "OBSOLETE", "SYNTAX"); err != nil {
+		return nil, err
+	}
+	mr := &MatchingRuleDescription{
+		OID:        oid,
+		Names:      f["NAME"],
+		Obsolete:   f.flag("OBSOLETE"),
+		Extensions: f.extensions(),
+	}
+	if mr.Description, err = f.single("DESC"); err != nil {
+		return nil, err
+	}
+	syntax, err := f.single("SYNTAX")
+	if err != nil {
+		return nil, err
+	}
+	mr.Syntax = OID(syntax)
+	if mr.Syntax.Validate() != nil {
+		return nil, ErrInvalidSchemaDefinition.WithInfo("invalid syntax OID", syntax)
+	}
+	return mr, nil
+}
+
+// Parses a RFC 4512 SyntaxDescription.
+func ParseLDAPSyntax(def string) (*LDAPSyntax, error) {
+	oid, f, err := parseSchemaDefinition(def)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.check("DESC"); err != nil {
+		return nil, err
+	}
+	syntax := &LDAPSyntax{
+		OID:        oid,
+		Extensions: f.extensions(),
+	}
+	if syntax.Description, err = f.single("DESC"); err != nil {
+		return nil, err
+	}
+	return syntax, nil
+}
@@ -66,6 +66,11 @@ type SearchResultEntry struct {
 }
 
 func GetSearchRequest(data []byte) (*SearchRequest, error) {
+	return getSearchRequest(data, DefaultLimits.MaxFilterDepth)
+}
+
+// Return a SearchRequest whose filter is nested at most maxFilterDepth deep
+func getSearchRequest(data []byte, maxFilterDepth int) (*SearchRequest, error) {
 	seq, err := BerGetSequence(data)
 	if err != nil {
 		return nil, err
@@ -124,7 +129,7 @@ func GetSearchRequest(data []byte) (*SearchRequest, error) {
 	if err != nil {
 		return nil, err
 	}
-	filter, err := GetFilter(seq[6])
+	filter, err := getFilter(seq[6], 1, maxFilterDepth)
 	if err != nil {
 		return nil, err
 	}
@@ -155,6 +160,58 @@ func GetSearchRequest(data []byte) (*SearchRequest, error) {
 	return req, nil
 }
 
+// Return a SearchResultEntry from BER-encoded data
+func GetSearchResultEntry(data []byte) (*SearchResultEntry, error) {
+	seq, err := BerGetSequence(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(seq) != 2 {
+		return nil, ErrWrongSequenceLength.WithInfo("SearchResultEntry sequence length", len(seq))
+	}
+	if seq[0].Type != BerTypeOctetString {
+		return nil, ErrWrongElementType.WithInfo("SearchResultEntry objectName type", seq[0].Type)
+	}
+	entry := &SearchResultEntry{ObjectName: BerGetOctetString(seq[0].Data)}
+	if seq[1].Type != BerTypeSequence {
+		return nil, ErrWrongElementType.WithInfo("SearchResultEntry attributes type", seq[1].Type)
+	}
+	a_seq, err := BerGetSequence(seq[1].Data)
+	if err != nil {
+		return nil, err
+	}
+	for _, ra := range a_seq {
+		if ra.Type != BerTypeSequence {
+			return nil, ErrWrongElementType.WithInfo("PartialAttribute type", ra.Type)
+		}
+		attr, err := GetAttribute(ra.Data)
+		if err != nil {
+			return nil, err
+		}
+		entry.Attributes = append(entry.Attributes, attr)
+	}
+	return entry, nil
+}
+
+// Return a SearchResultReference from BER-encoded data
+func GetSearchResultReference(data []byte) (SearchResultReference, error) {
+	seq, err := BerGetSequence(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(seq) == 0 {
+		return nil, ErrWrongSequenceLength.WithInfo("SearchResultReference sequence length", len(seq))
+	}
+	var ref SearchResultReference
+	for _, r := range seq {
+		if r.Type != BerTypeOctetString {
+			return nil, ErrWrongElementType.WithInfo("SearchResultReference URI type", r.Type)
+		}
+		ref = append(ref, BerGetOctetString(r.Data))
+	}
+	return ref, nil
+}
+
 // Return the BER-encoded sequence (without element header)
 func (s SearchResultReference) Encode() []byte {
 	b := bytes.NewBuffer(nil)
@@ -175,3 +232,26 @@ func (s *SearchResultEntry) Encode() []byte {
 	b.Write(BerEncodeSequence(ab.Bytes()))
 	return b.Bytes()
 }
+
+// Return the BER-encoded struct (without element header).
+// A nil Filter is encoded as (objectClass=*).
+func (r *SearchRequest) Encode() []byte {
+	b := bytes.NewBuffer(nil)
+	b.Write(BerEncodeOctetString(r.BaseObject))
+	b.Write(BerEncodeEnumerated(int64(r.Scope)))
+	b.Write(BerEncodeEnumerated(int64(r.DerefAliases)))
+	b.Write(BerEncodeInteger(int64(r.SizeLimit)))
+	b.Write(BerEncodeInteger(int64(r.TimeLimit)))
+	b.Write(BerEncodeBoolean(r.TypesOnly))
+	filter := r.Filter
+	if filter == nil {
+		filter = &Filter{Type: FilterTypePresent, Data: "objectClass"}
+	}
+	b.Write(filter.Encode())
+	ab := bytes.NewBuffer(nil)
+	for _, attr := range r.Attributes {
+		ab.Write(BerEncodeOctetString(attr))
+	}
+	b.Write(BerEncodeSequence(ab.Bytes()))
+	return b.Bytes()
+}
@@ -1,35 +1,125 @@
 package ldapserver
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
-	"log"
+	"log/slog"
 	"net"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
 // The LDAP server object
 type LDAPServer struct {
+	// Mutex protecting the fields below
+	lock sync.Mutex
 	// Listener for new connections
 	listener net.Listener
-	// Signal for shutdown complete
-	done chan struct{}
+	// Whether Shutdown() has been called
+	shuttingDown bool
+	// Open connections
+	conns map[*Conn]struct{}
+	// Number of accepted connections, in total and by client IP
+	connCount     int
+	connCountByIP map[string]int
+	// Wait group for the Serve() loop
+	serving sync.WaitGroup
+	// Wait group for the connection goroutines
+	connections sync.WaitGroup
+	// Context for all connections, cancelled on shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Last connection ID assigned
+	lastConnID atomic.Uint64
+	// Bind attempts by client IP and by bind DN
+	bindsByIP rateLimiter
+	bindsByDN rateLimiter
 	// Handler for LDAP requests
 	Handler Handler
 	// TLS config for StartTLS and LDAPS connections
 	TLSConfig *tls.Config
+	// Logger for server and connection events.
+	// If nil, slog.Default() is used.
+	Logger *slog.Logger
+	// Logger for the access log, recording connections and operations
+	// with their parameters, result codes and elapsed time.
+	// If nil, no access log is written.
+	AccessLog *slog.Logger
+	// Collector for connection and operation metrics, e.g. a *PrometheusMetrics.
+	// If nil, no metrics are collected.
+	Metrics Metrics
+	// Limits on the size and complexity of received messages.
+	// If nil, DefaultLimits is used.
+	Limits *Limits
+	// Maximum time to wait for the next request on a connection.
+	// If it expires while no operations are in progress,
+	// the client is sent a Notice of Disconnection and the connection is closed.
+	// If zero, there is no idle timeout.
+	IdleTimeout time.Duration
+	// Maximum time to read the rest of a request once it has started arriving.
+	// If zero, there is no read timeout.
+	ReadTimeout time.Duration
+	// Maximum time to write a response.
+	// If it expires, the connection is closed.
+	// If zero, there is no write timeout.
+	WriteTimeout time.Duration
+	// Maximum time for the TLS handshake of LDAPS and StartTLS connections.
+	// If zero, there is no handshake timeout.
+	TLSHandshakeTimeout time.Duration
+	// Maximum number of open connections.
+	// Further connections are sent a Notice of Disconnection with the busy result code and closed.
+	// If zero, there is no limit.
+	MaxConnections int
+	// Maximum number of open connections from a single IP address, enforced like MaxConnections.
+	// If zero, there is no limit.
+	MaxConnectionsPerIP int
+	// Rate limits of Bind requests from a single IP address and for a single DN.
+	// Bind requests exceeding them fail with the unwillingToPerform result code.
+	BindRateLimitPerIP RateLimit
+	BindRateLimitPerDN RateLimit
+	// Called when a connection or Bind request exceeds one of the limits above.
+	// It is rejected if the function returns true, or allowed anyway if it returns false.
+	// If nil, the event is logged as a warning and the connection or request is rejected.
+	OnLimitExceeded func(event *LimitExceeded) bool
+	// Additional information published in the Root DSE,
+	// combined with the features of the server
+	// and the values added by the Handler if it implements RootDSEProvider.
+	RootDSE *RootDSE
+	// Schema published in the subschema subentry at SubschemaDN
+	// and referenced by the subschemaSubentry attribute of the Root DSE.
+	// If nil, no schema is published.
+	Schema *Schema
+	// Request controls supported by the server, in addition to those
+	// registered by the Handler if it implements ControlProvider.
+	// They are published in the Root DSE as supportedControl,
+	// and requests with other critical controls fail with unavailableCriticalExtension.
+	Controls *ControlRegistry
 }
 
 // Create a new LDAP server with the specified handler.
 func NewLDAPServer(handler Handler) *LDAPServer {
 	s := &LDAPServer{
 		Handler: handler,
-		done:    make(chan struct{}),
 	}
+	s.init()
 	return s
 }
 
+// Initialize the internal state if needed.
+// The lock must be held by the caller unless the server is not yet shared.
+func (s *LDAPServer) init() {
+	if s.ctx == nil {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+		s.conns = make(map[*Conn]struct{})
+		s.connCountByIP = make(map[string]int)
+	}
+}
+
 // Load the certificate and key to enable TLS connections.
 func (s *LDAPServer) SetupTLS(certFile string, keyFile string) error {
 	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
@@ -76,57 +166,238 @@ func (s *LDAPServer) Serve(listener net.Listener) {
 	if s.Handler == nil {
 		s.Handler = &BaseHandler{}
 	}
-	if s.done == nil {
-		// Shutdown() was called
+	s.lock.Lock()
+	s.init()
+	if s.shuttingDown {
+		s.lock.Unlock()
 		listener.Close()
 		return
 	}
 	s.listener = listener
-	defer func() {
-		s.done <- struct{}{}
-	}()
+	s.serving.Add(1)
+	s.lock.Unlock()
+	defer s.serving.Done()
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
-			if opErr, ok := err.(*net.OpError); ok && opErr.Err.Error() == "use of closed network connection" {
+			if errors.Is(err, net.ErrClosed) {
 				break
 			}
-			log.Println("Accept error:", err.Error())
+			s.logger().Error("Accept error", "error", err)
 			continue
 		}
-		go s.handleConnection(conn)
+		ip := remoteIP(conn.RemoteAddr())
+		s.lock.Lock()
+		limit := s.connectionLimitExceeded(ip)
+		s.lock.Unlock()
+		if limit != "" && s.limitExceeded(&LimitExceeded{Limit: limit, RemoteAddr: conn.RemoteAddr()}) {
+			go rejectConnection(conn)
+			continue
+		}
+		s.lock.Lock()
+		if s.shuttingDown {
+			s.lock.Unlock()
+			conn.Close()
+			continue
+		}
+		s.connections.Add(1)
+		s.connCount++
+		s.connCountByIP[ip]++
+		s.lock.Unlock()
+		go func() {
+			defer s.connections.Done()
+			defer func() {
+				s.lock.Lock()
+				s.connCount--
+				s.connCountByIP[ip]--
+				if s.connCountByIP[ip] == 0 {
+					delete(s.connCountByIP, ip)
+				}
+				s.lock.Unlock()
+			}()
+			s.handleConnection(conn)
+		}()
 	}
 }
 
-// Signal the server to shut down and wait for it to stop.
-func (s *LDAPServer) Shutdown() {
-	if s.listener == nil {
-		return
+// Returns the connection limit that a new connection from the IP address would exceed, if any.
+// The lock must be held by the caller.
+func (s *LDAPServer) connectionLimitExceeded(ip string) LimitType {
+	if s.MaxConnections > 0 && s.connCount >= s.MaxConnections {
+		return LimitMaxConnections
+	}
+	if s.MaxConnectionsPerIP > 0 && s.connCountByIP[ip] >= s.MaxConnectionsPerIP {
+		return LimitMaxConnectionsPerIP
+	}
+	return ""
+}
+
+// Returns the Bind rate limit that the Bind request exceeds, if any
+func (s *LDAPServer) bindLimitExceeded(conn *Conn, req *BindRequest) LimitType {
+	now := time.Now()
+	if !s.bindsByIP.allow(remoteIP(conn.RemoteAddr()), s.BindRateLimitPerIP, now) {
+		return LimitBindRatePerIP
+	}
+	if req.Name != "" && !s.bindsByDN.allow(bindDNKey(req.Name), s.BindRateLimitPerDN, now) {
+		return LimitBindRatePerDN
+	}
+	return ""
+}
+
+// Reports an exceeded limit and returns whether it should be enforced
+func (s *LDAPServer) limitExceeded(event *LimitExceeded) bool {
+	if s.OnLimitExceeded != nil {
+		return s.OnLimitExceeded(event)
+	}
+	attrs := []any{"limit", event.Limit, "remote_addr", event.RemoteAddr.String()}
+	if event.BindDN != "" {
+		attrs = append(attrs, "bind_dn", event.BindDN)
 	}
-	s.listener.Close()
-	<-s.done
-	close(s.done)
-	s.done = nil
+	s.logger().Warn("Limit exceeded", attrs...)
+	return true
+}
+
+// Sends a Notice of Disconnection with the busy result code and closes the connection
+func rejectConnection(c net.Conn) {
+	defer c.Close()
+	res := &ExtendedResult{
+		Result: Result{
+			ResultCode:        ResultBusy,
+			DiagnosticMessage: "too many connections",
+		},
+		ResponseName: OIDNoticeOfDisconnection,
+	}
+	msg := &Message{}
+	msg.ProtocolOp.Type = TypeExtendedResponseOp
+	msg.ProtocolOp.Data = res.Encode()
+	c.SetWriteDeadline(time.Now().Add(time.Second))
+	c.Write(msg.EncodeWithHeader())
+}
+
+// Shut down the server gracefully.
+//
+// Stops accepting connections and stops reading requests from open connections.
+// Each client is sent a Notice of Disconnection with the unavailable result code,
+// and its connection is closed when the operations in progress have finished.
+// If the context is done before then, the contexts of the remaining operations are cancelled,
+// the connections are closed, and the context's error is returned.
+func (s *LDAPServer) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	s.init()
+	s.shuttingDown = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	conns := make([]*Conn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	s.lock.Unlock()
+	s.serving.Wait()
+	for _, conn := range conns {
+		conn.stopReading()
+	}
+	done := make(chan struct{})
+	go func() {
+		s.connections.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		s.lock.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.lock.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Returns the logger to use
+func (s *LDAPServer) logger() *slog.Logger {
+	if s.Logger == nil {
+		return slog.Default()
+	}
+	return s.Logger
+}
+
+// Returns the limits to use
+func (s *LDAPServer) limits() *Limits {
+	if s.Limits == nil {
+		return &DefaultLimits
+	}
+	return s.Limits
+}
+
+// Returns whether Shutdown() has been called
+func (s *LDAPServer) isShuttingDown() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.shuttingDown
 }
 
 // Handle a connection received from the listener.
 func (s *LDAPServer) handleConnection(c net.Conn) {
 	defer c.Close()
-	ldapConn := Conn{
+	ldapConn := &Conn{
 		conn:         c,
+		rawConn:      c,
+		id:           s.lastConnID.Add(1),
+		metrics:      s.Metrics,
+		limits:       s.limits(),
 		TLSConfig:    s.TLSConfig,
+		operations:   make(map[MessageID]context.CancelFunc),
 		MessageCache: make(map[MessageID]any),
+
+		idleTimeout:         s.IdleTimeout,
+		readTimeout:         s.ReadTimeout,
+		writeTimeout:        s.WriteTimeout,
+		tlsHandshakeTimeout: s.TLSHandshakeTimeout,
+		rootDSE:             s.rootDSE,
+		schema:              s.Schema,
+		controls:            s.controlRegistry(),
+		handler:             s.Handler,
 	}
-	if tlsConn, isTLS := c.(*tls.Conn); isTLS {
+	ldapConn.logger = s.logger().With("conn", ldapConn.id, "remote_addr", c.RemoteAddr().String())
+	if s.AccessLog != nil {
+		ldapConn.accessLog = s.AccessLog.With("conn", ldapConn.id)
+	}
+	ldapConn.ctx, ldapConn.cancel = context.WithCancel(s.ctx)
+	defer ldapConn.cancel()
+	s.lock.Lock()
+	if s.shuttingDown {
+		s.lock.Unlock()
+		return
+	}
+	s.conns[ldapConn] = struct{}{}
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, ldapConn)
+		s.lock.Unlock()
+	}()
+	ldapConn.logAccess("CONNECT", "remote_addr", c.RemoteAddr().String(), "local_addr", c.LocalAddr().String())
+	defer ldapConn.logAccess("DISCONNECT")
+	tlsConn, isTLS := c.(*tls.Conn)
+	if s.Metrics != nil {
+		s.Metrics.ConnectionOpened(isTLS)
+		defer s.Metrics.ConnectionClosed()
+	}
+	if isTLS {
 		ldapConn.isTLS = true
-		err := tlsConn.Handshake()
+		err := ldapConn.handshake(tlsConn)
 		if err != nil {
-			log.Println("TLS handshake error:", err)
+			ldapConn.logger.Warn("TLS handshake error", "error", err)
 			return
 		}
+		ldapConn.logAccessTLS(tlsConn.ConnectionState())
 	}
 	for {
-		if ldapConn.closed {
+		if ldapConn.closed.Load() {
 			// Close() called
 			return
 		}
@@ -134,148 +405,227 @@ func (s *LDAPServer) handleConnection(c net.Conn) {
 		msg, err := ldapConn.ReadMessage()
 		ldapConn.tlsStarting.RUnlock()
 		if err != nil {
+			if s.isShuttingDown() {
+				// Let the operations in progress finish
+				ldapConn.NotifyDisconnect(ResultUnavailable, "the server is shutting down")
+				ldapConn.asyncOperations.Wait()
+				ldapConn.Close()
+				return
+			}
+			if errors.Is(err, errIdleTimeout) {
+				ldapConn.logger.Info("Idle timeout exceeded, closing connection")
+				ldapConn.NotifyDisconnect(ResultOther, "idle timeout exceeded")
+				ldapConn.Close()
+				return
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				ldapConn.logger.Warn("Timed out reading LDAPMessage, closing connection")
+				ldapConn.Close()
+				return
+			}
 			if errors.Is(err, syscall.Errno(0x2746)) || // Windows: An existing connection was forcibly closed by the client
 				strings.HasSuffix(err.Error(), "connection reset by peer") {
-				log.Println("Connection was reset.")
+				ldapConn.logger.Info("Connection was reset")
+				ldapConn.Close()
+				return
+			} else if errors.Is(err, ErrLimitExceeded) {
+				ldapConn.logger.Warn("LDAPMessage exceeds limits, closing connection", "error", err)
+				ldapConn.NotifyDisconnect(ResultProtocolError, "LDAP message exceeds limits: "+err.Error())
 				ldapConn.Close()
 				return
 			} else {
-				log.Println("Error reading LDAPMessage, closing connection:", err)
+				ldapConn.logger.Warn("Error reading LDAPMessage, closing connection", "error", err)
 				// Might fail if it is a transport problem, but we're closing anyway
 				ldapConn.NotifyDisconnect(ResultProtocolError, "error reading LDAP message")
 				ldapConn.Close()
 				return
 			}
 		}
-		s.handleMessage(&ldapConn, msg)
+		s.handleMessage(ldapConn, msg)
 	}
 }
 
 // Process a LDAP Message received from the connection.
 func (s *LDAPServer) handleMessage(conn *Conn, msg *Message) {
+	logger := conn.logger.With("message_id", msg.MessageID, "op", OperationName(msg.ProtocolOp.Type))
+	logger.Debug("Received request")
 	if msg.ProtocolOp.Type != TypeBindRequestOp {
 		conn.asyncOperations.Add(1)
 		defer conn.asyncOperations.Done()
 	}
+	if rtype, ok := responseTypes[msg.ProtocolOp.Type]; ok {
+		if res := conn.controls.decodeControls(msg); res != nil {
+			logger.Info("Rejected request controls", "diagnostic_message", res.DiagnosticMessage)
+			conn.SendResult(msg.MessageID, nil, rtype, res)
+			return
+		}
+	}
 	switch msg.ProtocolOp.Type {
 	case TypeAbandonRequestOp:
 		messageID, err := BerGetInteger(msg.ProtocolOp.Data)
 		if err != nil {
-			log.Println("Error parsing Abandon request:", err)
-			conn.NotifyDisconnect(ResultProtocolError, "invalid Abandon request received")
-			conn.Close()
+			rejectRequest(conn, logger, "Abandon", err)
 			return
 		}
 		if messageID < 0 || messageID > 2147483647 {
-			log.Println("Invalid Abandon message ID:", messageID)
+			logger.Warn("Invalid Abandon message ID", "abandon_id", messageID)
 			return
 		}
-		s.Handler.Abandon(conn, msg, MessageID(messageID))
+		conn.logAccessNoResponse(msg, "ABANDON", "target", messageID)
+		conn.abandonOperation(MessageID(messageID))
+		s.Handler.Abandon(conn.ctx, conn, msg, MessageID(messageID))
 	case TypeAddRequestOp:
 		req, err := GetAddRequest(msg.ProtocolOp.Data)
+		if err == nil {
+			err = conn.limits.checkAttributes(req.Attributes)
+		}
 		if err != nil {
-			log.Println("Error parsing Add request:", err)
-			conn.NotifyDisconnect(ResultProtocolError, "invalid Add request received")
-			conn.Close()
+			rejectRequest(conn, logger, "Add", err)
 			return
 		}
+		conn.recordRequest(msg, "ADD", "dn", req.Entry)
+		ctx, done := conn.startOperation(msg.MessageID, 0)
 		conn.asyncOperations.Add(1)
 		go func() {
 			defer conn.asyncOperations.Done()
-			s.Handler.Add(conn, msg, req)
+			defer done()
+			s.Handler.Add(ctx, conn, msg, req)
 		}()
 	case TypeBindRequestOp:
 		req, err := GetBindRequest(msg.ProtocolOp.Data)
 		if err != nil {
-			log.Println("Error parsing Bind request:", err)
-			conn.NotifyDisconnect(ResultProtocolError, "invalid Bind request received")
-			conn.Close()
+			rejectRequest(conn, logger, "Bind", err)
+			return
+		}
+		conn.recordRequest(msg, "BIND", bindAccessAttrs(req)...)
+		if limit := s.bindLimitExceeded(conn, req); limit != "" &&
+			s.limitExceeded(&LimitExceeded{Limit: limit, RemoteAddr: conn.RemoteAddr(), BindDN: req.Name}) {
+			conn.SendResult(msg.MessageID, nil, TypeBindResponseOp,
+				ResultUnwillingToPerform.AsResult("too many Bind attempts, try again later"))
 			return
 		}
 		// Handle this so that implementations don't have to
 		if req.Version != 3 {
-			log.Println("Unsupported LDAP version in Bind request:", req.Version)
+			logger.Info("Unsupported LDAP version in Bind request", "version", req.Version)
 			conn.SendResult(msg.MessageID, nil, TypeBindResponseOp,
 				ResultProtocolError.AsResult("unsupported LDAP version specified in Bind request"))
 			return
 		}
 		conn.asyncOperations.Wait()
-		s.Handler.Bind(conn, msg, req)
+		s.Handler.Bind(conn.ctx, conn, msg, req)
+		conn.recordEnd(conn.ctx, msg.MessageID)
 	case TypeCompareRequestOp:
 		req, err := GetCompareRequest(msg.ProtocolOp.Data)
 		if err != nil {
-			log.Println("Error parsing Compare request:", err)
-			conn.NotifyDisconnect(ResultProtocolError, "invalid Compare request received")
-			conn.Close()
+			rejectRequest(conn, logger, "Compare", err)
 			return
 		}
+		conn.recordRequest(msg, "COMPARE", "dn", req.Object, "attr", req.Attribute)
+		ctx, done := conn.startOperation(msg.MessageID, 0)
 		conn.asyncOperations.Add(1)
 		go func() {
 			defer conn.asyncOperations.Done()
-			s.Handler.Compare(conn, msg, req)
+			defer done()
+			s.Handler.Compare(ctx, conn, msg, req)
 		}()
 	case TypeDeleteRequestOp:
 		dn := BerGetOctetString(msg.ProtocolOp.Data)
+		conn.recordRequest(msg, "DELETE", "dn", dn)
+		ctx, done := conn.startOperation(msg.MessageID, 0)
 		conn.asyncOperations.Add(1)
 		go func() {
 			defer conn.asyncOperations.Done()
-			s.Handler.Delete(conn, msg, dn)
+			defer done()
+			s.Handler.Delete(ctx, conn, msg, dn)
 		}()
 	case TypeExtendedRequestOp:
 		req, err := GetExtendedRequest(msg.ProtocolOp.Data)
 		if err != nil {
-			log.Println("Error parsing Extended request:", err)
-			conn.NotifyDisconnect(ResultProtocolError, "invalid Extended request received")
-			conn.Close()
+			rejectRequest(conn, logger, "Extended", err)
 			return
 		}
+		conn.recordRequest(msg, "EXTENDED", "oid", req.Name)
 		// This is not concurrent in case it is a StartTLS request
-		s.Handler.Extended(conn, msg, req)
+		ctx, done := conn.startOperation(msg.MessageID, 0)
+		defer done()
+		s.Handler.Extended(ctx, conn, msg, req)
 	case TypeModifyRequestOp:
 		req, err := GetModifyRequest(msg.ProtocolOp.Data)
+		if err == nil {
+			err = conn.limits.checkModifyRequest(req)
+		}
 		if err != nil {
-			log.Println("Error parsing Modify request:", err)
-			conn.NotifyDisconnect(ResultProtocolError, "invalid Modify request received")
-			conn.Close()
+			rejectRequest(conn, logger, "Modify", err)
 			return
 		}
+		conn.recordRequest(msg, "MODIFY", modifyAccessAttrs(req)...)
+		ctx, done := conn.startOperation(msg.MessageID, 0)
 		conn.asyncOperations.Add(1)
-		defer func() {
+		go func() {
 			defer conn.asyncOperations.Done()
-			s.Handler.Modify(conn, msg, req)
+			defer done()
+			s.Handler.Modify(ctx, conn, msg, req)
 		}()
 	case TypeModifyDNRequestOp:
 		req, err := GetModifyDNRequest(msg.ProtocolOp.Data)
 		if err != nil {
-			log.Println("Error parsing ModifyDN request:", err)
-			conn.NotifyDisconnect(ResultProtocolError, "invalid ModifyDN request received")
-			conn.Close()
+			rejectRequest(conn, logger, "ModifyDN", err)
 			return
 		}
+		conn.recordRequest(msg, "MODRDN", "dn", req.Object, "newrdn", req.NewRDN, "deleteoldrdn", req.DeleteOldRDN, "newsuperior", req.NewSuperior)
+		ctx, done := conn.startOperation(msg.MessageID, 0)
 		conn.asyncOperations.Add(1)
-		defer func() {
+		go func() {
 			defer conn.asyncOperations.Done()
-			s.Handler.ModifyDN(conn, msg, req)
+			defer done()
+			s.Handler.ModifyDN(ctx, conn, msg, req)
 		}()
 	case TypeSearchRequestOp:
-		req, err := GetSearchRequest(msg.ProtocolOp.Data)
+		req, err := getSearchRequest(msg.ProtocolOp.Data, conn.limits.MaxFilterDepth)
+		if err == nil {
+			err = conn.limits.checkSearchRequest(req)
+		}
 		if err != nil {
-			log.Println("Error parsing Search request:", err)
-			conn.NotifyDisconnect(ResultProtocolError, "invalid Search request received")
-			conn.Close()
+			rejectRequest(conn, logger, "Search", err)
 			return
 		}
+		conn.recordRequest(msg, "SEARCH", searchAccessAttrs(req)...)
+		ctx, done := conn.startOperation(msg.MessageID, time.Duration(req.TimeLimit)*time.Second)
 		conn.asyncOperations.Add(1)
 		go func() {
 			defer conn.asyncOperations.Done()
-			s.Handler.Search(conn, msg, req)
+			defer done()
+			if req.IsRootDSESearch() {
+				// The Root DSE is answered by the server for all handlers
+				SearchRootDSE(ctx, conn, msg, req)
+				return
+			}
+			if conn.IsSubschemaSearch(req) {
+				// So is the subschema subentry the Root DSE references
+				SearchSubschema(ctx, conn, msg, req)
+				return
+			}
+			s.Handler.Search(ctx, conn, msg, req)
 		}()
 	case TypeUnbindRequestOp:
 		// Unbind has no result
 		// Simply close the connection
+		conn.logAccessNoResponse(msg, "UNBIND")
 		conn.Close()
 	default:
 		// Let the handler deal with it if it knows how
-		s.Handler.Other(conn, msg)
+		s.Handler.Other(conn.ctx, conn, msg)
+	}
+}
+
+// Log a request that could not be parsed or exceeds the limits
+// and disconnect the client with a protocol error.
+func rejectRequest(conn *Conn, logger *slog.Logger, name string, err error) {
+	logger.Warn("Error parsing "+name+" request", "error", err)
+	diagnosticMessage := "invalid " + name + " request received"
+	if errors.Is(err, ErrLimitExceeded) {
+		diagnosticMessage = name + " request exceeds limits: " + err.Error()
 	}
+	conn.NotifyDisconnect(ResultProtocolError, diagnosticMessage)
+	conn.Close()
 }
@@ -1,7 +1,17 @@
 package ldapserver_test
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/merlinz01/ldapserver"
 )
@@ -14,5 +24,659 @@ func TestServerLifecycle(t *testing.T) {
 			t.Error("Error listening:", err)
 		}
 	}()
-	s.Shutdown()
+	s.Shutdown(context.Background())
+}
+
+// Handler that blocks in Search until released or the operation's context is done
+type blockingHandler struct {
+	ldapserver.BaseHandler
+	started chan struct{}
+	release chan struct{}
+	errs    chan error
+}
+
+func (h *blockingHandler) Search(ctx context.Context, conn *ldapserver.Conn, msg *ldapserver.Message, req *ldapserver.SearchRequest) {
+	h.started <- struct{}{}
+	select {
+	case <-h.release:
+		conn.SendResult(msg.MessageID, nil, ldapserver.TypeSearchResultDoneOp, ldapserver.ResultSuccess.AsResult(""))
+		h.errs <- nil
+	case <-ctx.Done():
+		h.errs <- ctx.Err()
+	}
+}
+
+func TestOperationContext(t *testing.T) {
+	handler := &blockingHandler{started: make(chan struct{}), errs: make(chan error)}
+	conn := startTestServer(t, handler)
+	send := func(id ldapserver.MessageID, optype ldapserver.BerType, data []byte) {
+		msg := ldapserver.Message{MessageID: id}
+		msg.ProtocolOp.Type = optype
+		msg.ProtocolOp.Data = data
+		if _, err := conn.Write(msg.EncodeWithHeader()); err != nil {
+			t.Fatal("Error sending request:", err)
+		}
+	}
+	wait := func(expected error) {
+		t.Helper()
+		select {
+		case err := <-handler.errs:
+			if err != expected {
+				t.Fatal("wrong context error", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("context not cancelled")
+		}
+	}
+
+	// Abandon
+	send(1, ldapserver.TypeSearchRequestOp, (&ldapserver.SearchRequest{BaseObject: "dc=example,dc=com"}).Encode())
+	<-handler.started
+	send(2, ldapserver.TypeAbandonRequestOp, ldapserver.BerEncodeIntegerRaw(1))
+	wait(context.Canceled)
+
+	// Time limit
+	send(3, ldapserver.TypeSearchRequestOp, (&ldapserver.SearchRequest{BaseObject: "dc=example,dc=com", TimeLimit: 1}).Encode())
+	<-handler.started
+	wait(context.DeadlineExceeded)
+
+	// Connection closed
+	send(4, ldapserver.TypeSearchRequestOp, (&ldapserver.SearchRequest{BaseObject: "dc=example,dc=com"}).Encode())
+	<-handler.started
+	conn.Close()
+	wait(context.Canceled)
+}
+
+func TestShutdown(t *testing.T) {
+	for _, drain := range []bool{true, false} {
+		handler := &blockingHandler{started: make(chan struct{}), release: make(chan struct{}), errs: make(chan error, 1)}
+		server := ldapserver.NewLDAPServer(handler)
+		server.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal("Error listening:", err)
+		}
+		go server.Serve(listener)
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal("Error dialing:", err)
+		}
+		defer conn.Close()
+		msg := ldapserver.Message{MessageID: 1}
+		msg.ProtocolOp.Type = ldapserver.TypeSearchRequestOp
+		msg.ProtocolOp.Data = (&ldapserver.SearchRequest{BaseObject: "dc=example,dc=com"}).Encode()
+		if _, err := conn.Write(msg.EncodeWithHeader()); err != nil {
+			t.Fatal("Error sending request:", err)
+		}
+		<-handler.started
+
+		timeout := 5 * time.Second
+		if !drain {
+			timeout = 100 * time.Millisecond
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		shutdownErr := make(chan error, 1)
+		go func() {
+			shutdownErr <- server.Shutdown(ctx)
+		}()
+
+		notice, err := ldapserver.ReadLDAPMessage(conn)
+		if err != nil {
+			t.Fatal("Error reading notice:", err)
+		}
+		if notice.MessageID != 0 || notice.ProtocolOp.Type != ldapserver.TypeExtendedResponseOp {
+			t.Fatal("expected an unsolicited notification", notice)
+		}
+		res, err := ldapserver.GetExtendedResult(notice.ProtocolOp.Data)
+		if err != nil {
+			t.Fatal("Error parsing notice:", err)
+		}
+		if res.ResponseName != ldapserver.OIDNoticeOfDisconnection || res.ResultCode != ldapserver.ResultUnavailable {
+			t.Fatalf("wrong notice %+v", res)
+		}
+
+		if drain {
+			close(handler.release)
+			done, err := ldapserver.ReadLDAPMessage(conn)
+			if err != nil {
+				t.Fatal("Error reading result:", err)
+			}
+			if done.MessageID != 1 || done.ProtocolOp.Type != ldapserver.TypeSearchResultDoneOp {
+				t.Fatal("expected the result of the operation in progress", done)
+			}
+			if err = <-handler.errs; err != nil {
+				t.Fatal("operation was cancelled:", err)
+			}
+			if err = <-shutdownErr; err != nil {
+				t.Fatal("Error shutting down:", err)
+			}
+		} else {
+			if err = <-handler.errs; err != context.Canceled {
+				t.Fatal("operation was not cancelled:", err)
+			}
+			if err = <-shutdownErr; err != context.DeadlineExceeded {
+				t.Fatal("expected DeadlineExceeded, got", err)
+			}
+		}
+		if _, err = ldapserver.ReadLDAPMessage(conn); err == nil {
+			t.Fatal("connection was not closed")
+		}
+	}
+}
+
+func TestShutdownDuringStartTLS(t *testing.T) {
+	server := ldapserver.NewLDAPServer(&ldapserver.BaseHandler{})
+	server.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	if err := server.SetupTLS("test/cert.pem", "test/privkey.pem"); err != nil {
+		t.Fatal("Error setting up TLS:", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error listening:", err)
+	}
+	go server.Serve(listener)
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal("Error dialing:", err)
+	}
+	defer conn.Close()
+
+	// Start TLS but never send a ClientHello, leaving the handshake unfinished
+	res, err := ldapserver.GetExtendedResult(doRequest(t, conn, 1, ldapserver.TypeExtendedRequestOp,
+		(&ldapserver.ExtendedRequest{Name: ldapserver.OIDStartTLS}).Encode())[0].ProtocolOp.Data)
+	if err != nil || res.ResultCode != ldapserver.ResultSuccess {
+		t.Fatal("wrong StartTLS result", res, err)
+	}
+	// Give the server time to begin the handshake
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- server.Shutdown(ctx)
+	}()
+	select {
+	case err = <-shutdownErr:
+		if err != nil && err != context.DeadlineExceeded {
+			t.Fatal("Error shutting down:", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown blocked on the TLS handshake")
+	}
+}
+
+func TestLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	handler := ldapserver.NewMemoryHandler()
+	server := ldapserver.NewLDAPServer(handler)
+	server.Logger = slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error listening:", err)
+	}
+	go server.Serve(listener)
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal("Error dialing:", err)
+	}
+	msg := ldapserver.Message{MessageID: 7}
+	msg.ProtocolOp.Type = ldapserver.TypeDeleteRequestOp
+	msg.ProtocolOp.Data = []byte("cn=missing")
+	if _, err := conn.Write(msg.EncodeWithHeader()); err != nil {
+		t.Fatal("Error sending request:", err)
+	}
+	if _, err := ldapserver.ReadLDAPMessage(conn); err != nil {
+		t.Fatal("Error reading response:", err)
+	}
+	conn.Close()
+	server.Shutdown(context.Background())
+
+	var sent map[string]any
+	for _, line := range bytes.Split(buf.Bytes(), []byte("\n")) {
+		var record map[string]any
+		if json.Unmarshal(line, &record) == nil && record["msg"] == "Sent response" && record["message_id"] == 7.0 {
+			sent = record
+		}
+	}
+	if sent == nil {
+		t.Fatal("response not logged:", buf.String())
+	}
+	if sent["conn"] != 1.0 || sent["op"] != "delResponse" ||
+		sent["result_code"] != float64(ldapserver.ResultNoSuchObject) || sent["remote_addr"] != conn.LocalAddr().String() {
+		t.Fatal("wrong log record", sent)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	buf := &bytes.Buffer{}
+	handler := newTestDirectory(t)
+	server := ldapserver.NewLDAPServer(handler)
+	server.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	server.AccessLog = slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey || a.Key == slog.LevelKey || a.Key == "elapsed" {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error listening:", err)
+	}
+	go server.Serve(listener)
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal("Error dialing:", err)
+	}
+	doRequest(t, conn, 1, ldapserver.TypeSearchRequestOp, (&ldapserver.SearchRequest{
+		BaseObject: "dc=example,dc=com",
+		Scope:      ldapserver.SearchScopeWholeSubtree,
+		Filter:     ldapserver.MustParseFilter("(objectClass=*)"),
+	}).Encode())
+	doRequest(t, conn, 2, ldapserver.TypeDeleteRequestOp, []byte("cn=missing,dc=example,dc=com"))
+	msg := ldapserver.Message{MessageID: 3}
+	msg.ProtocolOp.Type = ldapserver.TypeUnbindRequestOp
+	if _, err := conn.Write(msg.EncodeWithHeader()); err != nil {
+		t.Fatal("Error sending request:", err)
+	}
+	ldapserver.ReadLDAPMessage(conn)
+	conn.Close()
+	server.Shutdown(context.Background())
+
+	log := buf.String()
+	for _, expected := range []string{
+		"msg=CONNECT conn=1 remote_addr=" + conn.LocalAddr().String(),
+		`msg=SEARCH conn=1 op=1 msgid=1 base="dc=example,dc=com" scope=sub filter="(objectClass=*)"`,
+		"msg=RESULT conn=1 op=1 msgid=1 tag=searchResDone result_code=0 entries=3 references=0\n",
+		`msg=DELETE conn=1 op=2 msgid=2 dn="cn=missing,dc=example,dc=com"`,
+		"msg=RESULT conn=1 op=2 msgid=2 tag=delResponse result_code=32\n",
+		"msg=UNBIND conn=1 op=3 msgid=3\n",
+		"msg=DISCONNECT conn=1\n",
+	} {
+		if !strings.Contains(log, expected) {
+			t.Errorf("access log does not contain %q:\n%s", expected, log)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := ldapserver.NewPrometheusMetrics()
+	server := ldapserver.NewLDAPServer(newTestDirectory(t))
+	server.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	server.Metrics = metrics
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error listening:", err)
+	}
+	go server.Serve(listener)
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal("Error dialing:", err)
+	}
+	doRequest(t, conn, 1, ldapserver.TypeSearchRequestOp, (&ldapserver.SearchRequest{
+		BaseObject: "dc=example,dc=com",
+		Scope:      ldapserver.SearchScopeWholeSubtree,
+	}).Encode())
+	doRequest(t, conn, 2, ldapserver.TypeDeleteRequestOp, []byte("cn=missing,dc=example,dc=com"))
+	doRequest(t, conn, 3, ldapserver.TypeDeleteRequestOp, []byte("cn=missing,dc=example,dc=com"))
+	conn.Close()
+	server.Shutdown(context.Background())
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain") {
+		t.Error("wrong content type", recorder.Header().Get("Content-Type"))
+	}
+	text := recorder.Body.String()
+	for _, expected := range []string{
+		"\nldap_connections_accepted_total{tls=\"false\"} 1\n",
+		"\nldap_connections_accepted_total{tls=\"true\"} 0\n",
+		"\nldap_connections_active 0\n",
+		"\nldap_starttls_total 0\n",
+		"\nldap_operations_total{op=\"delRequest\",result_code=\"32\"} 2\n",
+		"\nldap_operations_total{op=\"searchRequest\",result_code=\"0\"} 1\n",
+		"\nldap_operation_duration_seconds_bucket{op=\"delRequest\",le=\"+Inf\"} 2\n",
+		"\nldap_operation_duration_seconds_count{op=\"searchRequest\"} 1\n",
+	} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("metrics do not contain %q:\n%s", expected, text)
+		}
+	}
+	if strings.Contains(text, "ldap_received_bytes_total 0\n") || strings.Contains(text, "ldap_sent_bytes_total 0\n") {
+		t.Error("bytes not counted:\n", text)
+	}
+
+	// The zero value is usable
+	zero := &ldapserver.PrometheusMetrics{}
+	zero.OperationCompleted(ldapserver.TypeBindRequestOp, ldapserver.ResultSuccess, time.Millisecond)
+	var b strings.Builder
+	if err := zero.WriteText(&b); err != nil {
+		t.Fatal("Error writing metrics:", err)
+	}
+	if !strings.Contains(b.String(), "\nldap_operation_duration_seconds_bucket{op=\"bindRequest\",le=\"0.001\"} 1\n") {
+		t.Error("zero value metrics not recorded:\n", b.String())
+	}
+}
+
+func TestLimits(t *testing.T) {
+	server := ldapserver.NewLDAPServer(newTestDirectory(t))
+	server.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	server.Limits = &ldapserver.Limits{
+		MaxMessageSize: 1000,
+		MaxFilterDepth: 3,
+		MaxAttributes:  2,
+		MaxValues:      2,
+		MaxControls:    1,
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error listening:", err)
+	}
+	go server.Serve(listener)
+	defer server.Shutdown(context.Background())
+
+	// Send a message and expect a Notice of Disconnection with a protocol error
+	expectDisconnect := func(name string, msg *ldapserver.Message) {
+		t.Helper()
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal("Error dialing:", err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write(msg.EncodeWithHeader()); err != nil {
+			t.Fatal("Error sending request:", err)
+		}
+		notice, err := ldapserver.ReadLDAPMessage(conn)
+		if err != nil {
+			t.Fatal(name, "error reading notice:", err)
+		}
+		res, err := ldapserver.GetExtendedResult(notice.ProtocolOp.Data)
+		if err != nil {
+			t.Fatal(name, "error parsing notice:", err)
+		}
+		if notice.MessageID != 0 || res.ResponseName != ldapserver.OIDNoticeOfDisconnection ||
+			res.ResultCode != ldapserver.ResultProtocolError {
+			t.Fatal(name, "wrong notice", notice.MessageID, res)
+		}
+		if !strings.Contains(res.DiagnosticMessage, "limit exceeded") {
+			t.Fatal(name, "wrong diagnostic message", res.DiagnosticMessage)
+		}
+		if _, err = ldapserver.ReadLDAPMessage(conn); err == nil {
+			t.Fatal(name, "connection not closed")
+		}
+	}
+	request := func(optype ldapserver.BerType, data []byte, controls ...ldapserver.Control) *ldapserver.Message {
+		msg := &ldapserver.Message{MessageID: 1, Controls: controls}
+		msg.ProtocolOp.Type = optype
+		msg.ProtocolOp.Data = data
+		return msg
+	}
+
+	expectDisconnect("message size", request(ldapserver.TypeDeleteRequestOp, bytes.Repeat([]byte("a"), 1000)))
+	expectDisconnect("controls", request(ldapserver.TypeDeleteRequestOp, []byte("dc=example,dc=com"),
+		ldapserver.Control{OID: "1.2.3"}, ldapserver.Control{OID: "1.2.4"}))
+	expectDisconnect("filter depth", request(ldapserver.TypeSearchRequestOp, (&ldapserver.SearchRequest{
+		Filter: ldapserver.MustParseFilter("(&(|(!(cn=a))))"),
+	}).Encode()))
+	expectDisconnect("search attributes", request(ldapserver.TypeSearchRequestOp, (&ldapserver.SearchRequest{
+		Filter:     ldapserver.MustParseFilter("(cn=a)"),
+		Attributes: []string{"cn", "sn", "mail"},
+	}).Encode()))
+	expectDisconnect("add attributes", request(ldapserver.TypeAddRequestOp, (&ldapserver.AddRequest{
+		Entry: "cn=a,dc=example,dc=com",
+		Attributes: []ldapserver.Attribute{
+			{Description: "objectClass", Values: []string{"person"}},
+			{Description: "cn", Values: []string{"a"}},
+			{Description: "sn", Values: []string{"a"}},
+		},
+	}).Encode()))
+	expectDisconnect("modify values", request(ldapserver.TypeModifyRequestOp, (&ldapserver.ModifyRequest{
+		Object: "cn=a,dc=example,dc=com",
+		Changes: []ldapserver.ModifyChange{{
+			Operation:    ldapserver.ModifyAdd,
+			Modification: ldapserver.Attribute{Description: "mail", Values: []string{"a", "b", "c"}},
+		}},
+	}).Encode()))
+
+	// Requests within the limits are processed
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal("Error dialing:", err)
+	}
+	defer conn.Close()
+	responses := doRequest(t, conn, 1, ldapserver.TypeSearchRequestOp, (&ldapserver.SearchRequest{
+		BaseObject: "dc=example,dc=com",
+		Filter:     ldapserver.MustParseFilter("(&(!(cn=a)))"),
+		Attributes: []string{"cn", "sn"},
+	}).Encode())
+	res, err := ldapserver.GetResult(responses[len(responses)-1].ProtocolOp.Data)
+	if err != nil || res.ResultCode != ldapserver.ResultSuccess {
+		t.Fatal("wrong search result", res, err)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	handler := &blockingHandler{started: make(chan struct{}), release: make(chan struct{}), errs: make(chan error, 1)}
+	server := ldapserver.NewLDAPServer(handler)
+	server.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	server.IdleTimeout = 200 * time.Millisecond
+	server.ReadTimeout = 200 * time.Millisecond
+	if err := server.SetupTLS("test/cert.pem", "test/privkey.pem"); err != nil {
+		t.Fatal("Error setting up TLS:", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error listening:", err)
+	}
+	go server.Serve(listener)
+	defer server.Shutdown(context.Background())
+	tlsServer := ldapserver.NewLDAPServer(handler)
+	tlsServer.Logger = server.Logger
+	tlsServer.TLSHandshakeTimeout = 200 * time.Millisecond
+	tlsListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error listening:", err)
+	}
+	go tlsServer.Serve(tls.NewListener(tlsListener, server.TLSConfig))
+	defer tlsServer.Shutdown(context.Background())
+
+	dial := func(listener net.Listener) net.Conn {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal("Error dialing:", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+	expectClosed := func(name string, conn net.Conn) {
+		t.Helper()
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatal(name, "connection not closed:", err)
+		}
+	}
+
+	// Idle connection
+	conn := dial(listener)
+	defer conn.Close()
+	notice, err := ldapserver.ReadLDAPMessage(conn)
+	if err != nil {
+		t.Fatal("Error reading notice:", err)
+	}
+	res, err := ldapserver.GetExtendedResult(notice.ProtocolOp.Data)
+	if err != nil {
+		t.Fatal("Error parsing notice:", err)
+	}
+	if res.ResponseName != ldapserver.OIDNoticeOfDisconnection || res.ResultCode != ldapserver.ResultOther {
+		t.Fatal("wrong notice", res)
+	}
+	expectClosed("idle", conn)
+
+	// The idle timeout does not expire while an operation is in progress
+	conn = dial(listener)
+	defer conn.Close()
+	msg := ldapserver.Message{MessageID: 1}
+	msg.ProtocolOp.Type = ldapserver.TypeSearchRequestOp
+	msg.ProtocolOp.Data = (&ldapserver.SearchRequest{BaseObject: "dc=example,dc=com"}).Encode()
+	if _, err := conn.Write(msg.EncodeWithHeader()); err != nil {
+		t.Fatal("Error sending request:", err)
+	}
+	<-handler.started
+	time.Sleep(500 * time.Millisecond)
+	handler.release <- struct{}{}
+	done, err := ldapserver.ReadLDAPMessage(conn)
+	if err != nil || done.MessageID != 1 || done.ProtocolOp.Type != ldapserver.TypeSearchResultDoneOp {
+		t.Fatal("wrong response", done, err)
+	}
+	if err = <-handler.errs; err != nil {
+		t.Fatal("operation cancelled:", err)
+	}
+
+	// Incomplete request
+	conn = dial(listener)
+	defer conn.Close()
+	if _, err := conn.Write([]byte{0x30, 0x10, 0x02}); err != nil {
+		t.Fatal("Error sending request:", err)
+	}
+	expectClosed("read", conn)
+
+	// Incomplete TLS handshake
+	conn = dial(tlsListener)
+	defer conn.Close()
+	expectClosed("handshake", conn)
+}
+
+// Handler that rejects all Bind requests with invalidCredentials
+type invalidCredentialsHandler struct {
+	ldapserver.BaseHandler
+}
+
+func (h *invalidCredentialsHandler) Bind(ctx context.Context, conn *ldapserver.Conn, msg *ldapserver.Message, req *ldapserver.BindRequest) {
+	conn.SendResult(msg.MessageID, nil, ldapserver.TypeBindResponseOp, ldapserver.ResultInvalidCredentials.AsResult(""))
+}
+
+func TestConnectionAndBindLimits(t *testing.T) {
+	server := ldapserver.NewLDAPServer(&invalidCredentialsHandler{})
+	server.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	server.MaxConnectionsPerIP = 1
+	server.BindRateLimitPerDN = ldapserver.RateLimit{Rate: 0.001, Burst: 2}
+	events := make(chan *ldapserver.LimitExceeded, 10)
+	server.OnLimitExceeded = func(event *ldapserver.LimitExceeded) bool {
+		events <- event
+		return true
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error listening:", err)
+	}
+	go server.Serve(listener)
+	defer server.Shutdown(context.Background())
+
+	bind := func(conn net.Conn, id ldapserver.MessageID, name string) ldapserver.LDAPResultCode {
+		t.Helper()
+		return doResult(t, conn, id, ldapserver.TypeBindRequestOp, (&ldapserver.BindRequest{
+			Version: 3, Name: name, AuthType: ldapserver.AuthenticationTypeSimple, Credentials: "wrong",
+		}).Encode()).ResultCode
+	}
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal("Error dialing:", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	for i, name := range []string{"uid=jdoe,dc=example,dc=com", "UID=jdoe,DC=Example,DC=com"} {
+		if code := bind(conn, ldapserver.MessageID(i+1), name); code != ldapserver.ResultInvalidCredentials {
+			t.Fatal("wrong result code", code)
+		}
+	}
+	if code := bind(conn, 3, "uid=JDoe,dc=example,dc=com"); code != ldapserver.ResultUnwillingToPerform {
+		t.Fatal("Bind not rate limited", code)
+	}
+	event := <-events
+	if event.Limit != ldapserver.LimitBindRatePerDN || event.BindDN != "uid=JDoe,dc=example,dc=com" {
+		t.Fatal("wrong event", event)
+	}
+	if code := bind(conn, 4, "uid=other,dc=example,dc=com"); code != ldapserver.ResultInvalidCredentials {
+		t.Fatal("wrong result code", code)
+	}
+
+	// A second connection from the same IP address is rejected
+	conn2, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal("Error dialing:", err)
+	}
+	defer conn2.Close()
+	conn2.SetDeadline(time.Now().Add(5 * time.Second))
+	notice, err := ldapserver.ReadLDAPMessage(conn2)
+	if err != nil {
+		t.Fatal("Error reading notice:", err)
+	}
+	res, err := ldapserver.GetExtendedResult(notice.ProtocolOp.Data)
+	if err != nil {
+		t.Fatal("Error parsing notice:", err)
+	}
+	if res.ResponseName != ldapserver.OIDNoticeOfDisconnection || res.ResultCode != ldapserver.ResultBusy {
+		t.Fatal("wrong notice", res)
+	}
+	if _, err = conn2.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("connection not closed:", err)
+	}
+	event = <-events
+	if event.Limit != ldapserver.LimitMaxConnectionsPerIP {
+		t.Fatal("wrong event", event)
+	}
+
+	// The connection is counted until it is closed
+	conn.Close()
+	for i := 0; ; i++ {
+		conn3, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal("Error dialing:", err)
+		}
+		conn3.SetDeadline(time.Now().Add(5 * time.Second))
+		msg := ldapserver.Message{MessageID: 1}
+		msg.ProtocolOp.Type = ldapserver.TypeBindRequestOp
+		msg.ProtocolOp.Data = (&ldapserver.BindRequest{Version: 3, AuthType: ldapserver.AuthenticationTypeSimple, Credentials: ""}).Encode()
+		conn3.Write(msg.EncodeWithHeader())
+		res, err := ldapserver.ReadLDAPMessage(conn3)
+		conn3.Close()
+		if err == nil && res.MessageID == 1 {
+			break
+		}
+		if i == 50 {
+			t.Fatal("connection not accepted after the first was closed")
+		}
+		<-events
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBindRateLimitWithoutBurst(t *testing.T) {
+	server := ldapserver.NewLDAPServer(&invalidCredentialsHandler{})
+	server.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	// A zero Burst allows one Bind at a time
+	server.BindRateLimitPerIP = ldapserver.RateLimit{Rate: 0.001}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error listening:", err)
+	}
+	go server.Serve(listener)
+	defer server.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal("Error dialing:", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	for i, expected := range []ldapserver.LDAPResultCode{ldapserver.ResultInvalidCredentials, ldapserver.ResultUnwillingToPerform} {
+		res := doResult(t, conn, ldapserver.MessageID(i+1), ldapserver.TypeBindRequestOp, (&ldapserver.BindRequest{
+			Version: 3, Name: "uid=jdoe,dc=example,dc=com", AuthType: ldapserver.AuthenticationTypeSimple, Credentials: "wrong",
+		}).Encode())
+		if res.ResultCode != expected {
+			t.Fatal("wrong result code", res.ResultCode, "expected", expected)
+		}
+	}
 }
@@ -0,0 +1,426 @@
+package ldapserver
+
+import (
+	"context"
+	"sort"
+)
+
+// A key of the Server-Side Sorting request control (RFC 2891)
+//
+//	SortKeyList ::= SEQUENCE OF SEQUENCE {
+//		attributeType   AttributeDescription,
+//		orderingRule    [0] MatchingRuleId OPTIONAL,
+//		reverseOrder    [1] BOOLEAN DEFAULT FALSE }
+type SortKey struct {
+	// Attribute to sort by
+	AttributeType string
+	// Name or OID of the matching rule used to order the values,
+	// or "" for the ordering rule of the attribute
+	OrderingRule string
+	// Whether to sort in descending order
+	ReverseOrder bool
+}
+
+// The Server-Side Sorting request control (RFC 2891)
+type SortRequestControl struct {
+	// Sort keys, the most significant first
+	Keys []SortKey
+}
+
+func (c *SortRequestControl) ControlType() OID {
+	return OIDServerSideSortRequest
+}
+
+func (c *SortRequestControl) EncodeValue() string {
+	var data []byte
+	for _, key := range c.Keys {
+		kdata := BerEncodeOctetString(key.AttributeType)
+		if key.OrderingRule != "" {
+			kdata = append(kdata, BerEncodeElement(BerContextSpecificType(0, false), []byte(key.OrderingRule))...)
+		}
+		if key.ReverseOrder {
+			kdata = append(kdata, BerEncodeElement(BerContextSpecificType(1, false), []byte{0xff})...)
+		}
+		data = append(data, BerEncodeSequence(kdata)...)
+	}
+	return string(BerEncodeSequence(data))
+}
+
+// Decode the value of a Server-Side Sorting request control
+func DecodeSortRequestControl(value string) (TypedControl, error) {
+	seq, err := controlValueSequence(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(seq) == 0 {
+		return nil, ErrWrongSequenceLength.WithInfo("SortKeyList sequence length", 0)
+	}
+	c := &SortRequestControl{}
+	for _, raw := range seq {
+		if raw.Type != BerTypeSequence {
+			return nil, ErrWrongElementType.WithInfo("SortKey type", raw.Type)
+		}
+		kseq, err := BerGetSequence(raw.Data)
+		if err != nil {
+			return nil, err
+		}
+		if len(kseq) < 1 || len(kseq) > 3 {
+			return nil, ErrWrongSequenceLength.WithInfo("SortKey sequence length", len(kseq))
+		}
+		if kseq[0].Type != BerTypeOctetString {
+			return nil, ErrWrongElementType.WithInfo("SortKey attributeType type", kseq[0].Type)
+		}
+		key := SortKey{AttributeType: BerGetOctetString(kseq[0].Data)}
+		rest := kseq[1:]
+		if len(rest) > 0 && rest[0].Type == BerContextSpecificType(0, false) {
+			key.OrderingRule = BerGetOctetString(rest[0].Data)
+			rest = rest[1:]
+		}
+		if len(rest) > 0 && rest[0].Type == BerContextSpecificType(1, false) {
+			key.ReverseOrder, err = BerGetBoolean(rest[0].Data)
+			if err != nil {
+				return nil, err
+			}
+			rest = rest[1:]
+		}
+		if len(rest) > 0 {
+			return nil, ErrWrongElementType.WithInfo("SortKey element type", rest[0].Type)
+		}
+		c.Keys = append(c.Keys, key)
+	}
+	return c, nil
+}
+
+// The Server-Side Sorting response control (RFC 2891)
+//
+//	SortResult ::= SEQUENCE {
+//		sortResult  ENUMERATED,
+//		attributeType [0] AttributeDescription OPTIONAL }
+type SortResponseControl struct {
+	// Result of the sort, e.g. ResultSuccess or ResultInappropriateMatching
+	Result LDAPResultCode
+	// Attribute that caused the sort to fail, if any
+	AttributeType string
+}
+
+func (c *SortResponseControl) ControlType() OID {
+	return OIDServerSideSortResponse
+}
+
+func (c *SortResponseControl) EncodeValue() string {
+	data := BerEncodeEnumerated(int64(c.Result))
+	if c.AttributeType != "" {
+		data = append(data, BerEncodeElement(BerContextSpecificType(0, false), []byte(c.AttributeType))...)
+	}
+	return string(BerEncodeSequence(data))
+}
+
+// Decode the value of a Server-Side Sorting response control
+func DecodeSortResponseControl(value string) (TypedControl, error) {
+	seq, err := controlValueSequence(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(seq) < 1 || len(seq) > 2 {
+		return nil, ErrWrongSequenceLength.WithInfo("SortResult sequence length", len(seq))
+	}
+	if seq[0].Type != BerTypeEnumerated {
+		return nil, ErrWrongElementType.WithInfo("SortResult sortResult type", seq[0].Type)
+	}
+	result, err := BerGetEnumerated(seq[0].Data)
+	if err != nil {
+		return nil, err
+	}
+	c := &SortResponseControl{Result: LDAPResultCode(result)}
+	if len(seq) > 1 {
+		if seq[1].Type != BerContextSpecificType(0, false) {
+			return nil, ErrWrongElementType.WithInfo("SortResult attributeType type", seq[1].Type)
+		}
+		c.AttributeType = BerGetOctetString(seq[1].Data)
+	}
+	return c, nil
+}
+
+// Returns the rule ordering the values of the sort key, or nil if there is none,
+// in which case the sort fails with inappropriateMatching (RFC 2891 section 1.2).
+func sortKeyRule(key *SortKey, rules MatchingRuleResolver) *MatchingRule {
+	if key.OrderingRule != "" {
+		rule := rules.MatchingRule(key.OrderingRule)
+		if rule == nil || rule.Type != MatchingRuleOrdering {
+			return nil
+		}
+		return rule
+	}
+	return rules.OrderingRule(key.AttributeType)
+}
+
+// Returns the least normalized value of the entry's attribute according to the rule,
+// or nil if it has no valid value
+func sortKeyValue(entry *SearchResultEntry, attribute string, rule *MatchingRule, rules MatchingRuleResolver) *string {
+	var least *string
+	for _, attr := range entry.Attributes {
+		if !sameAttributeType(rules, attr.Description, attribute) {
+			continue
+		}
+		for _, v := range attr.Values {
+			n, err := rule.normalize(v)
+			if err != nil {
+				continue
+			}
+			if least == nil || rule.compare(n, *least) < 0 {
+				least = &n
+			}
+		}
+	}
+	return least
+}
+
+// An entry with the least normalized value of each sort key
+type sortedEntry struct {
+	entry *SearchResultEntry
+	// Values of the sort keys, nil if the entry has no valid value for the key
+	values []*string
+}
+
+// Sort the entries as specified by the sort keys of a Server-Side Sorting request control (RFC 2891),
+// comparing the values with the matching rules, or DefaultMatchingRules if rules is nil.
+//
+// The entries are sorted by the least value of each key's attribute,
+// and those without a valid value are sorted after all others.
+// Only the attributes of the SearchResultEntry values are considered,
+// so the sort attributes must be among the attributes returned.
+// Keys whose attribute has no ordering rule, and no ordering rule of their own, cannot be sorted by.
+//
+// Returns the response control to send with the SearchResultDone response.
+// If a key has no usable matching rule, its result is inappropriateMatching
+// and the entries are left unsorted.
+func SortEntries(entries []*SearchResultEntry, keys []SortKey, rules MatchingRuleResolver) *SortResponseControl {
+	if rules == nil {
+		rules = DefaultMatchingRules
+	}
+	keyRules := make([]*MatchingRule, len(keys))
+	for i := range keys {
+		keyRules[i] = sortKeyRule(&keys[i], rules)
+		if keyRules[i] == nil {
+			return &SortResponseControl{Result: ResultInappropriateMatching, AttributeType: keys[i].AttributeType}
+		}
+	}
+	sorted := make([]sortedEntry, len(entries))
+	for i, entry := range entries {
+		sorted[i] = sortedEntry{entry: entry, values: make([]*string, len(keys))}
+		for k := range keys {
+			sorted[i].values[k] = sortKeyValue(entry, keys[k].AttributeType, keyRules[k], rules)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		for k, key := range keys {
+			a, b := sorted[i].values[k], sorted[j].values[k]
+			var c int
+			switch {
+			case a == nil && b == nil:
+				continue
+			case a == nil:
+				c = 1
+			case b == nil:
+				c = -1
+			default:
+				c = keyRules[k].compare(*a, *b)
+			}
+			if c == 0 {
+				continue
+			}
+			if key.ReverseOrder {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+	for i := range sorted {
+		entries[i] = sorted[i].entry
+	}
+	return &SortResponseControl{Result: ResultSuccess}
+}
+
+// The sort key attributes requested from a wrapped handler
+// in addition to the attributes requested by the client
+type sortKeyAttributes struct {
+	// Sort key attributes the client did not request
+	added []string
+	// Whether the client requested attribute descriptions only
+	typesOnly bool
+	rules     MatchingRuleResolver
+}
+
+// Returns the Search request to pass to the wrapped handler so that the entries it returns
+// have the values of the sort keys, and the attributes to remove from them for the client.
+func requestSortKeyAttributes(req *SearchRequest, keys []SortKey, rules MatchingRuleResolver) (*SearchRequest, *sortKeyAttributes) {
+	if rules == nil {
+		rules = DefaultMatchingRules
+	}
+	a := &sortKeyAttributes{typesOnly: req.TypesOnly, rules: rules}
+	// An empty selection or "*" selects all attributes
+	all := len(req.Attributes) == 0
+	for _, attr := range req.Attributes {
+		all = all || attr == "*"
+	}
+	if !all {
+		for _, key := range keys {
+			requested := false
+			for _, attr := range req.Attributes {
+				requested = requested || sameAttributeType(rules, attr, key.AttributeType)
+			}
+			if !requested {
+				a.added = append(a.added, key.AttributeType)
+			}
+		}
+	}
+	if len(a.added) == 0 && !a.typesOnly {
+		return req, a
+	}
+	wrapped := *req
+	wrapped.Attributes = append(req.Attributes[:len(req.Attributes):len(req.Attributes)], a.added...)
+	wrapped.TypesOnly = false
+	return &wrapped, a
+}
+
+// Returns the entry with the attributes requested by the client,
+// i.e. without the added sort key attributes, and without values if only types were requested
+func (a *sortKeyAttributes) clientEntry(entry *SearchResultEntry) *SearchResultEntry {
+	if len(a.added) == 0 && !a.typesOnly {
+		return entry
+	}
+	res := &SearchResultEntry{ObjectName: entry.ObjectName}
+	for _, attr := range entry.Attributes {
+		added := false
+		for _, key := range a.added {
+			added = added || sameAttributeType(a.rules, attr.Description, key)
+		}
+		if added {
+			continue
+		}
+		if a.typesOnly {
+			attr = Attribute{Description: attr.Description}
+		}
+		res.Attributes = append(res.Attributes, attr)
+	}
+	return res
+}
+
+// Returns a middleware that implements the Server-Side Sorting control (RFC 2891) for Search requests,
+// comparing values with the matching rules, or DefaultMatchingRules if rules is nil.
+//
+// The sort key attributes are requested from the wrapped handler with the attributes of the Search request,
+// and removed from the entries if the client did not request them.
+// The entries returned by the wrapped handler are held back and sorted with SortEntries,
+// then sent before its references and its SearchResultDone response,
+// which carries the sort response control.
+// If the entries cannot be sorted and the control is critical,
+// the search fails with unavailableCriticalExtension; otherwise they are sent unsorted.
+// The control is registered as supported, so the wrapped handler need not know about it.
+//
+// To combine sorting with PagedResults, chain PagedResults first so that the pages are sorted.
+func ServerSideSort(rules MatchingRuleResolver) Middleware {
+	return func(next Handler) Handler {
+		return &sortHandler{Handler: next, rules: rules}
+	}
+}
+
+// Handler that sorts the entries of the wrapped handler's Search operations
+type sortHandler struct {
+	Handler
+	rules MatchingRuleResolver
+}
+
+// The responses of a Search operation being sorted
+type sortedSearch struct {
+	entries []*SearchResultEntry
+	// Controls of the entries that have them
+	entryControls map[*SearchResultEntry][]Control
+	references    []heldResponse
+	done          *heldResponse
+}
+
+// Keeps the responses of the wrapped handler instead of sending them
+func (s *sortedSearch) collect(controls []Control, rtype BerType, res Encodable) bool {
+	switch rtype {
+	case TypeSearchResultEntryOp:
+		entry, ok := res.(*SearchResultEntry)
+		if !ok {
+			var err error
+			if entry, err = GetSearchResultEntry(res.Encode()); err != nil {
+				return false
+			}
+		}
+		if len(controls) > 0 {
+			if s.entryControls == nil {
+				s.entryControls = make(map[*SearchResultEntry][]Control)
+			}
+			s.entryControls[entry] = controls
+		}
+		s.entries = append(s.entries, entry)
+	case TypeSearchResultDoneOp:
+		s.done = &heldResponse{controls, rtype, res}
+	default:
+		s.references = append(s.references, heldResponse{controls, rtype, res})
+	}
+	return false
+}
+
+func (h *sortHandler) Search(ctx context.Context, conn *Conn, msg *Message, req *SearchRequest) {
+	control, ok := msg.DecodedControl(OIDServerSideSortRequest).(*SortRequestControl)
+	if !ok {
+		h.Handler.Search(ctx, conn, msg, req)
+		return
+	}
+	wrapped, attributes := requestSortKeyAttributes(req, control.Keys, h.rules)
+	search := &sortedSearch{}
+	pop := conn.pushResponseFilter(msg.MessageID, search.collect)
+	h.Handler.Search(ctx, conn, msg, wrapped)
+	pop()
+	if ctx.Err() == context.Canceled {
+		// Abandoned or disconnected
+		return
+	}
+	response := SortEntries(search.entries, control.Keys, h.rules)
+	controls := []Control{NewControl(response, false)}
+	if response.Result != ResultSuccess && msg.GetControl(OIDServerSideSortRequest).Criticality {
+		conn.SendResult(msg.MessageID, controls, TypeSearchResultDoneOp,
+			ResultUnavailableCriticalExtension.AsResult("cannot sort by attribute "+response.AttributeType))
+		return
+	}
+	for _, entry := range search.entries {
+		if err := conn.SendResult(msg.MessageID, search.entryControls[entry], TypeSearchResultEntryOp,
+			attributes.clientEntry(entry)); err != nil {
+			return
+		}
+	}
+	for _, r := range search.references {
+		if err := conn.SendResult(msg.MessageID, r.controls, r.rtype, r.res); err != nil {
+			return
+		}
+	}
+	var res Encodable = ResultSuccess.AsResult("")
+	if search.done != nil {
+		controls = append(controls, search.done.controls...)
+		res = search.done.res
+	}
+	conn.SendResult(msg.MessageID, controls, TypeSearchResultDoneOp, res)
+}
+
+func (h *sortHandler) RegisterControls(registry *ControlRegistry) {
+	registry.Register(OIDServerSideSortRequest, DecodeSortRequestControl, TypeSearchRequestOp)
+	if p, ok := h.Handler.(ControlProvider); ok {
+		p.RegisterControls(registry)
+	}
+}
+
+func (h *sortHandler) AddToRootDSE(dse *RootDSE) {
+	if p, ok := h.Handler.(RootDSEProvider); ok {
+		p.AddToRootDSE(dse)
+	}
+}
+
+func (h *sortHandler) Unwrap() Handler {
+	return h.Handler
+}
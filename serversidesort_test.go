@@ -0,0 +1,207 @@
+package ldapserver_test
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/merlinz01/ldapserver"
+)
+
+func TestSortControls(t *testing.T) {
+	req := &ldapserver.SortRequestControl{Keys: []ldapserver.SortKey{
+		{AttributeType: "sn"},
+		{AttributeType: "uidNumber", OrderingRule: "integerOrderingMatch", ReverseOrder: true},
+	}}
+	decoded, err := ldapserver.DecodeSortRequestControl(req.EncodeValue())
+	if err != nil {
+		t.Fatal("Error decoding control:", err)
+	}
+	if !reflect.DeepEqual(decoded, req) {
+		t.Fatal("wrong decoded request control", decoded)
+	}
+	res := &ldapserver.SortResponseControl{Result: ldapserver.ResultInappropriateMatching, AttributeType: "sn"}
+	decoded, err = ldapserver.DecodeSortResponseControl(res.EncodeValue())
+	if err != nil {
+		t.Fatal("Error decoding control:", err)
+	}
+	if *decoded.(*ldapserver.SortResponseControl) != *res {
+		t.Fatal("wrong decoded response control", decoded)
+	}
+	for _, value := range []string{"", "\x30\x00", "\x30\x02\x04\x00", "\x30\x05\x30\x03\x02\x01\x01"} {
+		if _, err := ldapserver.DecodeSortRequestControl(value); err == nil {
+			t.Fatalf("invalid control value %q accepted", value)
+		}
+	}
+}
+
+func TestSortEntries(t *testing.T) {
+	entry := func(name string, attrs ...ldapserver.Attribute) *ldapserver.SearchResultEntry {
+		return &ldapserver.SearchResultEntry{ObjectName: name, Attributes: attrs}
+	}
+	names := func(entries []*ldapserver.SearchResultEntry) []string {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.ObjectName)
+		}
+		return names
+	}
+	entries := []*ldapserver.SearchResultEntry{
+		entry("a", ldapserver.Attribute{Description: "sn", Values: []string{"Smith"}},
+			ldapserver.Attribute{Description: "uidNumber", Values: []string{"10"}}),
+		entry("b"),
+		entry("c", ldapserver.Attribute{Description: "SN", Values: []string{"doe", "zed"}},
+			ldapserver.Attribute{Description: "uidNumber", Values: []string{"9"}}),
+		entry("d", ldapserver.Attribute{Description: "sn", Values: []string{"smith"}},
+			ldapserver.Attribute{Description: "uidNumber", Values: []string{"100"}}),
+	}
+	for _, tc := range []struct {
+		keys  []ldapserver.SortKey
+		order []string
+	}{
+		{[]ldapserver.SortKey{{AttributeType: "sn"}}, []string{"c", "a", "d", "b"}},
+		{[]ldapserver.SortKey{{AttributeType: "sn", ReverseOrder: true}}, []string{"b", "a", "d", "c"}},
+		{[]ldapserver.SortKey{{AttributeType: "sn"}, {AttributeType: "uidNumber", OrderingRule: "integerOrderingMatch", ReverseOrder: true}},
+			[]string{"c", "d", "a", "b"}},
+		{[]ldapserver.SortKey{{AttributeType: "uidNumber", OrderingRule: "2.5.13.15"}}, []string{"c", "a", "d", "b"}},
+	} {
+		res := ldapserver.SortEntries(entries, tc.keys, nil)
+		if res.Result != ldapserver.ResultSuccess || !slicesEqual(names(entries), tc.order) {
+			t.Fatal("wrong order", tc.keys, res.Result, names(entries))
+		}
+	}
+	before := names(entries)
+	for _, rule := range []string{"unknownMatch", "caseIgnoreSubstringsMatch"} {
+		res := ldapserver.SortEntries(entries, []ldapserver.SortKey{{AttributeType: "sn", OrderingRule: rule}}, nil)
+		if res.Result != ldapserver.ResultInappropriateMatching || res.AttributeType != "sn" || !slicesEqual(names(entries), before) {
+			t.Fatal("unusable ordering rule not rejected", rule, res)
+		}
+	}
+	// Attributes without an ordering rule are not sorted by their equality rule
+	rules := ldapserver.NewMatchingRuleRegistry()
+	rules.Register(ldapserver.CaseIgnoreMatch)
+	if err := rules.SetAttributeRules("sn", "caseIgnoreMatch", "", ""); err != nil {
+		t.Fatal("Error setting rules:", err)
+	}
+	res := ldapserver.SortEntries(entries, []ldapserver.SortKey{{AttributeType: "sn"}}, rules)
+	if res.Result != ldapserver.ResultInappropriateMatching || res.AttributeType != "sn" || !slicesEqual(names(entries), before) {
+		t.Fatal("attribute without ordering rule not rejected", res)
+	}
+}
+
+func TestServerSideSort(t *testing.T) {
+	h := newTestDirectory(t)
+	for _, e := range []struct{ uid, sn string }{{"asmith", "Smith"}, {"bbrown", "brown"}} {
+		if err := h.AddEntry(ldapserver.NewEntry(ldapserver.MustParseDN("uid="+e.uid+",ou=users,dc=example,dc=com"),
+			ldapserver.Attribute{Description: "objectClass", Values: []string{"person"}},
+			ldapserver.Attribute{Description: "uid", Values: []string{e.uid}},
+			ldapserver.Attribute{Description: "sn", Values: []string{e.sn}})); err != nil {
+			t.Fatal("Error adding entry:", err)
+		}
+	}
+	conn := startTestServer(t, ldapserver.Chain(h, ldapserver.PagedResults, ldapserver.ServerSideSort(nil)))
+	search := (&ldapserver.SearchRequest{
+		BaseObject: "ou=users,dc=example,dc=com", Scope: ldapserver.SearchScopeSingleLevel, Attributes: []string{"sn"},
+	}).Encode()
+	sortBySN := ldapserver.NewControl(&ldapserver.SortRequestControl{Keys: []ldapserver.SortKey{{AttributeType: "sn"}}}, true)
+	dns := func(responses []*ldapserver.Message) []string {
+		var dns []string
+		for _, res := range responses {
+			if res.ProtocolOp.Type == ldapserver.TypeSearchResultEntryOp {
+				entry, err := ldapserver.GetSearchResultEntry(res.ProtocolOp.Data)
+				if err != nil {
+					t.Fatal("Error parsing entry:", err)
+				}
+				dns = append(dns, entry.ObjectName)
+			}
+		}
+		return dns
+	}
+	sortResult := func(msg *ldapserver.Message) *ldapserver.SortResponseControl {
+		c := msg.GetControl(ldapserver.OIDServerSideSortResponse)
+		if c == nil {
+			t.Fatal("no sort response control")
+		}
+		res, err := ldapserver.DecodeSortResponseControl(c.ControlValue)
+		if err != nil {
+			t.Fatal("Error decoding control:", err)
+		}
+		return res.(*ldapserver.SortResponseControl)
+	}
+
+	responses := doSearchWithControls(t, conn, 1, search, sortBySN)
+	if got := dns(responses); !slicesEqual(got, []string{"uid=bbrown,ou=users,dc=example,dc=com",
+		"uid=jdoe,ou=users,dc=example,dc=com", "uid=asmith,ou=users,dc=example,dc=com"}) {
+		t.Fatal("wrong order", got)
+	}
+	if res := sortResult(responses[len(responses)-1]); res.Result != ldapserver.ResultSuccess {
+		t.Fatal("wrong sort result", res.Result)
+	}
+
+	// Sorted pages
+	responses = doSearchWithControls(t, conn, 2, search, sortBySN,
+		ldapserver.NewControl(&ldapserver.PagedResultsControl{Size: 2}, true))
+	if got := dns(responses); !slicesEqual(got, []string{"uid=bbrown,ou=users,dc=example,dc=com", "uid=jdoe,ou=users,dc=example,dc=com"}) {
+		t.Fatal("wrong first page", got)
+	}
+
+	unsortable := ldapserver.SortRequestControl{Keys: []ldapserver.SortKey{{AttributeType: "sn", OrderingRule: "unknownMatch"}}}
+	responses = doSearchWithControls(t, conn, 3, search, ldapserver.NewControl(&unsortable, true))
+	done := responses[len(responses)-1]
+	result, err := ldapserver.GetResult(done.ProtocolOp.Data)
+	if err != nil {
+		t.Fatal("Error parsing result:", err)
+	}
+	if len(responses) != 1 || result.ResultCode != ldapserver.ResultUnavailableCriticalExtension ||
+		sortResult(done).Result != ldapserver.ResultInappropriateMatching {
+		t.Fatal("critical unsortable request not rejected", result.ResultCode)
+	}
+	responses = doSearchWithControls(t, conn, 4, search, ldapserver.NewControl(&unsortable, false))
+	if len(dns(responses)) != 3 || sortResult(responses[len(responses)-1]).Result != ldapserver.ResultInappropriateMatching {
+		t.Fatal("non-critical unsortable request not returned unsorted")
+	}
+
+	// The sort attribute is requested from the handler even if the client does not request it
+	for i, req := range []*ldapserver.SearchRequest{
+		{BaseObject: "ou=users,dc=example,dc=com", Scope: ldapserver.SearchScopeSingleLevel, Attributes: []string{"uid"}},
+		{BaseObject: "ou=users,dc=example,dc=com", Scope: ldapserver.SearchScopeSingleLevel, Attributes: []string{"sn"}, TypesOnly: true},
+	} {
+		responses = doSearchWithControls(t, conn, ldapserver.MessageID(5+i), req.Encode(), sortBySN)
+		if got := dns(responses); !slicesEqual(got, []string{"uid=bbrown,ou=users,dc=example,dc=com",
+			"uid=jdoe,ou=users,dc=example,dc=com", "uid=asmith,ou=users,dc=example,dc=com"}) {
+			t.Fatal("wrong order", req.Attributes, got)
+		}
+		for _, res := range responses[:len(responses)-1] {
+			entry, err := ldapserver.GetSearchResultEntry(res.ProtocolOp.Data)
+			if err != nil {
+				t.Fatal("Error parsing entry:", err)
+			}
+			if len(entry.Attributes) != 1 || entry.Attributes[0].Description != req.Attributes[0] ||
+				req.TypesOnly != (len(entry.Attributes[0].Values) == 0) {
+				t.Fatal("wrong attributes", req.Attributes, entry.Attributes)
+			}
+		}
+	}
+}
+
+// Send a Search request with controls and return all the responses
+func doSearchWithControls(t *testing.T, conn net.Conn, id ldapserver.MessageID, search []byte, controls ...ldapserver.Control) []*ldapserver.Message {
+	t.Helper()
+	msg := ldapserver.Message{MessageID: id, Controls: controls}
+	msg.ProtocolOp.Type = ldapserver.TypeSearchRequestOp
+	msg.ProtocolOp.Data = search
+	if _, err := conn.Write(msg.EncodeWithHeader()); err != nil {
+		t.Fatal("Error sending request:", err)
+	}
+	var responses []*ldapserver.Message
+	for {
+		res, err := ldapserver.ReadLDAPMessage(conn)
+		if err != nil {
+			t.Fatal("Error reading response:", err)
+		}
+		responses = append(responses, res)
+		if res.ProtocolOp.Type == ldapserver.TypeSearchResultDoneOp {
+			return responses
+		}
+	}
+}
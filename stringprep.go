@@ -0,0 +1,78 @@
+package ldapserver
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Prepare a string for matching as specified by RFC 4518 section 2:
+// characters with no matching significance are removed, whitespace and separators
+// are mapped to SPACE, case is folded if caseFold is true, prohibited characters
+// are rejected and insignificant spaces are removed.
+// Unicode normalization (NFKC) is not applied.
+//
+// Instead of the surrounding and doubled inner spaces of section 2.6.1, leading and trailing
+// spaces are removed and inner runs of spaces are replaced with a single space,
+// which compares values the same way. A non-empty value with only spaces is prepared
+// to a single space, so it is equal to other such values but not to the empty string.
+func prepareString(value string, caseFold bool) (string, error) {
+	if !utf8.ValidString(value) {
+		return "", ErrInvalidAttributeValue.WithInfo("reason", "invalid UTF-8")
+	}
+	var b strings.Builder
+	b.Grow(len(value))
+	space, onlySpaces := false, false
+	for _, r := range value {
+		switch {
+		case mapsToNothing(r):
+			continue
+		case mapsToSpace(r):
+			space = b.Len() > 0
+			onlySpaces = b.Len() == 0
+			continue
+		case prohibited(r):
+			return "", ErrInvalidAttributeValue.WithInfo("prohibited character", fmt.Sprintf("%U", r))
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		if caseFold {
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	if onlySpaces {
+		return " ", nil
+	}
+	return b.String(), nil
+}
+
+// Returns true if the character is mapped to nothing (RFC 4518 section 2.2)
+func mapsToNothing(r rune) bool {
+	switch {
+	case r == 0x00AD, r == 0x034F, r == 0x1806, r == 0x200B, r == 0xFFFC,
+		r >= 0x180B && r <= 0x180D,
+		r >= 0xFE00 && r <= 0xFE0F:
+		return true
+	case r <= 0x08, r >= 0x0E && r <= 0x1F, r >= 0x7F && r <= 0x84, r >= 0x86 && r <= 0x9F:
+		// Control characters other than whitespace
+		return true
+	}
+	return false
+}
+
+// Returns true if the character is mapped to SPACE (RFC 4518 section 2.2)
+func mapsToSpace(r rune) bool {
+	return r >= 0x09 && r <= 0x0D || r == 0x85 || r == 0x2028 || r == 0x2029 || unicode.Is(unicode.Zs, r)
+}
+
+// Returns true if the character is prohibited (RFC 4518 section 2.4)
+func prohibited(r rune) bool {
+	return r == utf8.RuneError ||
+		unicode.Is(unicode.Co, r) ||
+		r >= 0xFDD0 && r <= 0xFDEF ||
+		r&0xFFFE == 0xFFFE
+}
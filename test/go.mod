@@ -1,6 +1,6 @@
 module github.com/merlinz01/ldapserver/test
 
-go 1.19
+go 1.21
 
 require "github.com/merlinz01/ldapserver" v0.0.0
 
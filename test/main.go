@@ -1,11 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
 	"time"
 
@@ -13,21 +13,27 @@ import (
 )
 
 func main() {
-	handler := &TestHandler{
-		abandonment: make(map[ldapserver.MessageID]bool),
-	}
+	handler := &TestHandler{}
 	server := ldapserver.NewLDAPServer(handler)
 	err := server.SetupTLS("cert.pem", "privkey.pem")
 	if err != nil {
 		log.Println("Error setting up TLS:", err)
 		return
 	}
+	// Slow down password guessing
+	server.MaxConnectionsPerIP = 16
+	server.BindRateLimitPerIP = ldapserver.RateLimit{Rate: 1, Burst: 10}
+	server.BindRateLimitPerDN = ldapserver.RateLimit{Rate: 0.2, Burst: 5}
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-signals
 		log.Println("Shutting down.")
-		server.Shutdown()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Println("Error shutting down:", err)
+		}
 	}()
 	log.Println("Serving.")
 	err = server.ListenAndServe("localhost:389")
@@ -39,8 +45,6 @@ func main() {
 
 type TestHandler struct {
 	ldapserver.BaseHandler
-	abandonment     map[ldapserver.MessageID]bool
-	abandonmentLock sync.Mutex
 }
 
 func getAuth(conn *ldapserver.Conn) ldapserver.DN {
@@ -58,16 +62,11 @@ func getAuth(conn *ldapserver.Conn) ldapserver.DN {
 	return auth
 }
 
-func (t *TestHandler) Abandon(conn *ldapserver.Conn, msg *ldapserver.Message, messageID ldapserver.MessageID) {
-	log.Println("Abandon request")
-	t.abandonmentLock.Lock()
-	if _, exists := t.abandonment[messageID]; exists {
-		t.abandonment[messageID] = true
-	}
-	t.abandonmentLock.Unlock()
+func (t *TestHandler) Abandon(ctx context.Context, conn *ldapserver.Conn, msg *ldapserver.Message, messageID ldapserver.MessageID) {
+	log.Println("Abandon request for message", messageID)
 }
 
-func (t *TestHandler) Add(conn *ldapserver.Conn, msg *ldapserver.Message, req *ldapserver.AddRequest) {
+func (t *TestHandler) Add(ctx context.Context, conn *ldapserver.Conn, msg *ldapserver.Message, req *ldapserver.AddRequest) {
 	log.Println("Add request")
 	auth := getAuth(conn)
 	if !auth.Equal(theOnlyAuthorizedUser) {
@@ -97,7 +96,7 @@ func (t *TestHandler) checkPassword(dn ldapserver.DN, password string) bool {
 	return false
 }
 
-func (t *TestHandler) Bind(conn *ldapserver.Conn, msg *ldapserver.Message, req *ldapserver.BindRequest) {
+func (t *TestHandler) Bind(ctx context.Context, conn *ldapserver.Conn, msg *ldapserver.Message, req *ldapserver.BindRequest) {
 	log.Println("Bind request")
 	res := &ldapserver.BindResult{}
 	if !conn.IsTLS() {
@@ -157,15 +156,8 @@ func (t *TestHandler) Bind(conn *ldapserver.Conn, msg *ldapserver.Message, req *
 	conn.SendResult(msg.MessageID, nil, ldapserver.TypeBindResponseOp, res)
 }
 
-func (t *TestHandler) Compare(conn *ldapserver.Conn, msg *ldapserver.Message, req *ldapserver.CompareRequest) {
+func (t *TestHandler) Compare(ctx context.Context, conn *ldapserver.Conn, msg *ldapserver.Message, req *ldapserver.CompareRequest) {
 	log.Println("Compare request")
-	// Allow cancellation
-	t.abandonment[msg.MessageID] = false
-	defer func() {
-		t.abandonmentLock.Lock()
-		delete(t.abandonment, msg.MessageID)
-		t.abandonmentLock.Unlock()
-	}()
 	auth := getAuth(conn)
 	if !auth.Equal(theOnlyAuthorizedUser) {
 		log.Println("Not an authorized connection!", auth)
@@ -174,12 +166,13 @@ func (t *TestHandler) Compare(conn *ldapserver.Conn, msg *ldapserver.Message, re
 				"the connection is not authorized to perform the requested operation"))
 		return
 	}
-	// Pretend to take a while
-	time.Sleep(time.Second * 2)
 	log.Println("Compare DN:", req.Object)
 	log.Println("  Attribute:", req.Attribute)
 	log.Println("  Value:", req.Value)
-	if t.abandonment[msg.MessageID] {
+	// Pretend to take a while
+	select {
+	case <-time.After(time.Second * 2):
+	case <-ctx.Done():
 		log.Println("Abandoning compare request")
 		return
 	}
@@ -187,7 +180,7 @@ func (t *TestHandler) Compare(conn *ldapserver.Conn, msg *ldapserver.Message, re
 		ldapserver.ResultCompareTrue.AsResult(""))
 }
 
-func (t *TestHandler) Delete(conn *ldapserver.Conn, msg *ldapserver.Message, dn string) {
+func (t *TestHandler) Delete(ctx context.Context, conn *ldapserver.Conn, msg *ldapserver.Message, dn string) {
 	log.Println("Delete request")
 	auth := getAuth(conn)
 	if !auth.Equal(theOnlyAuthorizedUser) {
@@ -202,7 +195,7 @@ func (t *TestHandler) Delete(conn *ldapserver.Conn, msg *ldapserver.Message, dn
 		ldapserver.ResultSuccess.AsResult("the entry was successfully deleted"))
 }
 
-func (t *TestHandler) Modify(conn *ldapserver.Conn, msg *ldapserver.Message, req *ldapserver.ModifyRequest) {
+func (t *TestHandler) Modify(ctx context.Context, conn *ldapserver.Conn, msg *ldapserver.Message, req *ldapserver.ModifyRequest) {
 	log.Println("Modify request")
 	auth := getAuth(conn)
 	if !auth.Equal(theOnlyAuthorizedUser) {
@@ -222,7 +215,7 @@ func (t *TestHandler) Modify(conn *ldapserver.Conn, msg *ldapserver.Message, req
 		ldapserver.ResultSuccess.AsResult("the entry was successfully modified"))
 }
 
-func (t *TestHandler) ModifyDN(conn *ldapserver.Conn, msg *ldapserver.Message, req *ldapserver.ModifyDNRequest) {
+func (t *TestHandler) ModifyDN(ctx context.Context, conn *ldapserver.Conn, msg *ldapserver.Message, req *ldapserver.ModifyDNRequest) {
 	log.Println("Modify DN request")
 	auth := getAuth(conn)
 	if !auth.Equal(theOnlyAuthorizedUser) {
@@ -240,16 +233,8 @@ func (t *TestHandler) ModifyDN(conn *ldapserver.Conn, msg *ldapserver.Message, r
 		ldapserver.ResultSuccess.AsResult("the entry was successfully modified"))
 }
 
-func (t *TestHandler) Search(conn *ldapserver.Conn, msg *ldapserver.Message, req *ldapserver.SearchRequest) {
+func (t *TestHandler) Search(ctx context.Context, conn *ldapserver.Conn, msg *ldapserver.Message, req *ldapserver.SearchRequest) {
 	log.Println("Search request")
-	// Allow cancellation
-	t.abandonment[msg.MessageID] = false
-	defer func() {
-		t.abandonmentLock.Lock()
-		delete(t.abandonment, msg.MessageID)
-		t.abandonmentLock.Unlock()
-	}()
-
 	auth := getAuth(conn)
 	if !auth.Equal(theOnlyAuthorizedUser) {
 		log.Println("Not an authorized connection!", auth)
@@ -285,12 +270,19 @@ func (t *TestHandler) Search(conn *ldapserver.Conn, msg *ldapserver.Message, req
 
 	// Return some entries
 	for i := 0; i < 5; i++ {
-		if t.abandonment[msg.MessageID] {
-			log.Println("Abandoning search request after", i, "requests")
+		// Pretend to take a while
+		select {
+		case <-time.After(time.Second * 3):
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				log.Println("Time limit exceeded after", i, "entries")
+				conn.SendResult(msg.MessageID, nil, ldapserver.TypeSearchResultDoneOp,
+					ldapserver.ResultTimeLimitExceeded.AsResult(""))
+				return
+			}
+			log.Println("Abandoning search request after", i, "entries")
 			return
 		}
-		// Pretend to take a while
-		time.Sleep(time.Second * 3)
 		entry := &ldapserver.SearchResultEntry{
 			ObjectName: fmt.Sprintf("uid=jdoe%d,%s", i, req.BaseObject),
 			Attributes: []ldapserver.Attribute{
@@ -307,17 +299,14 @@ func (t *TestHandler) Search(conn *ldapserver.Conn, msg *ldapserver.Message, req
 		ldapserver.ResultSuccess.AsResult(""))
 }
 
-func (t *TestHandler) Extended(conn *ldapserver.Conn, msg *ldapserver.Message, req *ldapserver.ExtendedRequest) {
+func (t *TestHandler) Extended(ctx context.Context, conn *ldapserver.Conn, msg *ldapserver.Message, req *ldapserver.ExtendedRequest) {
 	log.Println("Extended request with OID", req.Name)
-	switch req.Name {
-	case ldapserver.OIDPasswordModify:
-		log.Println("Password modify")
-		// Pretend to handle it
-		res := &ldapserver.ExtendedResult{}
-		res.ResultCode = ldapserver.ResultSuccess
-		conn.SendResult(msg.MessageID, nil, ldapserver.TypeExtendedResponseOp, res)
-	default:
-		log.Println("Passing request to base handler")
-		t.BaseHandler.Extended(conn, msg, req)
-	}
+	log.Println("Passing request to base handler")
+	t.BaseHandler.Extended(ctx, conn, msg, req)
+}
+
+func (t *TestHandler) ModifyPassword(ctx context.Context, conn *ldapserver.Conn, msg *ldapserver.Message, req *ldapserver.PasswordModifyRequest) (string, *ldapserver.Result) {
+	log.Println("Password modify for", req.UserIdentity)
+	// Pretend to handle it
+	return "", nil
 }
@@ -0,0 +1,349 @@
+package ldapserver
+
+import (
+	"context"
+)
+
+// The Virtual List View request control (draft-ietf-ldapext-ldapv3-vlv-09)
+//
+//	VirtualListViewRequest ::= SEQUENCE {
+//		beforeCount    INTEGER (0..maxInt),
+//		afterCount     INTEGER (0..maxInt),
+//		target       CHOICE {
+//			byOffset        [0] SEQUENCE {
+//				offset          INTEGER (1 .. maxInt),
+//				contentCount    INTEGER (0 .. maxInt) },
+//			greaterThanOrEqual [1] AssertionValue },
+//		contextID     OCTET STRING OPTIONAL }
+type VLVRequestControl struct {
+	// Number of entries to return before the target entry
+	BeforeCount uint32
+	// Number of entries to return after the target entry
+	AfterCount uint32
+	// Position of the target entry, starting at 1, when the target is byOffset
+	Offset uint32
+	// Client's estimate of the number of entries, or 0, when the target is byOffset
+	ContentCount uint32
+	// Whether the target is greaterThanOrEqual rather than byOffset
+	ByValue bool
+	// Assertion value of a greaterThanOrEqual target: the target entry is the first one
+	// whose value of the first sort key is greater than or equal to it
+	AssertionValue string
+	// Context ID returned by the server in a previous response, if any
+	ContextID string
+}
+
+func (c *VLVRequestControl) ControlType() OID {
+	return OIDVLVRequest
+}
+
+func (c *VLVRequestControl) EncodeValue() string {
+	data := BerEncodeInteger(int64(c.BeforeCount))
+	data = append(data, BerEncodeInteger(int64(c.AfterCount))...)
+	if c.ByValue {
+		data = append(data, BerEncodeElement(BerContextSpecificType(1, false), []byte(c.AssertionValue))...)
+	} else {
+		target := BerEncodeInteger(int64(c.Offset))
+		target = append(target, BerEncodeInteger(int64(c.ContentCount))...)
+		data = append(data, BerEncodeElement(BerContextSpecificType(0, true), target)...)
+	}
+	if c.ContextID != "" {
+		data = append(data, BerEncodeOctetString(c.ContextID)...)
+	}
+	return string(BerEncodeSequence(data))
+}
+
+// Decode the value of a Virtual List View request control
+func DecodeVLVRequestControl(value string) (TypedControl, error) {
+	seq, err := controlValueSequence(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(seq) < 3 || len(seq) > 4 {
+		return nil, ErrWrongSequenceLength.WithInfo("VirtualListViewRequest sequence length", len(seq))
+	}
+	c := &VLVRequestControl{}
+	if c.BeforeCount, err = getControlCount(seq[0], "VirtualListViewRequest beforeCount"); err != nil {
+		return nil, err
+	}
+	if c.AfterCount, err = getControlCount(seq[1], "VirtualListViewRequest afterCount"); err != nil {
+		return nil, err
+	}
+	switch seq[2].Type {
+	case BerContextSpecificType(0, true):
+		target, err := BerGetSequence(seq[2].Data)
+		if err != nil {
+			return nil, err
+		}
+		if len(target) != 2 {
+			return nil, ErrWrongSequenceLength.WithInfo("VirtualListViewRequest byOffset sequence length", len(target))
+		}
+		if c.Offset, err = getControlCount(target[0], "VirtualListViewRequest offset"); err != nil {
+			return nil, err
+		}
+		if c.ContentCount, err = getControlCount(target[1], "VirtualListViewRequest contentCount"); err != nil {
+			return nil, err
+		}
+	case BerContextSpecificType(1, false):
+		c.ByValue = true
+		c.AssertionValue = BerGetOctetString(seq[2].Data)
+	default:
+		return nil, ErrWrongElementType.WithInfo("VirtualListViewRequest target type", seq[2].Type)
+	}
+	if len(seq) > 3 {
+		if seq[3].Type != BerTypeOctetString {
+			return nil, ErrWrongElementType.WithInfo("VirtualListViewRequest contextID type", seq[3].Type)
+		}
+		c.ContextID = BerGetOctetString(seq[3].Data)
+	}
+	return c, nil
+}
+
+// The Virtual List View response control (draft-ietf-ldapext-ldapv3-vlv-09)
+//
+//	VirtualListViewResponse ::= SEQUENCE {
+//		targetPosition    INTEGER (0 .. maxInt),
+//		contentCount     INTEGER (0 .. maxInt),
+//		virtualListViewResult ENUMERATED {...},
+//		contextID     OCTET STRING OPTIONAL }
+type VLVResponseControl struct {
+	// Position of the target entry in the list, starting at 1
+	TargetPosition uint32
+	// Number of entries in the list
+	ContentCount uint32
+	// Result of the operation, e.g. ResultSuccess or ResultOffsetRangeError
+	Result LDAPResultCode
+	// Context ID for the client to send with its next request, if any
+	ContextID string
+}
+
+func (c *VLVResponseControl) ControlType() OID {
+	return OIDVLVResponse
+}
+
+func (c *VLVResponseControl) EncodeValue() string {
+	data := BerEncodeInteger(int64(c.TargetPosition))
+	data = append(data, BerEncodeInteger(int64(c.ContentCount))...)
+	data = append(data, BerEncodeEnumerated(int64(c.Result))...)
+	if c.ContextID != "" {
+		data = append(data, BerEncodeOctetString(c.ContextID)...)
+	}
+	return string(BerEncodeSequence(data))
+}
+
+// Decode the value of a Virtual List View response control
+func DecodeVLVResponseControl(value string) (TypedControl, error) {
+	seq, err := controlValueSequence(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(seq) < 3 || len(seq) > 4 {
+		return nil, ErrWrongSequenceLength.WithInfo("VirtualListViewResponse sequence length", len(seq))
+	}
+	c := &VLVResponseControl{}
+	if c.TargetPosition, err = getControlCount(seq[0], "VirtualListViewResponse targetPosition"); err != nil {
+		return nil, err
+	}
+	if c.ContentCount, err = getControlCount(seq[1], "VirtualListViewResponse contentCount"); err != nil {
+		return nil, err
+	}
+	if seq[2].Type != BerTypeEnumerated {
+		return nil, ErrWrongElementType.WithInfo("VirtualListViewResponse virtualListViewResult type", seq[2].Type)
+	}
+	result, err := BerGetEnumerated(seq[2].Data)
+	if err != nil {
+		return nil, err
+	}
+	c.Result = LDAPResultCode(result)
+	if len(seq) > 3 {
+		if seq[3].Type != BerTypeOctetString {
+			return nil, ErrWrongElementType.WithInfo("VirtualListViewResponse contextID type", seq[3].Type)
+		}
+		c.ContextID = BerGetOctetString(seq[3].Data)
+	}
+	return c, nil
+}
+
+// Returns the value of an INTEGER (0..maxInt) element
+func getControlCount(elem BerRawElement, description string) (uint32, error) {
+	if elem.Type != BerTypeInteger {
+		return 0, ErrWrongElementType.WithInfo(description+" type", elem.Type)
+	}
+	n, err := BerGetInteger(elem.Data)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n > 2147483647 {
+		return 0, ErrIntegerTooLarge.WithInfo(description, n)
+	}
+	return uint32(n), nil
+}
+
+// Returns the position of the target entry of a Virtual List View request in a list of n entries,
+// starting at 1, or n+1 if the target is after the last entry
+func vlvTargetPosition(entries []*SearchResultEntry, control *VLVRequestControl, keys []SortKey,
+	rules MatchingRuleResolver) (int, LDAPResultCode) {
+	n := len(entries)
+	if !control.ByValue {
+		offset, count := int(control.Offset), int(control.ContentCount)
+		switch {
+		case offset == 0:
+			return 0, ResultOffsetRangeError
+		case count == 0 || count == n:
+			// The offset is a position in the server's list
+		case offset >= count:
+			offset = n
+		case count > 1:
+			// Scale the position to the server's number of entries
+			offset = 1 + (offset-1)*(n-1)/(count-1)
+		}
+		return min(offset, n+1), ResultSuccess
+	}
+	if len(keys) == 0 {
+		return 0, ResultSortControlMissing
+	}
+	key := &keys[0]
+	rule := sortKeyRule(key, rules)
+	if rule == nil {
+		return 0, ResultInappropriateMatching
+	}
+	assertion, err := rule.normalize(control.AssertionValue)
+	if err != nil {
+		return 0, ResultInappropriateMatching
+	}
+	for i, entry := range entries {
+		value := sortKeyValue(entry, key.AttributeType, rule, rules)
+		// Entries without a value are sorted last, as if their value were the greatest
+		if key.ReverseOrder && value != nil && rule.compare(*value, assertion) <= 0 ||
+			!key.ReverseOrder && (value == nil || rule.compare(*value, assertion) >= 0) {
+			return i + 1, ResultSuccess
+		}
+	}
+	return n + 1, ResultSuccess
+}
+
+// Returns the window of a Virtual List View request (draft-ietf-ldapext-ldapv3-vlv-09)
+// over entries sorted by the keys of the Server-Side Sorting request control,
+// comparing the values with the matching rules, or DefaultMatchingRules if rules is nil.
+//
+// The window is made of the target entry and up to BeforeCount entries before it
+// and AfterCount entries after it. A byOffset target is scaled to the number of entries
+// if the client's ContentCount differs from it, and a greaterThanOrEqual target
+// is compared with the first sort key.
+//
+// Returns the entries of the window and the response control to send with the SearchResultDone response,
+// which has the target position and the number of entries.
+// If the target cannot be found, the response control has an error result and the window is empty.
+func VirtualListViewWindow(entries []*SearchResultEntry, control *VLVRequestControl, keys []SortKey,
+	rules MatchingRuleResolver) ([]*SearchResultEntry, *VLVResponseControl) {
+	if rules == nil {
+		rules = DefaultMatchingRules
+	}
+	n := len(entries)
+	response := &VLVResponseControl{ContentCount: uint32(n)}
+	target, result := vlvTargetPosition(entries, control, keys, rules)
+	if result != ResultSuccess {
+		response.Result = result
+		return nil, response
+	}
+	if n == 0 {
+		return nil, response
+	}
+	response.TargetPosition = uint32(target)
+	start := max(target-int(control.BeforeCount), 1)
+	end := min(target+int(control.AfterCount), n)
+	if start > end {
+		return nil, response
+	}
+	return entries[start-1 : end], response
+}
+
+// Returns a middleware that implements the Virtual List View control (draft-ietf-ldapext-ldapv3-vlv-09)
+// for Search requests, comparing values with the matching rules, or DefaultMatchingRules if rules is nil.
+//
+// A Virtual List View request must have a Server-Side Sorting control as well,
+// so chain ServerSideSort after this middleware.
+// As with ServerSideSort, the sort key attributes are requested from the wrapped handler.
+// The sorted entries returned by the wrapped handler are held back,
+// and the window computed by VirtualListViewWindow is sent with the SearchResultDone response,
+// which carries the Virtual List View response control. References are not returned.
+// No context is kept between requests, so the context ID is ignored.
+// The control is registered as supported, so the wrapped handler need not know about it.
+func VirtualListView(rules MatchingRuleResolver) Middleware {
+	return func(next Handler) Handler {
+		return &vlvHandler{Handler: next, rules: rules}
+	}
+}
+
+// Handler that returns a window of the wrapped handler's Search results
+type vlvHandler struct {
+	Handler
+	rules MatchingRuleResolver
+}
+
+func (h *vlvHandler) Search(ctx context.Context, conn *Conn, msg *Message, req *SearchRequest) {
+	control, ok := msg.DecodedControl(OIDVLVRequest).(*VLVRequestControl)
+	if !ok {
+		h.Handler.Search(ctx, conn, msg, req)
+		return
+	}
+	sortControl, ok := msg.DecodedControl(OIDServerSideSortRequest).(*SortRequestControl)
+	if !ok {
+		response := &VLVResponseControl{Result: ResultSortControlMissing}
+		conn.SendResult(msg.MessageID, []Control{NewControl(response, false)}, TypeSearchResultDoneOp,
+			ResultSortControlMissing.AsResult("the Virtual List View control requires a Server-Side Sorting control"))
+		return
+	}
+	wrapped, attributes := requestSortKeyAttributes(req, sortControl.Keys, h.rules)
+	search := &sortedSearch{}
+	pop := conn.pushResponseFilter(msg.MessageID, search.collect)
+	h.Handler.Search(ctx, conn, msg, wrapped)
+	pop()
+	if ctx.Err() == context.Canceled {
+		// Abandoned or disconnected
+		return
+	}
+	var done Encodable = ResultSuccess.AsResult("")
+	var doneControls []Control
+	if search.done != nil {
+		done, doneControls = search.done.res, search.done.controls
+	}
+	if code, ok := resultCodeOf(done); ok && code != ResultSuccess {
+		// Failed search, e.g. a critical sort that could not be done
+		response := &VLVResponseControl{Result: code}
+		conn.SendResult(msg.MessageID, append([]Control{NewControl(response, false)}, doneControls...),
+			TypeSearchResultDoneOp, done)
+		return
+	}
+	window, response := VirtualListViewWindow(search.entries, control, sortControl.Keys, h.rules)
+	if response.Result != ResultSuccess {
+		conn.SendResult(msg.MessageID, append([]Control{NewControl(response, false)}, doneControls...),
+			TypeSearchResultDoneOp, response.Result.AsResult("the Virtual List View target cannot be found"))
+		return
+	}
+	for _, entry := range window {
+		if err := conn.SendResult(msg.MessageID, search.entryControls[entry], TypeSearchResultEntryOp,
+			attributes.clientEntry(entry)); err != nil {
+			return
+		}
+	}
+	conn.SendResult(msg.MessageID, append([]Control{NewControl(response, false)}, doneControls...),
+		TypeSearchResultDoneOp, done)
+}
+
+func (h *vlvHandler) RegisterControls(registry *ControlRegistry) {
+	registry.Register(OIDVLVRequest, DecodeVLVRequestControl, TypeSearchRequestOp)
+	if p, ok := h.Handler.(ControlProvider); ok {
+		p.RegisterControls(registry)
+	}
+}
+
+func (h *vlvHandler) AddToRootDSE(dse *RootDSE) {
+	if p, ok := h.Handler.(RootDSEProvider); ok {
+		p.AddToRootDSE(dse)
+	}
+}
+
+func (h *vlvHandler) Unwrap() Handler {
+	return h.Handler
+}
@@ -0,0 +1,148 @@
+package ldapserver_test
+
+import (
+	"testing"
+
+	"github.com/merlinz01/ldapserver"
+)
+
+func TestVLVControls(t *testing.T) {
+	for _, req := range []*ldapserver.VLVRequestControl{
+		{BeforeCount: 1, AfterCount: 2, Offset: 5, ContentCount: 100},
+		{AfterCount: 20, ByValue: true, AssertionValue: "smi", ContextID: "ctx"},
+	} {
+		decoded, err := ldapserver.DecodeVLVRequestControl(req.EncodeValue())
+		if err != nil {
+			t.Fatal("Error decoding control:", err)
+		}
+		if *decoded.(*ldapserver.VLVRequestControl) != *req {
+			t.Fatal("wrong decoded request control", decoded)
+		}
+	}
+	res := &ldapserver.VLVResponseControl{TargetPosition: 3, ContentCount: 10, Result: ldapserver.ResultOffsetRangeError, ContextID: "ctx"}
+	decoded, err := ldapserver.DecodeVLVResponseControl(res.EncodeValue())
+	if err != nil {
+		t.Fatal("Error decoding control:", err)
+	}
+	if *decoded.(*ldapserver.VLVResponseControl) != *res {
+		t.Fatal("wrong decoded response control", decoded)
+	}
+	for _, value := range []string{"", "\x30\x00", "\x30\x06\x02\x01\x00\x02\x01\x00", "\x30\x08\x02\x01\x00\x02\x01\x00\x04\x00"} {
+		if _, err := ldapserver.DecodeVLVRequestControl(value); err == nil {
+			t.Fatalf("invalid control value %q accepted", value)
+		}
+	}
+}
+
+func TestVirtualListViewWindow(t *testing.T) {
+	var entries []*ldapserver.SearchResultEntry
+	for _, sn := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"} {
+		entries = append(entries, &ldapserver.SearchResultEntry{ObjectName: sn,
+			Attributes: []ldapserver.Attribute{{Description: "sn", Values: []string{sn}}}})
+	}
+	keys := []ldapserver.SortKey{{AttributeType: "sn"}}
+	for _, tc := range []struct {
+		control ldapserver.VLVRequestControl
+		window  string
+		target  uint32
+		result  ldapserver.LDAPResultCode
+	}{
+		{ldapserver.VLVRequestControl{BeforeCount: 1, AfterCount: 1, Offset: 5}, "def", 5, ldapserver.ResultSuccess},
+		{ldapserver.VLVRequestControl{BeforeCount: 2, AfterCount: 1, Offset: 1}, "ab", 1, ldapserver.ResultSuccess},
+		{ldapserver.VLVRequestControl{BeforeCount: 1, AfterCount: 5, Offset: 10}, "ij", 10, ldapserver.ResultSuccess},
+		{ldapserver.VLVRequestControl{BeforeCount: 1, Offset: 15}, "j", 11, ldapserver.ResultSuccess},
+		// Scaled to the number of entries
+		{ldapserver.VLVRequestControl{AfterCount: 1, Offset: 50, ContentCount: 100}, "ef", 5, ldapserver.ResultSuccess},
+		{ldapserver.VLVRequestControl{Offset: 100, ContentCount: 100}, "j", 10, ldapserver.ResultSuccess},
+		{ldapserver.VLVRequestControl{Offset: 0}, "", 0, ldapserver.ResultOffsetRangeError},
+		{ldapserver.VLVRequestControl{BeforeCount: 1, AfterCount: 1, ByValue: true, AssertionValue: "C"}, "bcd", 3, ldapserver.ResultSuccess},
+		{ldapserver.VLVRequestControl{AfterCount: 1, ByValue: true, AssertionValue: "cc"}, "de", 4, ldapserver.ResultSuccess},
+		{ldapserver.VLVRequestControl{BeforeCount: 1, ByValue: true, AssertionValue: "z"}, "j", 11, ldapserver.ResultSuccess},
+	} {
+		window, res := ldapserver.VirtualListViewWindow(entries, &tc.control, keys, nil)
+		got := ""
+		for _, e := range window {
+			got += e.ObjectName
+		}
+		if got != tc.window || res.TargetPosition != tc.target || res.ContentCount != 10 || res.Result != tc.result {
+			t.Fatal("wrong window", tc.control, got, res)
+		}
+	}
+	_, res := ldapserver.VirtualListViewWindow(entries, &ldapserver.VLVRequestControl{ByValue: true, AssertionValue: "a"}, nil, nil)
+	if res.Result != ldapserver.ResultSortControlMissing {
+		t.Fatal("missing sort keys not rejected", res.Result)
+	}
+}
+
+func TestVirtualListView(t *testing.T) {
+	h := newTestDirectory(t)
+	for _, e := range []struct{ uid, sn string }{{"asmith", "Smith"}, {"bbrown", "brown"}, {"cjones", "Jones"}} {
+		if err := h.AddEntry(ldapserver.NewEntry(ldapserver.MustParseDN("uid="+e.uid+",ou=users,dc=example,dc=com"),
+			ldapserver.Attribute{Description: "objectClass", Values: []string{"person"}},
+			ldapserver.Attribute{Description: "uid", Values: []string{e.uid}},
+			ldapserver.Attribute{Description: "sn", Values: []string{e.sn}})); err != nil {
+			t.Fatal("Error adding entry:", err)
+		}
+	}
+	conn := startTestServer(t, ldapserver.Chain(h, ldapserver.VirtualListView(nil), ldapserver.ServerSideSort(nil)))
+	search := (&ldapserver.SearchRequest{
+		BaseObject: "ou=users,dc=example,dc=com", Scope: ldapserver.SearchScopeSingleLevel, Attributes: []string{"uid", "sn"},
+	}).Encode()
+	sortBySN := ldapserver.NewControl(&ldapserver.SortRequestControl{Keys: []ldapserver.SortKey{{AttributeType: "sn"}}}, true)
+	// Returns the uids of the entries, the result code and the response control
+	view := func(id ldapserver.MessageID, controls ...ldapserver.Control) ([]string, ldapserver.LDAPResultCode, *ldapserver.VLVResponseControl) {
+		t.Helper()
+		var uids []string
+		responses := doSearchWithControls(t, conn, id, search, controls...)
+		for _, res := range responses[:len(responses)-1] {
+			entry, err := ldapserver.GetSearchResultEntry(res.ProtocolOp.Data)
+			if err != nil {
+				t.Fatal("Error parsing entry:", err)
+			}
+			uids = append(uids, entry.Attributes[0].Values[0])
+		}
+		done := responses[len(responses)-1]
+		result, err := ldapserver.GetResult(done.ProtocolOp.Data)
+		if err != nil {
+			t.Fatal("Error parsing result:", err)
+		}
+		c := done.GetControl(ldapserver.OIDVLVResponse)
+		if c == nil {
+			t.Fatal("no VLV response control")
+		}
+		response, err := ldapserver.DecodeVLVResponseControl(c.ControlValue)
+		if err != nil {
+			t.Fatal("Error decoding control:", err)
+		}
+		return uids, result.ResultCode, response.(*ldapserver.VLVResponseControl)
+	}
+
+	// Sorted by sn: brown, Doe, Jones, Smith
+	uids, code, res := view(1, sortBySN, ldapserver.NewControl(&ldapserver.VLVRequestControl{AfterCount: 1, Offset: 2}, true))
+	if code != ldapserver.ResultSuccess || !slicesEqual(uids, []string{"jdoe", "cjones"}) || res.TargetPosition != 2 || res.ContentCount != 4 {
+		t.Fatal("wrong window by offset", code, uids, res)
+	}
+	uids, code, res = view(2, sortBySN, ldapserver.NewControl(&ldapserver.VLVRequestControl{
+		BeforeCount: 1, AfterCount: 1, ByValue: true, AssertionValue: "k"}, true))
+	if code != ldapserver.ResultSuccess || !slicesEqual(uids, []string{"cjones", "asmith"}) || res.TargetPosition != 4 {
+		t.Fatal("wrong window by value", code, uids, res)
+	}
+	_, code, res = view(3, sortBySN, ldapserver.NewControl(&ldapserver.VLVRequestControl{}, true))
+	if code != ldapserver.ResultOffsetRangeError || res.Result != ldapserver.ResultOffsetRangeError {
+		t.Fatal("offset 0 not rejected", code, res.Result)
+	}
+	_, code, res = view(4, ldapserver.NewControl(&ldapserver.VLVRequestControl{Offset: 1}, true))
+	if code != ldapserver.ResultSortControlMissing || res.Result != ldapserver.ResultSortControlMissing {
+		t.Fatal("missing sort control not rejected", code, res.Result)
+	}
+
+	// The sort attribute is found without being requested by the client
+	search = (&ldapserver.SearchRequest{
+		BaseObject: "ou=users,dc=example,dc=com", Scope: ldapserver.SearchScopeSingleLevel, Attributes: []string{"uid"},
+	}).Encode()
+	uids, code, res = view(5, sortBySN, ldapserver.NewControl(&ldapserver.VLVRequestControl{
+		AfterCount: 1, ByValue: true, AssertionValue: "d"}, true))
+	if code != ldapserver.ResultSuccess || !slicesEqual(uids, []string{"jdoe", "cjones"}) || res.TargetPosition != 2 {
+		t.Fatal("wrong window by value without the sort attribute", code, uids, res)
+	}
+}